@@ -0,0 +1,83 @@
+// oswreplay feeds an OSW log recorded by turbine back through the SmartNet processor, and prints the control
+// messages it decodes as JSON lines.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/norasector/turbine/pkg/metrics"
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/op25/frame/smartnet"
+)
+
+func main() {
+	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}).Level(zerolog.InfoLevel)
+	logFile := flag.String("log", "", "OSW log to replay")
+	systemID := flag.Int("system", 0, "system ID to report messages under")
+	speed := flag.Float64("speed", 0, "replay speed relative to when OSWs were received, or 0 for as fast as possible")
+	debug := flag.Bool("debug", false, "log the processor's debug output")
+	flag.Parse()
+
+	if *logFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if *debug {
+		log.Logger = log.Logger.Level(zerolog.DebugLevel)
+	}
+
+	oswLog, err := smartnet.OpenOSWLog(*logFile)
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to open OSW log")
+	}
+	defer oswLog.Close()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	dataPacketChan := make(chan op25.OSWPacket)
+	updateChan := make(chan op25.DataPacket, 32)
+	enc := json.NewEncoder(os.Stdout)
+
+	procCtx, stopProc := context.WithCancel(ctx)
+	proc := smartnet.NewProcessor(*systemID, dataPacketChan, updateChan, metrics.NewRegistry(), log.Logger,
+		smartnet.WithMessageHandler(func(msg op25.ControlMessage) {
+			if err := enc.Encode(msg); err != nil {
+				log.Error().Err(err).Msg("failed to write message")
+			}
+		}))
+
+	eg, egCtx := errgroup.WithContext(procCtx)
+	eg.Go(func() error {
+		return proc.Start(egCtx)
+	})
+	eg.Go(func() error {
+		// Grants are printed by the message handler, so updates are only drained.
+		for {
+			select {
+			case <-egCtx.Done():
+				return egCtx.Err()
+			case <-updateChan:
+			}
+		}
+	})
+	eg.Go(func() error {
+		// The processor finishes with each OSW before taking the next, so everything has been processed once
+		// the replay returns.
+		defer stopProc()
+		return smartnet.ReplayOSWLog(egCtx, oswLog, dataPacketChan, *speed)
+	})
+
+	if err := eg.Wait(); err != nil && err != context.Canceled {
+		log.Fatal().Err(err).Msg("replay failed")
+	}
+}
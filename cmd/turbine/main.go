@@ -6,32 +6,32 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"gopkg.in/yaml.v2"
 
 	influxdb2 "github.com/influxdata/influxdb-client-go"
-	"github.com/influxdata/influxdb-client-go/api"
 	"github.com/norasector/turbine/pkg/dsp/viz"
+	"github.com/norasector/turbine/pkg/metrics"
 	"github.com/norasector/turbine/pkg/turbine"
+	turbineAPI "github.com/norasector/turbine/pkg/turbine/api"
+	"github.com/norasector/turbine/pkg/turbine/call"
 	"github.com/norasector/turbine/pkg/turbine/config"
 	"github.com/norasector/turbine/pkg/turbine/device"
 	"github.com/norasector/turbine/pkg/turbine/device/file"
 	hackrfDevice "github.com/norasector/turbine/pkg/turbine/device/hackrf"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"github.com/norasector/turbine/pkg/turbine/device/iqstream"
 	"github.com/norasector/turbine/pkg/turbine/device/rtlsdr"
+	"github.com/norasector/turbine/pkg/turbine/device/rtltcp"
+	"github.com/norasector/turbine/pkg/turbine/device/sigmf"
+	"github.com/norasector/turbine/pkg/turbine/device/synthetic"
 	"github.com/norasector/turbine/pkg/turbine/output"
-	"github.com/norasector/turbine/pkg/util"
 	"github.com/samuel/go-hackrf/hackrf"
 	"golang.org/x/sync/errgroup"
 )
 
-const (
-	fileByteReadSize = 262144
-	fileReadDelay    = time.Microsecond * 16384
-)
-
 func main() {
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}).Level(zerolog.InfoLevel)
 	configFile := flag.String("config", "turbine.yaml", "YAML config file")
@@ -67,13 +67,34 @@ func main() {
 		if err != nil {
 			log.Fatal().Str("device", "rlsdr").Err(err).Msg("failed to initialize RTLSDR")
 		}
+	case "rtl_tcp":
+		log.Info().Str("device", "rtl_tcp").Str("address", opts.RTLTCP.Address).Msg("initializing device...")
+		device = rtltcp.NewRTLTCPDevice(opts.RTLTCP)
+	case "iq_stream":
+		log.Info().Str("device", "iq_stream").Str("address", opts.IQStream.Address).Msg("initializing device...")
+		device, err = iqstream.NewIQStreamDevice(opts.IQStream)
+		if err != nil {
+			log.Fatal().Str("device", "iq_stream").Err(err).Msg("failed to init IQ stream")
+		}
+	case "synthetic":
+		log.Info().Str("device", "synthetic").Msg("initializing device...")
+		device, err = synthetic.NewSyntheticDevice(opts.Synthetic)
+		if err != nil {
+			log.Fatal().Str("device", "synthetic").Err(err).Msg("failed to init synthetic device")
+		}
 	case "file":
 		log.Info().Str("device", "file").Msg("initializing device...")
-		// Note that if you read from a file, it expects to be captured from a HackRF -- you will need to modify inputs here to accommodate
-		// file dumps from other SDRs
-		device, err = file.NewFileDevice(opts.PlaybackLocation, fileByteReadSize, opts.SampleRate, opts.CenterFreq, fileReadDelay)
+		device, err = file.NewFileDevice(opts.PlaybackLocation, file.Options{
+			Format:     iq.Format(opts.PlaybackFormat),
+			SampleRate: opts.SampleRate,
+			CenterFreq: opts.CenterFreq,
+			Start:      opts.PlaybackStart,
+			End:        opts.PlaybackEnd,
+			Loop:       opts.PlaybackLoop,
+			Fast:       opts.PlaybackFast,
+		})
 		if err != nil {
-			log.Fatal().Str("device", "rlsdr").Err(err).Msg("failed to init file reader")
+			log.Fatal().Str("device", "file").Err(err).Msg("failed to init file reader")
 		}
 		log.Logger = log.Logger.Level(zerolog.DebugLevel)
 	default:
@@ -83,23 +104,52 @@ func main() {
 		}
 		defer hackrf.Exit()
 
-		if opts.RecordLocation != "" {
-			device, err = hackrfDevice.NewRecordingHackRFDevice(opts.RecordLocation)
-			if err != nil {
-				log.Fatal().Str("device", "hackrf").Err(err).Msg("failed to create hackRF recording file device")
-			}
-
-		} else {
-			device, err = hackrfDevice.NewHackRFDevice()
-			if err != nil {
-				log.Fatal().Str("device", "hackrf").Err(err).Msg("failed to create hackRF device")
-			}
+		device, err = hackrfDevice.NewHackRFDevice()
+		if err != nil {
+			log.Fatal().Str("device", "hackrf").Err(err).Msg("failed to create hackRF device")
 		}
 	}
 
-	var influxWriteAPI api.WriteAPI = &util.MockWriteAPI{}
-	if opts.InfluxDB.Host != "" {
-		influxWriteAPI = influxdb2.NewClient(opts.InfluxDB.Host, "").WriteAPI(opts.InfluxDB.Organization, opts.InfluxDB.Bucket)
+	if centerFreq, sampleRate, ok := fixedTuning(device); ok {
+		if centerFreq != opts.CenterFreq || sampleRate != opts.SampleRate {
+			log.Info().Int("center_freq", centerFreq).Int("sample_rate", sampleRate).Msg("using the recording's center frequency and sample rate")
+		}
+		opts.CenterFreq = centerFreq
+		opts.SampleRate = sampleRate
+	}
+
+	if opts.RecordLocation != "" {
+		log.Info().Str("record_location", opts.RecordLocation).Msg("recording samples as SigMF")
+		device = sigmf.NewRecordingDevice(device, opts.RecordLocation)
+	}
+
+	metricsRegistry := metrics.NewRegistry()
+
+	audioOutputs := []turbine.AudioOutput{
+		output.NewTaggedOpusFrameUDPOutput(opts.OutputDestinations, opts.VoiceSampleOutputRate, metricsRegistry),
+	}
+
+	var eventSinks []turbine.EventSink
+	if opts.CallEvents.File != "" {
+		eventSinks = append(eventSinks, call.NewJSONLinesSink(opts.CallEvents.File))
+	}
+	if len(opts.CallEvents.Destinations) > 0 {
+		eventSinks = append(eventSinks, call.NewUDPSink(opts.CallEvents.Destinations))
+	}
+
+	if opts.Recorder.Directory != "" {
+		recorder, err := output.NewCallRecorder(output.CallRecorderOptions{
+			Directory:  opts.Recorder.Directory,
+			Format:     output.RecordingFormat(opts.Recorder.Format),
+			SampleRate: opts.VoiceSampleOutputRate,
+			MaxAge:     opts.Recorder.MaxAge,
+			MaxBytes:   opts.Recorder.MaxSizeMB * 1024 * 1024,
+		}, metricsRegistry)
+		if err != nil {
+			log.Fatal().Err(err).Msg("failed to create call recorder")
+		}
+		audioOutputs = append(audioOutputs, recorder)
+		eventSinks = append(eventSinks, recorder.EventSink())
 	}
 
 	vizServer := viz.NewServer(opts.VizServer.Port, opts.VizServer.UpdateInterval)
@@ -109,17 +159,22 @@ func main() {
 		turbine.Options{
 			CenterFreq:            opts.CenterFreq,
 			SampleRate:            opts.SampleRate,
+			PPM:                   opts.PPM,
+			FixedPPM:              opts.FixedPPM,
 			VoiceOutputSampleRate: opts.VoiceSampleOutputRate,
 			FrequencyTimeout:      opts.FrequencyTimeout,
 			Systems:               opts.Systems,
-			AudioOutputs: []turbine.AudioOutput{
-				output.NewTaggedOpusFrameUDPOutput(opts.OutputDestinations, opts.VoiceSampleOutputRate, influxWriteAPI),
-			},
-			RecordLocation:   opts.RecordLocation,
-			PlaybackLocation: opts.PlaybackLocation,
-		}, turbine.WithInfluxDB(
-			influxWriteAPI,
-		),
+			AudioOutputs:          audioOutputs,
+			EventSinks:            eventSinks,
+			IQOutputs:             opts.IQOutputs,
+			ChannelRecording:      opts.ChannelRecording,
+			Snapshots:             opts.Snapshots,
+			DeviceSettings:        opts.DeviceSettings,
+			FrontEndAGC:           opts.FrontEndAGC,
+			DeviceSupervisor:      opts.DeviceSupervisor,
+			RecordLocation:        opts.RecordLocation,
+			PlaybackLocation:      opts.PlaybackLocation,
+		}, turbine.WithMetrics(metricsRegistry),
 		turbine.WithImageServer(vizServer),
 		turbine.WithLogger(log.Logger))
 	if err != nil {
@@ -128,6 +183,28 @@ func main() {
 
 	eg, ctx := errgroup.WithContext(context.Background())
 
+	if opts.InfluxDB.Host != "" {
+		writeAPI := influxdb2.NewClient(opts.InfluxDB.Host, "").WriteAPI(opts.InfluxDB.Organization, opts.InfluxDB.Bucket)
+		exporter := metrics.NewInfluxExporter(metricsRegistry, writeAPI, opts.InfluxDB.Interval)
+		eg.Go(func() error {
+			return exporter.Run(ctx)
+		})
+	}
+
+	if opts.Prometheus.Port != 0 {
+		promServer := metrics.NewPrometheusServer(opts.Prometheus.Port, metricsRegistry)
+		eg.Go(func() error {
+			return promServer.Run(ctx)
+		})
+	}
+
+	if opts.API.Port != 0 {
+		apiServer := turbineAPI.NewServer(opts.API.Address, opts.API.Port, turbine)
+		eg.Go(func() error {
+			return apiServer.Run(ctx)
+		})
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
@@ -142,10 +219,24 @@ func main() {
 	})
 
 	eg.Go(func() error {
-		return turbine.Start(ctx)
+		if err := turbine.Start(ctx); err != nil {
+			return err
+		}
+		// The device ran out of samples, as it does at the end of a recording, so shut everything down.
+		return context.Canceled
 	})
 
 	if err := eg.Wait(); err != nil && err != context.Canceled {
 		log.Fatal().Err(err).Msg("exited program")
 	}
 }
+
+// fixedTuning returns the center frequency and sample rate the device's samples come with, if it decides them
+// rather than the config.
+func fixedTuning(d device.Device) (centerFreq, sampleRate int, ok bool) {
+	tuned, ok := d.(device.FixedTuning)
+	if !ok {
+		return 0, 0, false
+	}
+	return tuned.CenterFreq(), tuned.SampleRate(), true
+}
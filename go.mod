@@ -12,6 +12,7 @@ require (
 	github.com/racerxdl/segdsp v0.0.0-20190825170906-a855d00a24a8
 	github.com/rs/zerolog v1.26.1
 	github.com/samuel/go-hackrf v0.0.0-20171108215759-68a81b40b34d
+	golang.org/x/net v0.0.0-20210805182204-aaa1db679c0d
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
 	gonum.org/v1/gonum v0.9.3
 	gonum.org/v1/plot v0.10.0
@@ -40,7 +41,6 @@ require (
 	github.com/valyala/fasttemplate v1.1.0 // indirect
 	golang.org/x/crypto v0.0.0-20211215165025-cf75a172585e // indirect
 	golang.org/x/image v0.0.0-20210628002857-a66eb6448b8d // indirect
-	golang.org/x/net v0.0.0-20210805182204-aaa1db679c0d // indirect
 	golang.org/x/sys v0.0.0-20210809222454-d867a43fc93e // indirect
 	golang.org/x/text v0.3.6 // indirect
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
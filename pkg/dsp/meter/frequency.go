@@ -0,0 +1,58 @@
+package meter
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// FrequencyMeter measures the frequency error of an FM channel from the DC level of the discriminator's
+// output.  It passes its input through unchanged and belongs directly after the discriminator.
+type FrequencyMeter struct {
+	hzPerUnit float64
+	// offset is the float64 bits of the last segment's frequency error in Hz.  Accessed atomically.
+	offset uint64
+}
+
+// NewFrequencyMeter creates a meter for a discriminator whose output is gain per radian of phase change per
+// sample, at sampleRate.
+func NewFrequencyMeter(sampleRate int, gain float64) *FrequencyMeter {
+	return &FrequencyMeter{
+		hzPerUnit: float64(sampleRate) / (2 * math.Pi * gain),
+	}
+}
+
+// Invert negates the measured error, for chains whose spectrum is mirrored relative to the air.
+func (m *FrequencyMeter) Invert() *FrequencyMeter {
+	m.hzPerUnit = -m.hzPerUnit
+	return m
+}
+
+func (m *FrequencyMeter) PredictOutputSize(inputSize int) int {
+	return inputSize
+}
+
+func (m *FrequencyMeter) WorkBuffer(input, output []float32) int {
+	copy(output, input)
+	if len(input) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range input {
+		sum += float64(v)
+	}
+	atomic.StoreUint64(&m.offset, math.Float64bits(sum/float64(len(input))*m.hzPerUnit))
+	return len(input)
+}
+
+func (m *FrequencyMeter) Work(data []float32) []float32 {
+	ret := make([]float32, len(data))
+	m.WorkBuffer(data, ret)
+	return ret
+}
+
+// Offset returns the frequency error measured over the last segment, in Hz.  A positive error means the
+// signal is above the frequency it was tuned to.
+func (m *FrequencyMeter) Offset() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&m.offset))
+}
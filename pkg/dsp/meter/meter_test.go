@@ -0,0 +1,78 @@
+package meter
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"testing"
+
+	"github.com/norasector/turbine/pkg/dsp/demodulators/quad"
+)
+
+const sampleRate = 100000
+
+func tone(freq, amplitude float64, n int) []complex64 {
+	ret := make([]complex64, n)
+	for i := range ret {
+		ret[i] = complex64(cmplx.Rect(amplitude, 2*math.Pi*freq*float64(i)/sampleRate))
+	}
+	return ret
+}
+
+func TestSignalMeter(t *testing.T) {
+	const (
+		bandwidth = 12500
+		amplitude = 20.0
+		noiseStd  = 1.0 // per component
+	)
+	rng := rand.New(rand.NewSource(1))
+	m := NewSignalMeter(sampleRate, bandwidth, 128)
+
+	for seg := 0; seg < 20; seg++ {
+		samples := tone(1000, amplitude, 4096)
+		for i := range samples {
+			samples[i] += complex(float32(rng.NormFloat64()*noiseStd), float32(rng.NormFloat64()*noiseStd))
+		}
+		m.WorkBuffer(samples, make([]complex64, len(samples)))
+	}
+
+	got, ok := m.Measurement()
+	if !ok {
+		t.Fatal("expected a measurement")
+	}
+
+	fullScale := 128.0 * 128.0
+	noiseInChannel := 2 * noiseStd * noiseStd * float64(len(m.channelBins)) / fftSize
+	expectedRSSI := 10 * math.Log10((amplitude*amplitude+noiseInChannel)/fullScale)
+	expectedNoise := 10 * math.Log10(noiseInChannel/fullScale)
+	expectedSNR := 10 * math.Log10(amplitude*amplitude/noiseInChannel)
+
+	if math.Abs(got.RSSIDB-expectedRSSI) > 0.5 {
+		t.Errorf("rssi %.2f dB, expected %.2f dB", got.RSSIDB, expectedRSSI)
+	}
+	if math.Abs(got.NoiseFloorDB-expectedNoise) > 1.5 {
+		t.Errorf("noise floor %.2f dB, expected %.2f dB", got.NoiseFloorDB, expectedNoise)
+	}
+	if math.Abs(got.SNRDB-expectedSNR) > 1.5 {
+		t.Errorf("snr %.2f dB, expected %.2f dB", got.SNRDB, expectedSNR)
+	}
+}
+
+func TestFrequencyMeter(t *testing.T) {
+	gain := float32(sampleRate) / (4 * math.Pi * 4000)
+	demod := quad.MakeQuadDemod(gain)
+	m := NewFrequencyMeter(sampleRate, float64(gain))
+
+	samples := tone(-750, 10, 4096)
+	m.WorkBuffer(demod.Work(samples), make([]float32, len(samples)))
+	if offset := m.Offset(); math.Abs(offset+750) > 1 {
+		t.Errorf("offset %.1f Hz, expected -750 Hz", offset)
+	}
+
+	m.Invert()
+	demod = quad.MakeQuadDemod(gain)
+	m.WorkBuffer(demod.Work(samples), make([]float32, len(samples)))
+	if offset := m.Offset(); math.Abs(offset-750) > 1 {
+		t.Errorf("inverted offset %.1f Hz, expected 750 Hz", offset)
+	}
+}
@@ -0,0 +1,179 @@
+package meter
+
+import (
+	"math"
+	"math/cmplx"
+	"sort"
+	"sync"
+
+	"github.com/mjibson/go-dsp/fft"
+	"github.com/racerxdl/segdsp/dsp"
+)
+
+const (
+	// fftSize is the length of each FFT the spectrum is averaged over.
+	fftSize = 256
+	// smoothing is the weight given to each new segment's measurement.
+	smoothing = 0.25
+	// minPower keeps the logarithms finite when there is no signal at all.
+	minPower = 1e-20
+)
+
+// Measurement is the received signal quality of a channel.  Levels are in dB relative to the device's full
+// scale, so are comparable between channels on the same device but are not calibrated.
+type Measurement struct {
+	// RSSIDB is the power within the channel bandwidth.
+	RSSIDB float64 `json:"rssi_db"`
+	// NoiseFloorDB is the noise power within the channel bandwidth, estimated from the spectrum beside the
+	// channel.
+	NoiseFloorDB float64 `json:"noise_floor_db"`
+	SNRDB        float64 `json:"snr_db"`
+}
+
+// SignalMeter measures the power and noise floor of a channel centered at zero.  It passes its input through
+// unchanged, so can be dropped anywhere into a chain.  The sample rate should leave room either side of the
+// channel for the noise floor to be seen.
+type SignalMeter struct {
+	fullScale float64
+	window    []float64
+	// norm scales squared FFT bins so that they sum to the mean power of the input.
+	norm         float64
+	channelBins  []int
+	noiseBins    []int
+	fftBuf       []complex128
+	psd          []float64
+	noiseScratch []float64
+
+	mu          sync.Mutex
+	measured    bool
+	signalPower float64
+	noisePower  float64
+}
+
+// NewSignalMeter creates a meter for a channel of channelBandwidth Hz.  fullScale is the sample magnitude
+// that is 0 dB.
+func NewSignalMeter(sampleRate, channelBandwidth int, fullScale float64) *SignalMeter {
+	m := &SignalMeter{
+		fullScale:    fullScale,
+		window:       dsp.BlackmanHarris(fftSize, 92),
+		fftBuf:       make([]complex128, fftSize),
+		psd:          make([]float64, fftSize),
+		noiseScratch: make([]float64, 0, fftSize),
+	}
+
+	var windowPower float64
+	for _, w := range m.window {
+		windowPower += w * w
+	}
+	m.norm = 1 / (fftSize * windowPower)
+
+	binWidth := float64(sampleRate) / fftSize
+	halfChannel := float64(channelBandwidth) / 2
+	// Stay within the flat part of the filters ahead of the meter, and leave a guard band around the channel.
+	noiseLow, noiseHigh := halfChannel*1.25, float64(sampleRate)/4
+	if noiseHigh <= noiseLow {
+		noiseHigh = float64(sampleRate) / 2
+	}
+
+	for k := 0; k < fftSize; k++ {
+		freq := float64(k) * binWidth
+		if k > fftSize/2 {
+			freq = float64(k-fftSize) * binWidth
+		}
+		freq = math.Abs(freq)
+		switch {
+		case freq <= halfChannel:
+			m.channelBins = append(m.channelBins, k)
+		case freq > noiseLow && freq <= noiseHigh:
+			m.noiseBins = append(m.noiseBins, k)
+		}
+	}
+	return m
+}
+
+func (m *SignalMeter) PredictOutputSize(inputSize int) int {
+	return inputSize
+}
+
+func (m *SignalMeter) WorkBuffer(input, output []complex64) int {
+	copy(output, input)
+	m.measure(input)
+	return len(input)
+}
+
+func (m *SignalMeter) Work(data []complex64) []complex64 {
+	ret := make([]complex64, len(data))
+	m.WorkBuffer(data, ret)
+	return ret
+}
+
+func (m *SignalMeter) measure(input []complex64) {
+	frames := len(input) / fftSize
+	if frames == 0 {
+		return
+	}
+
+	for k := range m.psd {
+		m.psd[k] = 0
+	}
+	for f := 0; f < frames; f++ {
+		frame := input[f*fftSize : (f+1)*fftSize]
+		for i, v := range frame {
+			m.fftBuf[i] = complex(float64(real(v))*m.window[i], float64(imag(v))*m.window[i])
+		}
+		for k, v := range fft.FFT(m.fftBuf) {
+			mag := cmplx.Abs(v)
+			m.psd[k] += mag * mag
+		}
+	}
+	for k := range m.psd {
+		m.psd[k] *= m.norm / float64(frames)
+	}
+
+	var signal float64
+	for _, k := range m.channelBins {
+		signal += m.psd[k]
+	}
+
+	// The median bin beside the channel is robust against adjacent channels occupying part of the span.
+	var noise float64
+	if len(m.noiseBins) > 0 {
+		m.noiseScratch = m.noiseScratch[:0]
+		for _, k := range m.noiseBins {
+			m.noiseScratch = append(m.noiseScratch, m.psd[k])
+		}
+		sort.Float64s(m.noiseScratch)
+		noise = m.noiseScratch[len(m.noiseScratch)/2] * float64(len(m.channelBins))
+	}
+
+	m.mu.Lock()
+	if !m.measured {
+		m.signalPower, m.noisePower = signal, noise
+		m.measured = true
+	} else {
+		m.signalPower += smoothing * (signal - m.signalPower)
+		m.noisePower += smoothing * (noise - m.noisePower)
+	}
+	m.mu.Unlock()
+}
+
+// Measurement returns the smoothed measurement, and false if nothing has been measured yet.
+func (m *SignalMeter) Measurement() (Measurement, bool) {
+	m.mu.Lock()
+	signal, noise, ok := m.signalPower, m.noisePower, m.measured
+	m.mu.Unlock()
+	if !ok {
+		return Measurement{}, false
+	}
+
+	ref := m.fullScale * m.fullScale
+	return Measurement{
+		RSSIDB:       powerDB(signal / ref),
+		NoiseFloorDB: powerDB(noise / ref),
+		SNRDB:        powerDB((signal - noise) / math.Max(noise, minPower)),
+	}, true
+}
+
+func powerDB(p float64) float64 {
+	return 10 * math.Log10(math.Max(p, minPower))
+}
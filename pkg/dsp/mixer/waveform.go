@@ -35,6 +35,12 @@ func NewWaveformMixer(sampleRate int, frequency int) *WaveformMixer {
 	return ret
 }
 
+// SetFrequency retunes the mixer without a phase discontinuity.
+func (w *WaveformMixer) SetFrequency(frequency int) {
+	w.frequency = frequency
+	w.phaseIncrement = float64(frequency) * tau / float64(w.sampleRate)
+}
+
 func (w *WaveformMixer) WorkBuffer(input []complex64, output []complex64) int {
 
 	for i := 0; i < len(input); i++ {
@@ -18,6 +18,9 @@ type Processor struct {
 	inputFFT    *viz.FFTPlotter
 }
 
+// StageTimer is told how long each block took to process a segment.
+type StageTimer func(block string, d time.Duration)
+
 func NewProcessor(name, inputName string, vizServer *viz.Server) *Processor {
 	ret := &Processor{
 		Name:      name,
@@ -139,7 +142,7 @@ func (p *Processor) Initialize() error {
 }
 
 // processData can handle arbitrary input and output types
-func (p *Processor) processData(cmplxInput []complex64, floatInput []float32, byteInput []byte, expectedInputType, expectedOutputType DataType, metrics map[string]interface{}) ([]complex64, []float32, []byte, error) {
+func (p *Processor) processData(cmplxInput []complex64, floatInput []float32, byteInput []byte, expectedInputType, expectedOutputType DataType, timer StageTimer) ([]complex64, []float32, []byte, error) {
 	cnt := 0
 	if len(cmplxInput) > 0 {
 		cnt++
@@ -263,7 +266,9 @@ func (p *Processor) processData(cmplxInput []complex64, floatInput []float32, by
 
 		start := time.Now()
 		work()
-		metrics[fmt.Sprintf("%s_duration", block.Name)] = time.Since(start).Microseconds()
+		if timer != nil {
+			timer(block.Name, time.Since(start))
+		}
 
 		if block != p.blocks[len(p.blocks)-1] {
 			floatInput = floatOutput
@@ -279,14 +284,14 @@ func (p *Processor) processData(cmplxInput []complex64, floatInput []float32, by
 	return cmplxOutput, floatOutput, byteOutput, nil
 }
 
-func (p *Processor) ProcessComplexToBinary(input *types.SegmentComplex64, metrics map[string]interface{}) (*types.SegmentBinaryBytes, error) {
+func (p *Processor) ProcessComplexToBinary(input *types.SegmentComplex64, timer StageTimer) (*types.SegmentBinaryBytes, error) {
 	if !p.initialized {
 		if err := p.Initialize(); err != nil {
 			return nil, err
 		}
 	}
 
-	_, _, byteOutput, err := p.processData(input.Data, nil, nil, DataTypeComplex, DataTypeBytes, metrics)
+	_, _, byteOutput, err := p.processData(input.Data, nil, nil, DataTypeComplex, DataTypeBytes, timer)
 	if err != nil {
 		return nil, err
 	}
@@ -298,14 +303,14 @@ func (p *Processor) ProcessComplexToBinary(input *types.SegmentComplex64, metric
 	}, nil
 }
 
-func (p *Processor) ProcessComplexToFloat(input *types.SegmentComplex64, metrics map[string]interface{}) (*types.SegmentFloat32, error) {
+func (p *Processor) ProcessComplexToFloat(input *types.SegmentComplex64, timer StageTimer) (*types.SegmentFloat32, error) {
 	if !p.initialized {
 		if err := p.Initialize(); err != nil {
 			return nil, err
 		}
 	}
 
-	_, floatOutput, _, err := p.processData(input.Data, nil, nil, DataTypeComplex, DataTypeFloat, metrics)
+	_, floatOutput, _, err := p.processData(input.Data, nil, nil, DataTypeComplex, DataTypeFloat, timer)
 	if err != nil {
 		return nil, err
 	}
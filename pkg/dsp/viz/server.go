@@ -60,7 +60,12 @@ func (s *Server) SetUpdateInterval(interval time.Duration) {
 	s.mu.Unlock()
 }
 
+// Register adds a producer's plots to the server.  It does nothing on a nil server, so processors can run
+// without one.
 func (s *Server) Register(key string, p Producer) {
+	if s == nil {
+		return
+	}
 	s.mu.Lock()
 	bucket, ok := s.producerBuckets[key]
 	if !ok {
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go"
+	"github.com/influxdata/influxdb-client-go/api"
+	"github.com/influxdata/influxdb-client-go/api/write"
+)
+
+// DefaultInfluxInterval is how often the registry is written to InfluxDB if no interval is configured.
+const DefaultInfluxInterval = time.Second * 10
+
+// InfluxExporter periodically writes every metric in a registry to InfluxDB.  Each metric is a measurement
+// tagged with its labels.  Counters and gauges have a value field; histograms have count and sum fields.
+type InfluxExporter struct {
+	reg      *Registry
+	writeAPI api.WriteAPI
+	interval time.Duration
+}
+
+func NewInfluxExporter(reg *Registry, writeAPI api.WriteAPI, interval time.Duration) *InfluxExporter {
+	if interval <= 0 {
+		interval = DefaultInfluxInterval
+	}
+	return &InfluxExporter{
+		reg:      reg,
+		writeAPI: writeAPI,
+		interval: interval,
+	}
+}
+
+// Run writes the registry every interval until the context is done, then writes it once more and flushes.
+func (e *InfluxExporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			e.write(time.Now())
+			e.writeAPI.Flush()
+			return nil
+		case ts := <-ticker.C:
+			e.write(ts)
+		}
+	}
+}
+
+func (e *InfluxExporter) write(ts time.Time) {
+	for _, f := range e.reg.Gather() {
+		for _, s := range f.Samples {
+			e.writeAPI.WritePoint(influxPoint(f, s, ts))
+		}
+	}
+}
+
+func influxPoint(f Family, s Sample, ts time.Time) *write.Point {
+	tags := make(map[string]string, len(s.Labels))
+	for _, l := range s.Labels {
+		tags[l.Name] = l.Value
+	}
+
+	fields := map[string]interface{}{}
+	if f.Kind == KindHistogram {
+		fields["count"] = s.Count
+		fields["sum"] = s.Sum
+	} else {
+		fields["value"] = s.Value
+	}
+	return influxdb2.NewPoint(f.Name, tags, fields, ts)
+}
@@ -0,0 +1,360 @@
+// Package metrics holds Turbine's telemetry as typed counters, gauges and histograms.  Instrumented code only
+// updates values in memory; exporters read them from the registry, so recording a value never blocks or
+// spawns anything.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Kind int
+
+const (
+	KindCounter Kind = iota
+	KindGauge
+	KindHistogram
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindCounter:
+		return "counter"
+	case KindGauge:
+		return "gauge"
+	case KindHistogram:
+		return "histogram"
+	default:
+		return "untyped"
+	}
+}
+
+// DurationBuckets suit processing stages, which take from tens of microseconds to around a second.
+var DurationBuckets = ExponentialBuckets(0.00005, 2, 16)
+
+// ExponentialBuckets returns count histogram upper bounds, starting at start and each factor times the last.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	ret := make([]float64, count)
+	for i := range ret {
+		ret[i] = start
+		start *= factor
+	}
+	return ret
+}
+
+func addFloat(bits *uint64, v float64) {
+	for {
+		old := atomic.LoadUint64(bits)
+		if atomic.CompareAndSwapUint64(bits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	bits uint64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic(fmt.Errorf("counter cannot decrease: %v", v))
+	}
+	addFloat(&c.bits, v)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	bits uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	addFloat(&g.bits, v)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	mu     sync.Mutex
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	idx := sort.SearchFloat64s(h.bounds, v)
+
+	h.mu.Lock()
+	if idx < len(h.counts) {
+		h.counts[idx]++
+	}
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// ObserveDuration observes a duration in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Since observes the time elapsed since start, in seconds.
+func (h *Histogram) Since(start time.Time) {
+	h.ObserveDuration(time.Since(start))
+}
+
+func (h *Histogram) sample() Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := Sample{
+		Buckets: make([]Bucket, len(h.bounds)),
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		s.Buckets[i] = Bucket{UpperBound: bound, Count: cumulative}
+	}
+	return s
+}
+
+type child struct {
+	values []string
+	metric interface{}
+}
+
+// family is every labeled instance of one metric.
+type family struct {
+	name       string
+	help       string
+	kind       Kind
+	labelNames []string
+	buckets    []float64
+
+	mu       sync.RWMutex
+	children map[string]*child
+}
+
+func (f *family) with(values []string) interface{} {
+	if len(values) != len(f.labelNames) {
+		panic(fmt.Errorf("%s: got %d label values, expected %d", f.name, len(values), len(f.labelNames)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.RLock()
+	c, ok := f.children[key]
+	f.mu.RUnlock()
+	if ok {
+		return c.metric
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if c, ok := f.children[key]; ok {
+		return c.metric
+	}
+	c = &child{values: append([]string(nil), values...)}
+	switch f.kind {
+	case KindCounter:
+		c.metric = &Counter{}
+	case KindGauge:
+		c.metric = &Gauge{}
+	case KindHistogram:
+		c.metric = newHistogram(f.buckets)
+	}
+	f.children[key] = c
+	return c.metric
+}
+
+// remove deletes the instance with the given label values, if there is one.
+func (f *family) remove(values []string) {
+	f.mu.Lock()
+	delete(f.children, strings.Join(values, "\xff"))
+	f.mu.Unlock()
+}
+
+type CounterVec struct{ f *family }
+
+// With returns the counter with the given label values, in the order the labels were declared.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.f.with(labelValues).(*Counter)
+}
+
+type GaugeVec struct{ f *family }
+
+// With returns the gauge with the given label values, in the order the labels were declared.
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return v.f.with(labelValues).(*Gauge)
+}
+
+// Remove stops reporting the gauge with the given label values, for things that have gone away.
+func (v *GaugeVec) Remove(labelValues ...string) {
+	v.f.remove(labelValues)
+}
+
+type HistogramVec struct{ f *family }
+
+// With returns the histogram with the given label values, in the order the labels were declared.
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.f.with(labelValues).(*Histogram)
+}
+
+// Registry holds every metric.  It is safe for concurrent use.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+// register returns the family with a name, creating it if needed.  Registering the same name twice returns
+// the same family, so each instance of a component can declare the metrics it uses.
+func (r *Registry) register(name, help string, kind Kind, buckets []float64, labelNames []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || strings.Join(f.labelNames, ",") != strings.Join(labelNames, ",") {
+			panic(fmt.Errorf("metric %s registered again as a %s with labels %v", name, kind, labelNames))
+		}
+		return f
+	}
+
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		buckets:    buckets,
+		children:   make(map[string]*child),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (r *Registry) CounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, KindCounter, nil, labelNames)}
+}
+
+func (r *Registry) GaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, KindGauge, nil, labelNames)}
+}
+
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+	return &HistogramVec{r.register(name, help, KindHistogram, bounds, labelNames)}
+}
+
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Bucket is a histogram bucket.  Count includes every observation at or below UpperBound.
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// Sample is the value of one instance of a metric.  Counters and gauges set Value; histograms set the rest.
+type Sample struct {
+	Labels  []Label
+	Value   float64
+	Buckets []Bucket
+	Count   uint64
+	Sum     float64
+}
+
+type Family struct {
+	Name    string
+	Help    string
+	Kind    Kind
+	Samples []Sample
+}
+
+// Gather returns a snapshot of every metric, ordered by name and then label values.
+func (r *Registry) Gather() []Family {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	ret := make([]Family, 0, len(families))
+	for _, f := range families {
+		out := Family{
+			Name: f.name,
+			Help: f.help,
+			Kind: f.kind,
+		}
+
+		f.mu.RLock()
+		keys := make([]string, 0, len(f.children))
+		for key := range f.children {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			c := f.children[key]
+
+			var s Sample
+			switch m := c.metric.(type) {
+			case *Counter:
+				s.Value = m.Value()
+			case *Gauge:
+				s.Value = m.Value()
+			case *Histogram:
+				s = m.sample()
+			}
+			s.Labels = make([]Label, len(c.values))
+			for i, value := range c.values {
+				s.Labels[i] = Label{Name: f.labelNames[i], Value: value}
+			}
+			out.Samples = append(out.Samples, s)
+		}
+		f.mu.RUnlock()
+
+		ret = append(ret, out)
+	}
+	return ret
+}
@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	reg := NewRegistry()
+
+	frames := reg.CounterVec("test_frames_total", "Frames sent.", "result")
+	frames.With("sent").Add(3)
+	frames.With("dropped").Inc()
+	// Registering again returns the same metric.
+	reg.CounterVec("test_frames_total", "Frames sent.", "result").With("sent").Inc()
+
+	reg.GaugeVec("test_level_db", "Signal level.\nIn dB.", "channel").With(`a"b`).Set(-42.5)
+	reg.GaugeVec("test_unused", "Never set.")
+
+	duration := reg.HistogramVec("test_duration_seconds", "Duration.", []float64{1, 0.1})
+	duration.With().Observe(0.05)
+	duration.With().Observe(0.5)
+	duration.With().Observe(5)
+
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, reg.Gather()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{le="0.1"} 1
+test_duration_seconds_bucket{le="1"} 2
+test_duration_seconds_bucket{le="+Inf"} 3
+test_duration_seconds_sum 5.55
+test_duration_seconds_count 3
+# HELP test_frames_total Frames sent.
+# TYPE test_frames_total counter
+test_frames_total{result="dropped"} 1
+test_frames_total{result="sent"} 4
+# HELP test_level_db Signal level.\nIn dB.
+# TYPE test_level_db gauge
+test_level_db{channel="a\"b"} -42.5
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", buf.String(), expected)
+	}
+}
+
+func TestRegisterMismatch(t *testing.T) {
+	reg := NewRegistry()
+	reg.CounterVec("test_total", "", "a")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic registering a counter as a gauge")
+		}
+	}()
+	reg.GaugeVec("test_total", "", "a")
+}
@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WritePrometheus writes families in the Prometheus text exposition format.
+func WritePrometheus(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		if len(f.Samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n", f.Name, helpEscaper.Replace(f.Help))
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.Name, f.Kind)
+
+		for _, s := range f.Samples {
+			if f.Kind != KindHistogram {
+				writeSample(bw, f.Name, s.Labels, formatFloat(s.Value))
+				continue
+			}
+			for _, b := range s.Buckets {
+				writeSample(bw, f.Name+"_bucket", withLabel(s.Labels, "le", formatFloat(b.UpperBound)), strconv.FormatUint(b.Count, 10))
+			}
+			writeSample(bw, f.Name+"_bucket", withLabel(s.Labels, "le", "+Inf"), strconv.FormatUint(s.Count, 10))
+			writeSample(bw, f.Name+"_sum", s.Labels, formatFloat(s.Sum))
+			writeSample(bw, f.Name+"_count", s.Labels, strconv.FormatUint(s.Count, 10))
+		}
+	}
+	return bw.Flush()
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func withLabel(labels []Label, name, value string) []Label {
+	ret := make([]Label, len(labels), len(labels)+1)
+	copy(ret, labels)
+	return append(ret, Label{Name: name, Value: value})
+}
+
+func writeSample(w *bufio.Writer, name string, labels []Label, value string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l.Name, labelEscaper.Replace(l.Value))
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// Handler serves the registry for Prometheus to scrape.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WritePrometheus(w, r.Gather()); err != nil {
+			log.Warn().Err(err).Msg("error writing metrics")
+		}
+	})
+}
+
+// PrometheusServer serves the registry at /metrics.
+type PrometheusServer struct {
+	srv *http.Server
+}
+
+func NewPrometheusServer(port int, reg *Registry) *PrometheusServer {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", reg.Handler())
+	return &PrometheusServer{
+		srv: &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux},
+	}
+}
+
+func (s *PrometheusServer) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.srv.Shutdown(context.Background())
+	}()
+
+	err := s.srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
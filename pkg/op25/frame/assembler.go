@@ -1,8 +1,53 @@
 package frame
 
+import "time"
+
 // Assembler takes decoded bytes demodulated over the air and assembles them into packets.
 type Assembler interface {
 	// Receive expects a buffer of 1s and 0s that correspond to the bits in a packet.
 	// Each byte should only contain 1 bit.  There is no bit packing.
 	Receive([]byte)
 }
+
+// SoftAssembler is implemented by assemblers that can use the confidence of each bit.
+type SoftAssembler interface {
+	Assembler
+	// ReceiveSoft expects one value per bit, positive for a 1 and negative for a 0, whose magnitude is how
+	// confident the demodulator is in the bit.
+	ReceiveSoft([]float32)
+}
+
+// Stats are decode statistics kept by an assembler.
+type Stats struct {
+	InSync bool `json:"in_sync"`
+	// Frames counts frames received while in sync, whether or not they decoded.
+	Frames      uint64 `json:"frames"`
+	Packets     uint64 `json:"packets"`
+	CRCFailures uint64 `json:"crc_failures"`
+	// SyncLosses counts the times the decoder lost sync after having it.
+	SyncLosses uint64 `json:"sync_losses"`
+	// BitsCorrected counts bits fixed by error correction in packets that passed their CRC.
+	BitsCorrected uint64 `json:"bits_corrected"`
+	// Recovered counts packets that failed their CRC after error correction, but passed once their least
+	// reliable bits were flipped.  Only soft decision decoding recovers packets.
+	Recovered  uint64    `json:"recovered"`
+	LastPacket time.Time `json:"last_packet"`
+	// DecodeRate is packets decoded per second of air time, over the last second.
+	DecodeRate float64 `json:"decode_rate"`
+	// ExpectedRate is the packets per second the channel carries at full strength.
+	ExpectedRate float64 `json:"expected_rate"`
+}
+
+// DecodeRatio is the fraction of the channel's packets that are being decoded.
+func (s Stats) DecodeRatio() float64 {
+	if s.ExpectedRate == 0 {
+		return 0
+	}
+	return s.DecodeRate / s.ExpectedRate
+}
+
+// StatsReporter is implemented by assemblers that keep decode statistics.  Stats must be safe to call
+// concurrently with Receive.
+type StatsReporter interface {
+	Stats() Stats
+}
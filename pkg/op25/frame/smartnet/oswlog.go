@@ -0,0 +1,308 @@
+package smartnet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/norasector/turbine/pkg/op25"
+)
+
+// OSWLogFormat is how an OSW log is encoded.
+type OSWLogFormat int
+
+const (
+	// OSWLogBinary is a header followed by fixed size records, about 17 bytes per OSW.
+	OSWLogBinary OSWLogFormat = iota
+	// OSWLogJSON is one JSON object per line.
+	OSWLogJSON
+)
+
+const (
+	oswLogMagic      = "SNOSWLG1"
+	oswLogRecordSize = 17
+	// oswLogFlushInterval is how often buffered OSWs are written out, so a log is useful if turbine dies.
+	oswLogFlushInterval = time.Second
+)
+
+// OSWLogFormatForPath picks the format by file extension: .json and .jsonl are JSON lines, anything else is
+// binary.
+func OSWLogFormatForPath(path string) OSWLogFormat {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".jsonl":
+		return OSWLogJSON
+	default:
+		return OSWLogBinary
+	}
+}
+
+type oswLogRecord struct {
+	Timestamp time.Time `json:"ts"`
+	SystemID  int       `json:"system_id"`
+	Address   uint16    `json:"address"`
+	Group     uint8     `json:"group"`
+	Command   uint16    `json:"command"`
+}
+
+// OSWLogWriter writes decoded OSWs to a log, to be replayed later.  It's safe for concurrent use.
+type OSWLogWriter struct {
+	mu        sync.Mutex
+	w         *bufio.Writer
+	closer    io.Closer
+	format    OSWLogFormat
+	lastFlush time.Time
+}
+
+// NewOSWLogWriter writes a log to w.  A binary log starts with a header, so w should be empty.
+func NewOSWLogWriter(w io.Writer, format OSWLogFormat) (*OSWLogWriter, error) {
+	l := &OSWLogWriter{
+		w:         bufio.NewWriter(w),
+		format:    format,
+		lastFlush: time.Now(),
+	}
+	if format == OSWLogBinary {
+		if _, err := l.w.WriteString(oswLogMagic); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// CreateOSWLog appends to the log at path, creating it if needed, in the format its extension implies.
+func CreateOSWLog(path string) (*OSWLogWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	format := OSWLogFormatForPath(path)
+	var l *OSWLogWriter
+	if info.Size() == 0 {
+		l, err = NewOSWLogWriter(f, format)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	} else {
+		// The header is already there.
+		l = &OSWLogWriter{w: bufio.NewWriter(f), format: format, lastFlush: time.Now()}
+	}
+	l.closer = f
+	return l, nil
+}
+
+// Write logs an OSW.  Only SmartNet packets can be logged.
+func (l *OSWLogWriter) Write(p op25.OSWPacket) error {
+	packet, ok := p.Packet.(SmartnetPacket)
+	if !ok {
+		return fmt.Errorf("can't log %T", p.Packet)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch l.format {
+	case OSWLogJSON:
+		b, err := json.Marshal(oswLogRecord{
+			Timestamp: p.Timestamp,
+			SystemID:  p.SystemID,
+			Address:   packet.Address,
+			Group:     packet.Group,
+			Command:   packet.Command,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := l.w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	default:
+		var rec [oswLogRecordSize]byte
+		binary.BigEndian.PutUint64(rec[0:], uint64(p.Timestamp.UnixNano()))
+		binary.BigEndian.PutUint32(rec[8:], uint32(p.SystemID))
+		binary.BigEndian.PutUint16(rec[12:], packet.Address)
+		rec[14] = packet.Group
+		binary.BigEndian.PutUint16(rec[15:], packet.Command)
+		if _, err := l.w.Write(rec[:]); err != nil {
+			return err
+		}
+	}
+
+	if time.Since(l.lastFlush) >= oswLogFlushInterval {
+		l.lastFlush = time.Now()
+		return l.w.Flush()
+	}
+	return nil
+}
+
+// Close flushes the log, and closes the file if it was opened by CreateOSWLog.
+func (l *OSWLogWriter) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	err := l.w.Flush()
+	if l.closer != nil {
+		if closeErr := l.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// OSWLogReader reads a log written by OSWLogWriter.
+type OSWLogReader struct {
+	r      *bufio.Reader
+	closer io.Closer
+	format OSWLogFormat
+}
+
+// NewOSWLogReader reads a log from r, detecting its format from its contents.
+func NewOSWLogReader(r io.Reader) (*OSWLogReader, error) {
+	l := &OSWLogReader{
+		r:      bufio.NewReader(r),
+		format: OSWLogJSON,
+	}
+	magic, err := l.r.Peek(len(oswLogMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(magic, []byte(oswLogMagic)) {
+		l.format = OSWLogBinary
+		if _, err := l.r.Discard(len(oswLogMagic)); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// OpenOSWLog opens the log at path.
+func OpenOSWLog(path string) (*OSWLogReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	l, err := NewOSWLogReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.closer = f
+	return l, nil
+}
+
+// Next returns the next OSW in the log, or io.EOF at its end.
+func (l *OSWLogReader) Next() (op25.OSWPacket, error) {
+	var rec oswLogRecord
+
+	switch l.format {
+	case OSWLogJSON:
+		for {
+			line, err := l.r.ReadBytes('\n')
+			if len(bytes.TrimSpace(line)) == 0 {
+				if err != nil {
+					return op25.OSWPacket{}, err
+				}
+				continue
+			}
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return op25.OSWPacket{}, fmt.Errorf("bad OSW log line: %w", err)
+			}
+			break
+		}
+	default:
+		var buf [oswLogRecordSize]byte
+		if _, err := io.ReadFull(l.r, buf[:]); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				// A truncated last record, from a log that was still being written.
+				return op25.OSWPacket{}, io.EOF
+			}
+			return op25.OSWPacket{}, err
+		}
+		rec.Timestamp = time.Unix(0, int64(binary.BigEndian.Uint64(buf[0:]))).UTC()
+		rec.SystemID = int(int32(binary.BigEndian.Uint32(buf[8:])))
+		rec.Address = binary.BigEndian.Uint16(buf[12:])
+		rec.Group = buf[14]
+		rec.Command = binary.BigEndian.Uint16(buf[15:])
+	}
+
+	packet := SmartnetPacket{
+		Address: rec.Address,
+		Group:   rec.Group,
+		Command: rec.Command,
+	}
+	packet.RawData[0] = byte(packet.Address >> 8)
+	packet.RawData[1] = byte(packet.Address & 0xff)
+	packet.RawData[2] = packet.Group
+	packet.RawData[3] = byte(packet.Command >> 8)
+	packet.RawData[4] = byte(packet.Command & 0xff)
+
+	return op25.OSWPacket{
+		SystemID:   rec.SystemID,
+		SystemType: op25.SystemTypeSmartnet,
+		Packet:     packet,
+		Timestamp:  rec.Timestamp,
+	}, nil
+}
+
+// Close closes the file if the log was opened by OpenOSWLog.
+func (l *OSWLogReader) Close() error {
+	if l.closer != nil {
+		return l.closer.Close()
+	}
+	return nil
+}
+
+// ReplayOSWLog sends every OSW in a log to ch, keeping their original timestamps.  OSWs are spaced out as they
+// were received, sped up by speed; a speed of zero or less sends them as fast as ch accepts them.  It returns
+// nil at the end of the log.
+func ReplayOSWLog(ctx context.Context, l *OSWLogReader, ch chan<- op25.OSWPacket, speed float64) error {
+	var last time.Time
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		p, err := l.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if speed > 0 && !last.IsZero() && p.Timestamp.After(last) {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Duration(float64(p.Timestamp.Sub(last)) / speed))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		last = p.Timestamp
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ch <- p:
+		}
+	}
+}
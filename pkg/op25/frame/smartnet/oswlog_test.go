@@ -0,0 +1,79 @@
+package smartnet
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine/pkg/metrics"
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/rs/zerolog"
+)
+
+func TestOSWLogReplay(t *testing.T) {
+	start := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	packets := []SmartnetPacket{
+		{Address: 0x1234, Command: 0x308},
+		{Address: 0x2a30, Group: 1, Command: 0x1f0},
+		{Address: 0x1f00, Command: 0x2f8},
+		{Address: 0x1f00, Command: 0x2f8},
+	}
+
+	for _, format := range []OSWLogFormat{OSWLogBinary, OSWLogJSON} {
+		var buf bytes.Buffer
+		w, err := NewOSWLogWriter(&buf, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, p := range packets {
+			if err := w.Write(op25.OSWPacket{
+				SystemID:   604,
+				SystemType: op25.SystemTypeSmartnet,
+				Packet:     p,
+				Timestamp:  start.Add(time.Duration(i) * 23 * time.Millisecond),
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewOSWLogReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var messages []op25.ControlMessage
+		dataPacketChan := make(chan op25.OSWPacket)
+		updateChan := make(chan op25.DataPacket, 32)
+		proc := NewProcessor(604, dataPacketChan, updateChan, metrics.NewRegistry(), zerolog.Nop(),
+			WithMessageHandler(func(msg op25.ControlMessage) {
+				messages = append(messages, msg)
+			}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- proc.Start(ctx)
+		}()
+		if err := ReplayOSWLog(ctx, r, dataPacketChan, 0); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		<-done
+
+		if len(messages) != 1 {
+			t.Fatalf("format %d: expected 1 message, got %+v", format, messages)
+		}
+		msg := messages[0]
+		if msg.Type != op25.ControlMessageTypeGroupGrant || msg.SourceID != 0x1234 || msg.TalkGroupID != 0x2a30 ||
+			msg.Frequency != smartnetFrequency(0x1f0) || !msg.Timestamp.Equal(start) {
+			t.Errorf("format %d: unexpected grant %+v", format, msg)
+		}
+		if update := <-updateChan; update.DestTGID != 0x2a30 {
+			t.Errorf("format %d: unexpected update %+v", format, update)
+		}
+	}
+}
@@ -2,9 +2,12 @@ package smartnet
 
 import (
 	"context"
+	"math"
+	"sync"
 	"time"
 
 	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/op25/frame"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -20,8 +23,22 @@ const (
 	SmartnetCmdXOr        uint16 = 0x032A
 	SmartnetIDInvXOr      uint16 = (^SmartnetIDXOr) & 0xffff
 	SmartnetCmdInvXOr     uint16 = ^SmartnetCmdXOr & 0x3ff
+	SmartnetBaudRate             = 3600
 )
 
+// SmartnetPacketRate is how many packets per second a control channel carries.
+const SmartnetPacketRate = float64(SmartnetBaudRate) / SmartnetFrameLength
+
+// smartnetRecoveryBits is how many of the least reliable bits are tried flipped when a soft decision frame
+// fails its CRC.  Each of the 2^n-1 attempts has about a 1 in 1024 chance of passing a corrupt frame, so this
+// is kept small.
+const smartnetRecoveryBits = 3
+
+// smartnetMaxCRCFailures is how many frames in a row can fail their CRC before the sync is dropped and hunted
+// for again.  The sync can lock onto a pattern in the payload that looks like it, which comes round every frame
+// for as long as the same packet repeats, and would otherwise never be let go.
+const smartnetMaxCRCFailures = 10
+
 type SmartnetPacket struct {
 	Address uint16
 	Group   uint8
@@ -34,16 +51,30 @@ type SmartnetAssembler struct {
 	buf      [2 * SmartnetFrameLength]byte
 	rawFrame [SmartnetPayloadLength]byte
 	eccFrame [SmartnetDataLength + SmartnetCRCLength]byte
+	// conf, rawConf and eccMargin are how reliable each bit of buf, rawFrame and eccFrame is, when decoding
+	// soft decision bits.
+	conf      [2 * SmartnetFrameLength]float32
+	rawConf   [SmartnetPayloadLength]float32
+	eccMargin [SmartnetDataLength + SmartnetCRCLength]float32
+	soft      bool
 	// packet      SmartnetPacket
 	bufIdx      uint16
 	symbolCount uint
 	rxCount     int
 	syncReg     byte
 	inSync      bool
+	crcFailures int
 	timer       *time.Timer
 	outputChan  chan op25.OSWPacket
 	logger      zerolog.Logger
 	ctx         context.Context
+
+	// windowSymbols and windowPackets count towards the decode rate, which is measured every second of symbols.
+	windowSymbols int
+	windowPackets int
+
+	statsMu sync.Mutex
+	stats   frame.Stats
 }
 
 func NewSmartnetAssembler(ctx context.Context, systemID int, ch chan op25.OSWPacket, logger zerolog.Logger) *SmartnetAssembler {
@@ -53,29 +84,40 @@ func NewSmartnetAssembler(ctx context.Context, systemID int, ch chan op25.OSWPac
 		ctx:        ctx,
 		logger:     logger,
 		systemID:   systemID,
+		stats:      frame.Stats{ExpectedRate: SmartnetPacketRate},
 	}
 }
 
-func (s *SmartnetAssembler) insertSymbol(b byte) {
+func (s *SmartnetAssembler) insertSymbol(b byte, conf float32) {
 	s.buf[s.bufIdx] = b
 	s.buf[s.bufIdx+SmartnetFrameLength] = b
+	s.conf[s.bufIdx] = conf
+	s.conf[s.bufIdx+SmartnetFrameLength] = conf
 	s.bufIdx = (s.bufIdx + 1) % SmartnetFrameLength
 }
 
-func (s *SmartnetAssembler) receiveSymbol(symbol byte) {
+func (s *SmartnetAssembler) receiveSymbol(symbol byte, conf float32) {
 	syncDetected := false
 	s.symbolCount++
 	s.syncReg = ((s.syncReg << 1) & 0xff) | (symbol & 1)
 	if s.syncReg^SmartnetMagicNumber == 0 {
 		syncDetected = true
 	}
-	s.insertSymbol(symbol)
+	s.insertSymbol(symbol, conf)
 	s.rxCount++
 
+	s.windowSymbols++
+	if s.windowSymbols == SmartnetBaudRate {
+		s.statsMu.Lock()
+		s.stats.DecodeRate = float64(s.windowPackets) * SmartnetBaudRate / float64(s.windowSymbols)
+		s.statsMu.Unlock()
+		s.windowSymbols, s.windowPackets = 0, 0
+	}
+
 	select {
 	case <-s.timer.C:
 		log.Debug().Str("system", "smartnet").Msg("sync timer expired")
-		s.inSync = false
+		s.setInSync(false)
 		s.rxCount = 0
 		s.timer.Reset(time.Second)
 		return
@@ -83,7 +125,7 @@ func (s *SmartnetAssembler) receiveSymbol(symbol byte) {
 	}
 
 	if syncDetected && !s.inSync {
-		s.inSync = true
+		s.setInSync(true)
 		s.rxCount = 0
 		return
 	}
@@ -94,21 +136,55 @@ func (s *SmartnetAssembler) receiveSymbol(symbol byte) {
 
 	if !syncDetected {
 		log.Debug().Str("system", "smartnet").Msg("smartnet sync lost")
-		s.inSync = false
+		s.setInSync(false)
 		s.rxCount = 0
 		return
 	}
 
 	s.rxCount = 0
 
-	s.deinterleave(s.buf[s.bufIdx : s.bufIdx+SmartnetPayloadLength])
-	s.errorCorrection()
+	s.deinterleave(s.buf[s.bufIdx:s.bufIdx+SmartnetPayloadLength], s.conf[s.bufIdx:s.bufIdx+SmartnetPayloadLength])
+	var corrected int
+	if s.soft {
+		corrected = s.softErrorCorrection()
+	} else {
+		corrected = s.errorCorrection()
+	}
 
 	crcOK, packet := s.crcCheck()
+	recovered := false
+	if !crcOK && s.soft {
+		var flipped int
+		if crcOK, packet, flipped = s.recover(); crcOK {
+			corrected += flipped
+			recovered = true
+		}
+	}
 	if !crcOK {
 		log.Debug().Str("system", "smartnet").Msg("smartnet CRC failure")
+		s.statsMu.Lock()
+		s.stats.Frames++
+		s.stats.CRCFailures++
+		s.statsMu.Unlock()
+		s.crcFailures++
+		if s.crcFailures >= smartnetMaxCRCFailures {
+			log.Debug().Str("system", "smartnet").Msg("smartnet sync dropped after repeated CRC failures")
+			s.setInSync(false)
+		}
 		return
 	} else {
+		s.crcFailures = 0
+		s.windowPackets++
+		s.statsMu.Lock()
+		s.stats.Frames++
+		s.stats.Packets++
+		s.stats.BitsCorrected += uint64(corrected)
+		if recovered {
+			s.stats.Recovered++
+		}
+		s.stats.LastPacket = time.Now()
+		s.statsMu.Unlock()
+
 		select {
 		case <-s.ctx.Done():
 			return
@@ -128,6 +204,25 @@ func (s *SmartnetAssembler) receiveSymbol(symbol byte) {
 	s.timer.Reset(time.Second)
 }
 
+func (s *SmartnetAssembler) setInSync(inSync bool) {
+	lost := s.inSync && !inSync
+	s.inSync = inSync
+	s.crcFailures = 0
+	s.statsMu.Lock()
+	s.stats.InSync = inSync
+	if lost {
+		s.stats.SyncLosses++
+	}
+	s.statsMu.Unlock()
+}
+
+// Stats returns the assembler's decode statistics.
+func (s *SmartnetAssembler) Stats() frame.Stats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.stats
+}
+
 func (s *SmartnetAssembler) crcCheck() (bool, SmartnetPacket) {
 	var crcaccum uint16 = 0x0393
 	var crcop uint16 = 0x036e
@@ -186,15 +281,18 @@ func (s *SmartnetAssembler) crcCheck() (bool, SmartnetPacket) {
 
 }
 
-func (s *SmartnetAssembler) deinterleave(buf []byte) {
+func (s *SmartnetAssembler) deinterleave(buf []byte, conf []float32) {
 	for k := 0; k < SmartnetPayloadLength/4; k++ {
 		for l := 0; l < 4; l++ {
 			s.rawFrame[k*4+l] = buf[k+l*19]
+			s.rawConf[k*4+l] = conf[k+l*19]
 		}
 	}
 }
 
-func (s *SmartnetAssembler) errorCorrection() {
+// errorCorrection decodes the convolutionally coded frame into eccFrame by syndrome voting, and returns how
+// many bits it corrected.
+func (s *SmartnetAssembler) errorCorrection() int {
 	var expected [SmartnetPayloadLength]byte
 	var syndrome [SmartnetPayloadLength]byte
 
@@ -210,17 +308,132 @@ func (s *SmartnetAssembler) errorCorrection() {
 		syndrome[k] = expected[k] ^ (s.rawFrame[k] & 0x01)
 	}
 
+	corrected := 0
 	for k := 0; k < (SmartnetPayloadLength/2)-1; k++ {
 		if syndrome[2*k+1] == 1 && syndrome[2*k+3] == 1 {
 			s.eccFrame[k] = (^s.rawFrame[2*k]) & 0x01
+			corrected++
 		} else {
 			s.eccFrame[k] = s.rawFrame[2*k]
 		}
 	}
+	return corrected
+}
+
+// softErrorCorrection decodes the convolutionally coded frame into eccFrame using how reliable each bit is,
+// and returns how many bits it corrected.  It also sets eccMargin to how reliable each decoded bit is.
+//
+// Each pair of bits is a data bit and its parity with the previous data bit, so the code is a two state
+// trellis whose state is the previous data bit.  A forward and backward pass find, for each data bit, the
+// cheapest way through the trellis with it as a 0 and as a 1, where the cost of a path is the total
+// reliability of the received bits it disagrees with.  The cheaper wins, and the difference is its margin.
+func (s *SmartnetAssembler) softErrorCorrection() int {
+	const steps = SmartnetPayloadLength / 2
+	inf := float32(math.Inf(1))
+
+	// branch is the cost of sending data bit d at step k from state prev.
+	branch := func(k int, prev, d byte) float32 {
+		var cost float32
+		if s.rawFrame[2*k]&0x01 != d {
+			cost += s.rawConf[2*k]
+		}
+		if s.rawFrame[2*k+1]&0x01 != d^prev {
+			cost += s.rawConf[2*k+1]
+		}
+		return cost
+	}
+
+	var forward, backward [steps + 1][2]float32
+	forward[0] = [2]float32{0, inf}
+	for k := 0; k < steps; k++ {
+		for d := byte(0); d < 2; d++ {
+			forward[k+1][d] = float32(math.Min(
+				float64(forward[k][0]+branch(k, 0, d)),
+				float64(forward[k][1]+branch(k, 1, d))))
+		}
+	}
+	for k := steps - 1; k >= 0; k-- {
+		for prev := byte(0); prev < 2; prev++ {
+			backward[k][prev] = float32(math.Min(
+				float64(branch(k, prev, 0)+backward[k+1][0]),
+				float64(branch(k, prev, 1)+backward[k+1][1])))
+		}
+	}
+
+	corrected := 0
+	for k := range s.eccFrame {
+		var cost [2]float32
+		for d := byte(0); d < 2; d++ {
+			cost[d] = float32(math.Min(
+				float64(forward[k][0]+branch(k, 0, d)+backward[k+1][d]),
+				float64(forward[k][1]+branch(k, 1, d)+backward[k+1][d])))
+		}
+		d := byte(0)
+		if cost[1] < cost[0] {
+			d = 1
+		}
+		s.eccFrame[k] = d
+		s.eccMargin[k] = float32(math.Abs(float64(cost[1] - cost[0])))
+		if d != s.rawFrame[2*k]&0x01 {
+			corrected++
+		}
+	}
+	return corrected
+}
+
+// recover flips combinations of the least reliable bits of eccFrame until one passes the CRC.  It returns
+// whether one did, the packet, and how many bits were flipped.  eccFrame is left as it was if none did.
+func (s *SmartnetAssembler) recover() (bool, SmartnetPacket, int) {
+	var weakest [smartnetRecoveryBits]int
+	for i := range weakest {
+		weakest[i] = -1
+	}
+	for k := range s.eccFrame {
+		for i := range weakest {
+			if weakest[i] == -1 || s.eccMargin[k] < s.eccMargin[weakest[i]] {
+				copy(weakest[i+1:], weakest[i:])
+				weakest[i] = k
+				break
+			}
+		}
+	}
+
+	for mask := 1; mask < 1<<smartnetRecoveryBits; mask++ {
+		flipped := 0
+		for i, k := range weakest {
+			if mask&(1<<i) != 0 {
+				s.eccFrame[k] ^= 0x01
+				flipped++
+			}
+		}
+		if ok, packet := s.crcCheck(); ok {
+			return true, packet, flipped
+		}
+		for i, k := range weakest {
+			if mask&(1<<i) != 0 {
+				s.eccFrame[k] ^= 0x01
+			}
+		}
+	}
+	return false, SmartnetPacket{}, 0
 }
 
 func (s *SmartnetAssembler) Receive(buf []byte) {
+	s.soft = false
 	for i := 0; i < len(buf); i++ {
-		s.receiveSymbol(buf[i])
+		s.receiveSymbol(buf[i], 1)
+	}
+}
+
+// ReceiveSoft decodes soft decision bits, using their confidence to correct errors and to recover frames
+// that fail their CRC.
+func (s *SmartnetAssembler) ReceiveSoft(buf []float32) {
+	s.soft = true
+	for _, v := range buf {
+		var symbol byte
+		if v > 0 {
+			symbol = 1
+		}
+		s.receiveSymbol(symbol, float32(math.Abs(float64(v))))
 	}
 }
@@ -0,0 +1,57 @@
+package smartnet
+
+// EncodeFrame encodes a packet as it's sent over the air, one bit per byte: the sync, then the CRC protected,
+// convolutionally coded and interleaved payload.  It's the inverse of SmartnetAssembler, which decodes each
+// payload once it sees the sync of the frame after it.
+func EncodeFrame(p SmartnetPacket) []byte {
+	var data [SmartnetDataLength + SmartnetCRCLength]byte
+
+	address := p.Address ^ SmartnetIDInvXOr
+	for j := 0; j < 16; j++ {
+		data[j] = byte(address>>(15-j)) & 0x01
+	}
+	data[16] = ^p.Group & 0x01
+	command := p.Command ^ SmartnetCmdInvXOr
+	for j := 0; j < 10; j++ {
+		data[17+j] = byte(command>>(9-j)) & 0x01
+	}
+
+	// The same CRC as crcCheck, sent inverted.
+	var crcaccum uint16 = 0x0393
+	var crcop uint16 = 0x036e
+	for j := 0; j < SmartnetDataLength; j++ {
+		if crcop&0x01 == 1 {
+			crcop = (crcop >> 1) ^ 0x0225
+		} else {
+			crcop >>= 1
+		}
+		if data[j] == 1 {
+			crcaccum ^= crcop
+		}
+	}
+	for j := 0; j < SmartnetCRCLength; j++ {
+		data[SmartnetDataLength+j] = ^byte(crcaccum>>(SmartnetCRCLength-1-j)) & 0x01
+	}
+
+	// Each data bit is followed by its parity with the previous one.  The last pair flushes the encoder.
+	var raw [SmartnetPayloadLength]byte
+	var prev byte
+	for k := 0; k < SmartnetPayloadLength/2; k++ {
+		var d byte
+		if k < len(data) {
+			d = data[k]
+		}
+		raw[2*k] = d
+		raw[2*k+1] = d ^ prev
+		prev = d
+	}
+
+	ret := make([]byte, 0, SmartnetFrameLength)
+	for j := 0; j < SmartnetSyncLength; j++ {
+		ret = append(ret, (SmartnetMagicNumber>>(7-j))&0x01)
+	}
+	for i := 0; i < SmartnetPayloadLength; i++ {
+		ret = append(ret, raw[(i%19)*4+i/19])
+	}
+	return ret
+}
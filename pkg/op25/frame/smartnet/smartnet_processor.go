@@ -3,10 +3,10 @@ package smartnet
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go"
-	"github.com/influxdata/influxdb-client-go/api"
+	"github.com/norasector/turbine/pkg/metrics"
 	"github.com/norasector/turbine/pkg/op25"
 	"github.com/rs/zerolog"
 )
@@ -16,18 +16,52 @@ type SmartnetProcessor struct {
 	dataPacketChan       chan op25.OSWPacket
 	updateChan           chan op25.DataPacket
 	logger               zerolog.Logger
-	writeAPI             api.WriteAPI
+	messages             *metrics.CounterVec
 	systemID             int
+	systemLabel          string
+	messageHandler       func(op25.ControlMessage)
+	packetLog            *OSWLogWriter
 }
 
-func NewProcessor(systemID int, dataPacketChan chan op25.OSWPacket, updateChan chan op25.DataPacket, writeAPI api.WriteAPI, logger zerolog.Logger) *SmartnetProcessor {
-	return &SmartnetProcessor{
+type ProcessorOption func(s *SmartnetProcessor)
+
+// WithMessageHandler calls handler with every control message decoded.  It is called from the processor's
+// goroutine, so should not block.
+func WithMessageHandler(handler func(op25.ControlMessage)) ProcessorOption {
+	return func(s *SmartnetProcessor) {
+		s.messageHandler = handler
+	}
+}
+
+// WithPacketLog writes every OSW received to log, ahead of processing it.
+func WithPacketLog(log *OSWLogWriter) ProcessorOption {
+	return func(s *SmartnetProcessor) {
+		s.packetLog = log
+	}
+}
+
+func NewProcessor(systemID int, dataPacketChan chan op25.OSWPacket, updateChan chan op25.DataPacket, reg *metrics.Registry, logger zerolog.Logger, opts ...ProcessorOption) *SmartnetProcessor {
+	s := &SmartnetProcessor{
 		dataPacketChan: dataPacketChan,
 		updateChan:     updateChan,
-		writeAPI:       writeAPI,
+		messages:       reg.CounterVec("smartnet_messages_total", "Control channel messages decoded, by type.", "system_id", "type"),
 		systemID:       systemID,
+		systemLabel:    strconv.Itoa(systemID),
 		logger:         logger,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *SmartnetProcessor) handleMessage(msg op25.ControlMessage, ts time.Time) {
+	if s.messageHandler == nil {
+		return
+	}
+	msg.SystemID = s.systemID
+	msg.Timestamp = ts
+	s.messageHandler(msg)
 }
 
 func (s *SmartnetProcessor) popSmartnetPacket() parsedSmartnetPacket {
@@ -56,6 +90,12 @@ func (s *SmartnetProcessor) Start(ctx context.Context) error {
 			return ctx.Err()
 
 		case oswPacket := <-s.dataPacketChan:
+			if s.packetLog != nil {
+				if err := s.packetLog.Write(oswPacket); err != nil {
+					s.logger.Warn().Err(err).Int("system_id", s.systemID).Msg("failed to log OSW")
+				}
+			}
+
 			switch packet := oswPacket.Packet.(type) {
 			case SmartnetPacket:
 
@@ -63,24 +103,13 @@ func (s *SmartnetProcessor) Start(ctx context.Context) error {
 					ts:             oswPacket.Timestamp,
 					SmartnetPacket: packet,
 				}
-				metrics := make(map[string]interface{})
-
 				parseSmartnetPacket(&parsed)
 
 				s.smartnetPacketBuffer = append(s.smartnetPacketBuffer, parsed)
 
-				if err := s.processSmartnetPacket(metrics); err != nil {
+				if err := s.processSmartnetPacket(); err != nil {
 					return err
 				}
-
-				if len(metrics) > 0 {
-					go s.writeAPI.WritePoint(influxdb2.NewPoint("smartnet.packet.processed",
-						map[string]string{
-							"type": "smartnet",
-						},
-						metrics, time.Now()))
-
-				}
 			default:
 				return fmt.Errorf("unrecognized packet type %s", oswPacket.SystemType)
 			}
@@ -138,16 +167,32 @@ func smartnetFrequency(cmd uint16) int {
 	return freq //float32(math.Round(freq*100000) / 100000)
 }
 
-func incMap(m map[string]interface{}, key string) {
-	val := m[key]
-	if v, ok := val.(int); ok {
-		m[key] = v + 1
-	} else {
-		m[key] = 1
+// ChannelCommand returns the command that assigns freq on a rebanded 800 MHz system, the inverse of what the
+// processor decodes grants with.
+func ChannelCommand(freq int) (uint16, bool) {
+	for cmd := uint16(0); cmd <= 0x3ff; cmd++ {
+		packet := parsedSmartnetPacket{SmartnetPacket: SmartnetPacket{Command: cmd}}
+		parseSmartnetPacket(&packet)
+		if packet.isChannel && packet.frequency == freq {
+			return cmd, true
+		}
 	}
+	return 0, false
+}
+
+// groupStatus decodes the status bits carried in the low nibble of a talkgroup address.
+func groupStatus(address uint16) (emergency, encrypted bool) {
+	status := address & 0x000f
+	emergency = status == 2 || status == 4 || status == 5
+	encrypted = status >= 8
+	return
+}
+
+func (s *SmartnetProcessor) countMessage(messageType string) {
+	s.messages.With(s.systemLabel, messageType).Inc()
 }
 
-func (s *SmartnetProcessor) processSmartnetPacket(metrics map[string]interface{}) error {
+func (s *SmartnetProcessor) processSmartnetPacket() error {
 	for len(s.smartnetPacketBuffer) >= 3 {
 		if err := func() error {
 
@@ -162,22 +207,36 @@ func (s *SmartnetProcessor) processSmartnetPacket(metrics map[string]interface{}
 					srcID := osw2.Address
 					destTGID := osw1.Address & 0xfff0
 					targetFreq := osw1.frequency
+					emergency, encrypted := groupStatus(osw1.Address)
 
 					s.logger.Debug().
 						Int("source_id", int(srcID)).
 						Int("tgid", int(destTGID)).
 						Str("freq", op25.MHzToString(targetFreq)).
+						Bool("emergency", emergency).
+						Bool("encrypted", encrypted).
 						Str("system", "smartnet").
 						Msg("group grant")
 
+					s.handleMessage(op25.ControlMessage{
+						Type:        op25.ControlMessageTypeGroupGrant,
+						SourceID:    int(srcID),
+						TalkGroupID: int(destTGID),
+						Frequency:   targetFreq,
+						Emergency:   emergency,
+						Encrypted:   encrypted,
+					}, osw2.ts)
+
 					s.updateChan <- op25.DataPacket{
 						DestTGID:   destTGID,
 						SrcID:      srcID,
 						TargetFreq: targetFreq,
 						SystemID:   s.systemID,
+						Emergency:  emergency,
+						Encrypted:  encrypted,
 					}
 
-					incMap(metrics, "group_update")
+					s.countMessage("group_update")
 
 				case osw1.isChannel && osw1.Group == 0 && osw1.Address&0xFF00 == 0x1f00:
 					rxSysID := osw2.Address
@@ -189,7 +248,13 @@ func (s *SmartnetProcessor) processSmartnetPacket(metrics map[string]interface{}
 						Str("system", "smartnet").
 						Msg("system id broadcast")
 
-					incMap(metrics, "sys_id_broadcast")
+					s.handleMessage(op25.ControlMessage{
+						Type:           op25.ControlMessageTypeSystemID,
+						RemoteSystemID: int(rxSysID),
+						Frequency:      rxCCFreq,
+					}, osw2.ts)
+
+					s.countMessage("sys_id_broadcast")
 
 					s.updateChan <- op25.DataPacket{
 						TargetFreq: rxCCFreq,
@@ -210,7 +275,13 @@ func (s *SmartnetProcessor) processSmartnetPacket(metrics map[string]interface{}
 							Str("system", "smartnet").
 							Msg("system id broadcast")
 
-						incMap(metrics, "sys_id_broadcast")
+						s.handleMessage(op25.ControlMessage{
+							Type:           op25.ControlMessageTypeSystemID,
+							RemoteSystemID: int(rxSysID),
+							Frequency:      rxCCFreq,
+						}, osw2.ts)
+
+						s.countMessage("sys_id_broadcast")
 
 						s.updateChan <- op25.DataPacket{
 							TargetFreq: rxCCFreq,
@@ -228,7 +299,13 @@ func (s *SmartnetProcessor) processSmartnetPacket(metrics map[string]interface{}
 								Str("system", "smartnet").
 								Msg("system id broadcast")
 
-							incMap(metrics, "sys_id_broadcast")
+							s.handleMessage(op25.ControlMessage{
+								Type:           op25.ControlMessageTypeSystemID,
+								RemoteSystemID: int(rxSysID),
+								Frequency:      rxCCFreq,
+							}, osw2.ts)
+
+							s.countMessage("sys_id_broadcast")
 
 							s.updateChan <- op25.DataPacket{
 								TargetFreq: rxCCFreq,
@@ -248,7 +325,13 @@ func (s *SmartnetProcessor) processSmartnetPacket(metrics map[string]interface{}
 						Str("system", "smartnet").
 						Msg("affiliation broadcast")
 
-					incMap(metrics, "affiliation_broadcast")
+					s.handleMessage(op25.ControlMessage{
+						Type:        op25.ControlMessageTypeAffiliation,
+						SourceID:    int(srcID),
+						TalkGroupID: int(destTGID),
+					}, osw2.ts)
+
+					s.countMessage("affiliation_broadcast")
 
 				case osw1.Command == 0x320:
 
@@ -269,7 +352,15 @@ func (s *SmartnetProcessor) processSmartnetPacket(metrics map[string]interface{}
 								Str("system", "smartnet").
 								Msg("cellsite broadcast")
 
-							incMap(metrics, "cellsite_broadcast")
+							s.handleMessage(op25.ControlMessage{
+								Type:           op25.ControlMessageTypeCellsite,
+								RemoteSystemID: int(sysID),
+								CellID:         int(cellID),
+								Band:           int(band),
+								Features:       int(feat),
+							}, osw2.ts)
+
+							s.countMessage("cellsite_broadcast")
 						}
 					} else {
 						s.pushLeftSmartnetPacket(osw0)
@@ -284,20 +375,34 @@ func (s *SmartnetProcessor) processSmartnetPacket(metrics map[string]interface{}
 					srcID := osw2.Address
 					destTGID := osw1.Address & 0xfff0
 					targetFreq := osw1.frequency
+					emergency, encrypted := groupStatus(osw1.Address)
 
 					s.logger.Debug().
 						Int("source_id", int(srcID)).
 						Int("tgid", int(destTGID)).
 						Str("frequency", op25.MHzToString(targetFreq)).
+						Bool("emergency", emergency).
+						Bool("encrypted", encrypted).
 						Str("system", "smartnet").
 						Msg("astro grant")
 
-					incMap(metrics, "astro_grant")
+					s.handleMessage(op25.ControlMessage{
+						Type:        op25.ControlMessageTypeAstroGrant,
+						SourceID:    int(srcID),
+						TalkGroupID: int(destTGID),
+						Frequency:   targetFreq,
+						Emergency:   emergency,
+						Encrypted:   encrypted,
+					}, osw2.ts)
+
+					s.countMessage("astro_grant")
 					s.updateChan <- op25.DataPacket{
 						DestTGID:   destTGID,
 						SrcID:      srcID,
 						TargetFreq: targetFreq,
 						SystemID:   s.systemID,
+						Emergency:  emergency,
+						Encrypted:  encrypted,
 					}
 
 				} else {
@@ -306,21 +411,34 @@ func (s *SmartnetProcessor) processSmartnetPacket(metrics map[string]interface{}
 			case osw2.isChannel && osw2.Group > 0:
 				destTGID := osw2.Address & 0xfff0
 				targetFreq := osw2.frequency
+				emergency, encrypted := groupStatus(osw2.Address)
 				// TODO update_vocie_freq
 
 				s.logger.Debug().
 					Int("tgid", int(destTGID)).
 					Str("frequency", op25.MHzToString(targetFreq)).
+					Bool("emergency", emergency).
+					Bool("encrypted", encrypted).
 					Str("system", "smartnet").
 					Msg("group update")
 
-				incMap(metrics, "group_update")
+				s.handleMessage(op25.ControlMessage{
+					Type:        op25.ControlMessageTypeGroupUpdate,
+					TalkGroupID: int(destTGID),
+					Frequency:   targetFreq,
+					Emergency:   emergency,
+					Encrypted:   encrypted,
+				}, osw2.ts)
+
+				s.countMessage("group_update")
 
 				s.updateChan <- op25.DataPacket{
 					DestTGID:   destTGID,
 					SrcID:      0,
 					TargetFreq: targetFreq,
 					SystemID:   s.systemID,
+					Emergency:  emergency,
+					Encrypted:  encrypted,
 				}
 
 			case osw2.isChannel && osw2.Group == 0 && osw2.Address&0xff00 == 0x1f00:
@@ -328,14 +446,19 @@ func (s *SmartnetProcessor) processSmartnetPacket(metrics map[string]interface{}
 					Str("frequency", op25.MHzToString(osw2.frequency)).
 					Str("system", "smartnet").
 					Msg("control channel broadcast")
-				incMap(metrics, "control_channel_broadcast")
+
+				s.handleMessage(op25.ControlMessage{
+					Type:      op25.ControlMessageTypeControlChannel,
+					Frequency: osw2.frequency,
+				}, osw2.ts)
+				s.countMessage("control_channel_broadcast")
 
 				s.updateChan <- op25.DataPacket{
 					TargetFreq: osw2.frequency,
 					SystemID:   s.systemID,
 				}
 			default:
-				incMap(metrics, "unknown")
+				s.countMessage("unknown")
 			}
 			return nil
 		}(); err != nil {
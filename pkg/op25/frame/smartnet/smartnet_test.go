@@ -1 +1,149 @@
 package smartnet
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/rs/zerolog"
+)
+
+func syncBits() []byte {
+	return EncodeFrame(SmartnetPacket{})[:SmartnetSyncLength]
+}
+
+func newTestAssembler() (*SmartnetAssembler, chan op25.OSWPacket) {
+	ch := make(chan op25.OSWPacket, 4096)
+	return NewSmartnetAssembler(context.Background(), 604, ch, zerolog.Nop()), ch
+}
+
+func TestAssemblerDecodes(t *testing.T) {
+	a, ch := newTestAssembler()
+	packet := SmartnetPacket{Address: 0x1234, Group: 1, Command: 0x308}
+
+	var bits []byte
+	for i := 0; i < 10; i++ {
+		bits = append(bits, EncodeFrame(packet)...)
+	}
+	bits = append(bits, syncBits()...)
+	// One error in a data bit is corrected.
+	bits[SmartnetSyncLength+3] ^= 0x01
+	a.Receive(bits)
+
+	stats := a.Stats()
+	if stats.Packets != 10 || stats.CRCFailures != 0 || stats.BitsCorrected != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	got := (<-ch).Packet.(SmartnetPacket)
+	if got.Address != packet.Address || got.Group != packet.Group || got.Command != packet.Command {
+		t.Errorf("decoded %+v, expected %+v", got, packet)
+	}
+}
+
+// TestFalseSync starts the assembler on a pattern in the payload that looks like the sync, in a run of repeated
+// frames, and checks that it lets go of it.
+func TestFalseSync(t *testing.T) {
+	var (
+		frame  []byte
+		offset int
+	)
+	for address := 0; offset == 0; address++ {
+		frame = EncodeFrame(SmartnetPacket{Address: uint16(address), Command: 0x2f8})
+		for i := SmartnetSyncLength; i+SmartnetSyncLength <= SmartnetFrameLength; i++ {
+			var reg byte
+			for _, b := range frame[i : i+SmartnetSyncLength] {
+				reg = reg<<1 | b
+			}
+			if reg == SmartnetMagicNumber {
+				offset = i
+				break
+			}
+		}
+	}
+
+	var bits []byte
+	for i := 0; i < 30; i++ {
+		bits = append(bits, frame...)
+	}
+	a, _ := newTestAssembler()
+	a.Receive(bits[offset:])
+
+	stats := a.Stats()
+	if stats.CRCFailures != smartnetMaxCRCFailures || stats.SyncLosses != 1 || stats.Packets < 30-smartnetMaxCRCFailures-2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+// TestSoftDecision compares soft and hard decision decoding of the same noisy symbols.
+func TestSoftDecision(t *testing.T) {
+	const (
+		frames = 2000
+		noise  = 0.6
+	)
+	rng := rand.New(rand.NewSource(1))
+
+	var sent []SmartnetPacket
+	var symbols []float32
+	for i := 0; i < frames; i++ {
+		packet := SmartnetPacket{Address: uint16(rng.Intn(1 << 16)), Group: uint8(rng.Intn(2)), Command: uint16(rng.Intn(1 << 10))}
+		sent = append(sent, packet)
+		for j, b := range EncodeFrame(packet) {
+			v := float32(2*int(b) - 1)
+			// Leave the sync clean, so both decoders see the same frames.
+			if j >= SmartnetSyncLength {
+				v += float32(rng.NormFloat64() * noise)
+			}
+			symbols = append(symbols, v)
+		}
+	}
+
+	for _, b := range syncBits() {
+		symbols = append(symbols, float32(2*int(b)-1))
+	}
+
+	hardBits := make([]byte, len(symbols))
+	for i, v := range symbols {
+		if v > 0 {
+			hardBits[i] = 1
+		}
+	}
+	hard, _ := newTestAssembler()
+	hard.Receive(hardBits)
+	soft, softCh := newTestAssembler()
+	soft.ReceiveSoft(symbols)
+	close(softCh)
+
+	hardStats, softStats := hard.Stats(), soft.Stats()
+	t.Logf("hard: %d packets, %d CRC failures", hardStats.Packets, hardStats.CRCFailures)
+	t.Logf("soft: %d packets, %d CRC failures, %d recovered", softStats.Packets, softStats.CRCFailures, softStats.Recovered)
+
+	if hardStats.Frames != frames || softStats.Frames != frames {
+		t.Fatalf("expected %d frames, got %d hard and %d soft", frames, hardStats.Frames, softStats.Frames)
+	}
+	if softStats.CRCFailures*2 > hardStats.CRCFailures {
+		t.Errorf("soft decision should at least halve CRC failures")
+	}
+	if softStats.Recovered == 0 {
+		t.Errorf("expected some packets recovered by flipping unreliable bits")
+	}
+
+	// Packets arrive in order, so each decoded packet must match one sent at or after the previous match.
+	idx, wrong := 0, 0
+	for p := range softCh {
+		got := p.Packet.(SmartnetPacket)
+		found := false
+		for i := idx; i < len(sent); i++ {
+			if got.Address == sent[i].Address && got.Group == sent[i].Group && got.Command == sent[i].Command {
+				idx, found = i+1, true
+				break
+			}
+		}
+		if !found {
+			wrong++
+		}
+	}
+	if wrong > 0 {
+		t.Errorf("%d packets decoded wrongly", wrong)
+	}
+}
@@ -13,6 +13,9 @@ const (
 	kCoarseFrequency         float32 = 0.00125
 	kFineFrequency           float32 = 0.125
 	kCoarseFrequencyDeadband float32 = 1.66
+	// kBFSKFineFrequency is the fine frequency loop's gain for BFSK.  With only two levels, a fast loop can pull
+	// the DC level to where samples taken between symbols balance, and the symbol clock settles there.
+	kBFSKFineFrequency float32 = 0.01
 )
 
 var taps = [kNumSteps + 1][kNumTaps]float32{
@@ -18,6 +18,7 @@ type FSK4Demodulator struct {
 
 	fineFreqCorrection   float32
 	coarseFreqCorrection float32
+	fineFreqGain         float32
 
 	bfsk bool
 }
@@ -33,8 +34,12 @@ func NewFSK4Demodulator(sampleRate, symbolRate int, bfsk bool) *FSK4Demodulator
 		symbolTime:           float32(symbolRate) / float32(sampleRate),
 		fineFreqCorrection:   0.0,
 		coarseFreqCorrection: 0.0,
+		fineFreqGain:         kFineFrequency,
 		bfsk:                 bfsk,
 	}
+	if bfsk {
+		ret.fineFreqGain = kBFSKFineFrequency
+	}
 
 	return ret
 }
@@ -125,7 +130,7 @@ func (f *FSK4Demodulator) trackingLoopMMSE(input float32, output *float32) bool
 	f.symbolSpread = float32(math.Min(float64(f.symbolSpread), kSymbolSpreadMax))
 
 	f.coarseFreqCorrection += ((f.fineFreqCorrection - f.coarseFreqCorrection) * kCoarseFrequency)
-	f.fineFreqCorrection += (symbolError * kFineFrequency)
+	f.fineFreqCorrection += (symbolError * f.fineFreqGain)
 
 	return true
 }
@@ -0,0 +1,35 @@
+package slicer
+
+// SoftSlicer passes float32 symbols through as soft decision bits: positive for a 1, negative for a 0, with
+// their magnitude kept as the confidence.  It inverts them the same way BinarySlicer does, so the two can be
+// swapped at the end of a chain.
+type SoftSlicer struct {
+	invert bool
+}
+
+func NewSoftSlicer(invert bool) *SoftSlicer {
+	return &SoftSlicer{
+		invert: invert,
+	}
+}
+
+func (b *SoftSlicer) WorkBuffer(input []float32, output []float32) int {
+	for i := 0; i < len(input); i++ {
+		if b.invert {
+			output[i] = -input[i]
+		} else {
+			output[i] = input[i]
+		}
+	}
+	return len(input)
+}
+
+func (b *SoftSlicer) Work(items []float32) []float32 {
+	ret := make([]float32, len(items))
+	b.WorkBuffer(items, ret)
+	return ret
+}
+
+func (b *SoftSlicer) PredictOutputSize(inputSize int) int {
+	return inputSize
+}
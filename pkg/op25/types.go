@@ -20,4 +20,35 @@ type DataPacket struct {
 	SrcID      uint16
 	TargetFreq int
 	SystemID   int
+	Emergency  bool
+	Encrypted  bool
+}
+
+type ControlMessageType string
+
+const (
+	ControlMessageTypeGroupGrant     ControlMessageType = "group_grant"
+	ControlMessageTypeAstroGrant     ControlMessageType = "astro_grant"
+	ControlMessageTypeGroupUpdate    ControlMessageType = "group_update"
+	ControlMessageTypeAffiliation    ControlMessageType = "affiliation"
+	ControlMessageTypeSystemID       ControlMessageType = "system_id"
+	ControlMessageTypeControlChannel ControlMessageType = "control_channel"
+	ControlMessageTypeCellsite       ControlMessageType = "cellsite"
+)
+
+// ControlMessage is a decoded control channel message.  Only the fields relevant to the message type are set.
+type ControlMessage struct {
+	Type     ControlMessageType `json:"type"`
+	SystemID int                `json:"system_id"`
+	// RemoteSystemID is the system ID broadcast over the air, as opposed to the configured SystemID.
+	RemoteSystemID int       `json:"remote_system_id,omitempty"`
+	SourceID       int       `json:"source_id,omitempty"`
+	TalkGroupID    int       `json:"tgid,omitempty"`
+	Frequency      int       `json:"frequency,omitempty"`
+	Emergency      bool      `json:"emergency,omitempty"`
+	Encrypted      bool      `json:"encrypted,omitempty"`
+	CellID         int       `json:"cell_id,omitempty"`
+	Band           int       `json:"band,omitempty"`
+	Features       int       `json:"features,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
 }
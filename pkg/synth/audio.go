@@ -0,0 +1,120 @@
+package synth
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Audio is what an FM carrier is modulated with.
+type Audio interface {
+	// At returns the level, from -1 to 1, t seconds in.
+	At(t float64) float32
+}
+
+// Tone is a sine wave at Frequency, in Hz, with a peak of Level.
+type Tone struct {
+	Frequency float64
+	Level     float64
+}
+
+func (a Tone) At(t float64) float32 {
+	return float32(a.Level * math.Sin(2*math.Pi*a.Frequency*t))
+}
+
+// Recording is audio loaded from a file, which repeats once it's over.
+type Recording struct {
+	sampleRate int
+	samples    []float32
+}
+
+// NewRecording creates a recording from samples at sampleRate.
+func NewRecording(sampleRate int, samples []float32) *Recording {
+	return &Recording{sampleRate: sampleRate, samples: samples}
+}
+
+func (r *Recording) At(t float64) float32 {
+	if len(r.samples) == 0 {
+		return 0
+	}
+	// Linear interpolation is plenty for audio that'll go through a voice channel's filters.
+	pos := t * float64(r.sampleRate)
+	idx := int(pos)
+	frac := float32(pos - float64(idx))
+	cur := r.samples[idx%len(r.samples)]
+	next := r.samples[(idx+1)%len(r.samples)]
+	return cur + (next-cur)*frac
+}
+
+// LoadWAV loads a 16-bit PCM WAV file.  Only the first channel is kept.
+func LoadWAV(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadWAV(f)
+}
+
+// ReadWAV reads a 16-bit PCM WAV.  Only the first channel is kept.
+func ReadWAV(r io.Reader) (*Recording, error) {
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return nil, err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return nil, errors.New("not a WAV file")
+	}
+
+	var (
+		channels, bitsPerSample int
+		sampleRate              int
+	)
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, fmt.Errorf("no data chunk: %w", err)
+		}
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+		switch string(header[0:4]) {
+		case "fmt ":
+			format := make([]byte, size)
+			if _, err := io.ReadFull(r, format); err != nil {
+				return nil, err
+			}
+			if len(format) < 16 || binary.LittleEndian.Uint16(format[0:2]) != 1 {
+				return nil, errors.New("WAV file isn't PCM")
+			}
+			channels = int(binary.LittleEndian.Uint16(format[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(format[4:8]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(format[14:16]))
+			if bitsPerSample != 16 || channels < 1 {
+				return nil, fmt.Errorf("unsupported WAV format: %d channels of %d bits", channels, bitsPerSample)
+			}
+		case "data":
+			if sampleRate == 0 {
+				return nil, errors.New("WAV data before format")
+			}
+			data := make([]byte, size)
+			n, err := io.ReadFull(r, data)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				return nil, err
+			}
+			frame := channels * 2
+			samples := make([]float32, 0, n/frame)
+			for i := 0; i+frame <= n; i += frame {
+				samples = append(samples, float32(int16(binary.LittleEndian.Uint16(data[i:])))/math.MaxInt16)
+			}
+			return NewRecording(sampleRate, samples), nil
+		default:
+			// Chunks are padded to an even length.
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
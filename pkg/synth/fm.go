@@ -0,0 +1,57 @@
+package synth
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	// FMVoiceDeviation is the peak deviation of an FM voice carrier, in Hz.
+	FMVoiceDeviation = 2500
+)
+
+// FMCarrier is an analog voice channel: a carrier frequency modulated with audio.
+type FMCarrier struct {
+	frequency int
+	amplitude float64
+	deviation float64
+	audio     Audio
+
+	mu sync.Mutex
+	// t is how far into the audio the carrier is, in seconds.
+	t     float64
+	phase float64
+}
+
+// NewFMCarrier creates a carrier on frequency, with an amplitude in device units, modulated with audio.
+func NewFMCarrier(frequency int, amplitude float64, audio Audio) *FMCarrier {
+	return &FMCarrier{
+		frequency: frequency,
+		amplitude: amplitude,
+		deviation: FMVoiceDeviation,
+		audio:     audio,
+	}
+}
+
+// Frequency is the carrier's frequency.
+func (c *FMCarrier) Frequency() int {
+	return c.frequency
+}
+
+// Generate adds the carrier's next len(out) samples to out.
+func (c *FMCarrier) Generate(out []complex64, sampleRate, centerFreq int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	offset := float64(c.frequency - centerFreq)
+	radiansPerHz := 2 * math.Pi / float64(sampleRate)
+	dt := 1 / float64(sampleRate)
+
+	for i := range out {
+		freq := offset + c.deviation*float64(c.audio.At(c.t))
+		c.phase = math.Mod(c.phase+freq*radiansPerHz, 2*math.Pi)
+		sin, cos := math.Sincos(c.phase)
+		out[i] += complex(float32(c.amplitude*cos), float32(c.amplitude*sin))
+		c.t += dt
+	}
+}
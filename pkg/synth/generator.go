@@ -0,0 +1,110 @@
+// Package synth generates wideband IQ holding synthetic signals, for testing the receive chain without a radio.
+package synth
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/norasector/turbine-common/types"
+)
+
+// Source is a signal the generator can carry.
+type Source interface {
+	// Generate adds the next len(out) samples of the signal to out, as received by a device tuned to
+	// centerFreq and sampling at sampleRate.
+	Generate(out []complex64, sampleRate, centerFreq int)
+}
+
+// Generator sums its sources and noise into segments of samples as a device would deliver them: at the scale
+// of a signed 8-bit device, and with I and Q swapped, as types.SegmentCS8Raw.ToComplex64 leaves them.
+type Generator struct {
+	sampleRate int
+	centerFreq int
+	noise      float32
+	rng        *rand.Rand
+
+	mu      sync.Mutex
+	sources []Source
+}
+
+type GeneratorOption func(g *Generator)
+
+// WithNoise adds gaussian noise with a standard deviation of stddev to each of I and Q.
+func WithNoise(stddev float64) GeneratorOption {
+	return func(g *Generator) {
+		g.noise = float32(stddev)
+	}
+}
+
+// WithSeed seeds the noise, so runs are repeatable.
+func WithSeed(seed int64) GeneratorOption {
+	return func(g *Generator) {
+		g.rng = rand.New(rand.NewSource(seed))
+	}
+}
+
+// NewGenerator creates a generator for a device tuned to centerFreq and sampling at sampleRate.
+func NewGenerator(sampleRate, centerFreq int, opts ...GeneratorOption) *Generator {
+	g := &Generator{
+		sampleRate: sampleRate,
+		centerFreq: centerFreq,
+		rng:        rand.New(rand.NewSource(1)),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Add adds a source to every segment generated from now on.
+func (g *Generator) Add(s Source) {
+	g.mu.Lock()
+	g.sources = append(g.sources, s)
+	g.mu.Unlock()
+}
+
+// Remove stops generating a source.
+func (g *Generator) Remove(s Source) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for idx, source := range g.sources {
+		if source == s {
+			g.sources = append(g.sources[:idx:idx], g.sources[idx+1:]...)
+			return
+		}
+	}
+}
+
+// Segment generates the next n samples.
+func (g *Generator) Segment(n int) *types.SegmentComplex64 {
+	data := make([]complex64, n)
+	g.Fill(data)
+	return &types.SegmentComplex64{
+		SampleRate: g.sampleRate,
+		Frequency:  g.centerFreq,
+		Data:       data,
+	}
+}
+
+// Fill generates the next len(out) samples into out.
+func (g *Generator) Fill(out []complex64) {
+	for i := range out {
+		out[i] = 0
+	}
+
+	g.mu.Lock()
+	for _, s := range g.sources {
+		s.Generate(out, g.sampleRate, g.centerFreq)
+	}
+	g.mu.Unlock()
+
+	for i, v := range out {
+		re, im := real(v), imag(v)
+		if g.noise > 0 {
+			re += float32(g.rng.NormFloat64()) * g.noise
+			im += float32(g.rng.NormFloat64()) * g.noise
+		}
+		// Devices deliver Q as the real part, so their spectrum is mirrored.
+		out[i] = complex(im, re)
+	}
+}
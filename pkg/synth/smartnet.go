@@ -0,0 +1,111 @@
+package synth
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/norasector/turbine/pkg/op25/frame/smartnet"
+)
+
+const (
+	// SmartnetDeviation is the frequency deviation of a SmartNet control channel's BFSK, in Hz.
+	SmartnetDeviation = 2500
+)
+
+// SmartnetIdle is sent when a control channel has nothing else to send.
+var SmartnetIdle = smartnet.SmartnetPacket{Address: 0x1f00, Command: 0x2f8}
+
+// SmartnetControlChannel is a SmartNet control channel: BFSK at 3600 baud, sending scripted packets, or idles
+// when there are none.  A 1 is sent above the carrier.
+type SmartnetControlChannel struct {
+	frequency int
+	amplitude float64
+	deviation float64
+
+	mu      sync.Mutex
+	packets []smartnet.SmartnetPacket
+	bits    []byte
+	// symbolClock counts through each symbol, from 0 to 1.
+	symbolClock float64
+	phase       float64
+}
+
+// NewSmartnetControlChannel creates a control channel on frequency, with a carrier amplitude in device units.
+func NewSmartnetControlChannel(frequency int, amplitude float64) *SmartnetControlChannel {
+	return &SmartnetControlChannel{
+		frequency: frequency,
+		amplitude: amplitude,
+		deviation: SmartnetDeviation,
+	}
+}
+
+// Frequency is the control channel's frequency.
+func (c *SmartnetControlChannel) Frequency() int {
+	return c.frequency
+}
+
+// Send queues packets to be sent in order, after anything already queued.
+func (c *SmartnetControlChannel) Send(packets ...smartnet.SmartnetPacket) {
+	c.mu.Lock()
+	c.packets = append(c.packets, packets...)
+	c.mu.Unlock()
+}
+
+// Grant queues a group voice grant of talkGroupID to sourceID on frequency.
+func (c *SmartnetControlChannel) Grant(sourceID, talkGroupID, frequency int) error {
+	cmd, ok := smartnet.ChannelCommand(frequency)
+	if !ok {
+		return fmt.Errorf("no SmartNet channel on %d", frequency)
+	}
+	c.Send(
+		smartnet.SmartnetPacket{Address: uint16(sourceID), Command: 0x308},
+		smartnet.SmartnetPacket{Address: uint16(talkGroupID) & 0xfff0, Group: 1, Command: cmd},
+	)
+	return nil
+}
+
+// Pending returns how many packets are queued and not yet started.
+func (c *SmartnetControlChannel) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.packets)
+}
+
+func (c *SmartnetControlChannel) nextBit() byte {
+	if len(c.bits) == 0 {
+		packet := SmartnetIdle
+		if len(c.packets) > 0 {
+			packet = c.packets[0]
+			c.packets = c.packets[1:]
+		}
+		c.bits = smartnet.EncodeFrame(packet)
+	}
+	return c.bits[0]
+}
+
+// Generate adds the control channel's next len(out) samples to out, as continuous phase BFSK.
+func (c *SmartnetControlChannel) Generate(out []complex64, sampleRate, centerFreq int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	offset := float64(c.frequency - centerFreq)
+	symbolStep := float64(smartnet.SmartnetBaudRate) / float64(sampleRate)
+	radiansPerHz := 2 * math.Pi / float64(sampleRate)
+
+	for i := range out {
+		freq := offset - c.deviation
+		if c.nextBit() == 1 {
+			freq = offset + c.deviation
+		}
+		c.phase = math.Mod(c.phase+freq*radiansPerHz, 2*math.Pi)
+		sin, cos := math.Sincos(c.phase)
+		out[i] += complex(float32(c.amplitude*cos), float32(c.amplitude*sin))
+
+		c.symbolClock += symbolStep
+		if c.symbolClock >= 1 {
+			c.symbolClock--
+			c.bits = c.bits[1:]
+		}
+	}
+}
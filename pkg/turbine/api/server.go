@@ -0,0 +1,312 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/norasector/turbine/pkg/turbine"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/feed"
+	"github.com/rs/zerolog/log"
+)
+
+// Controller is the part of a running Turbine the API exposes.
+type Controller interface {
+	Systems() []turbine.SystemStatus
+	ControlChannels() []turbine.ControlChannelStatus
+	VoiceChannels() []turbine.VoiceChannelStatus
+	ActiveCalls() []*call.Record
+	DeviceStatus() turbine.DeviceStatus
+	OutputHealth() []turbine.OutputStatus
+	Feed() *feed.Bus
+
+	AddControlFrequency(systemID, freq int) error
+	RemoveControlFrequency(systemID, freq int) error
+	MuteTalkGroup(systemID, tgid int) error
+	UnmuteTalkGroup(systemID, tgid int) error
+	SetSquelch(systemID, level int) error
+	SetDeviceSettings(changes device.Settings) error
+	Snapshot(comment string) ([]string, error)
+}
+
+var _ Controller = (*turbine.Turbine)(nil)
+
+// Server serves a JSON API for monitoring and controlling a running Turbine.
+type Server struct {
+	ctrl    Controller
+	srv     *http.Server
+	handler *httprouter.Router
+}
+
+// defaultAddress keeps the API, which has no authentication, to local clients unless it's configured otherwise.
+const defaultAddress = "127.0.0.1"
+
+// NewServer serves the API on address and port.  An empty address is taken to be 127.0.0.1.
+func NewServer(address string, port int, ctrl Controller) *Server {
+	if address == "" {
+		address = defaultAddress
+	}
+	s := &Server{
+		ctrl:    ctrl,
+		srv:     &http.Server{Addr: net.JoinHostPort(address, strconv.Itoa(port))},
+		handler: httprouter.New(),
+	}
+
+	s.handler.GET("/api/systems", s.getSystems)
+	s.handler.GET("/api/control_channels", s.getControlChannels)
+	s.handler.GET("/api/voice_channels", s.getVoiceChannels)
+	s.handler.GET("/api/calls", s.getCalls)
+	s.handler.GET("/api/device", s.getDevice)
+	s.handler.GET("/api/outputs", s.getOutputs)
+	s.handler.Handler(http.MethodGet, "/api/events", ctrl.Feed().SSEHandler())
+	s.handler.Handler(http.MethodGet, "/api/events/ws", ctrl.Feed().WebSocketHandler())
+
+	s.handler.POST("/api/systems/:system/control_channels", s.addControlChannel)
+	s.handler.DELETE("/api/systems/:system/control_channels/:frequency", s.removeControlChannel)
+	s.handler.PUT("/api/systems/:system/muted/:tgid", s.muteTalkGroup)
+	s.handler.DELETE("/api/systems/:system/muted/:tgid", s.unmuteTalkGroup)
+	s.handler.PUT("/api/systems/:system/squelch", s.setSquelch)
+	s.handler.POST("/api/snapshots", s.takeSnapshot)
+	s.handler.PUT("/api/device/settings", s.setDeviceSettings)
+
+	s.srv.Handler = s.handler
+	return s
+}
+
+// Handler returns the API's HTTP handler, so it can be mounted elsewhere or tested.
+func (s *Server) Handler() http.Handler {
+	return s.handler
+}
+
+func (s *Server) Stop(ctx context.Context) {
+	s.srv.Shutdown(ctx)
+}
+
+func (s *Server) Run(ctx context.Context) error {
+	go func() {
+		<-ctx.Done()
+		s.srv.Shutdown(context.Background())
+	}()
+
+	err := s.srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn().Err(err).Msg("error writing api response")
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, turbine.ErrUnknownSystem), errors.Is(err, turbine.ErrUnknownFrequency):
+		status = http.StatusNotFound
+	case errors.Is(err, turbine.ErrFrequencyOutOfRange), errors.Is(err, device.ErrUnsupportedSetting),
+		errors.Is(err, errBadRequest):
+		status = http.StatusBadRequest
+	case errors.Is(err, turbine.ErrNoSnapshot):
+		status = http.StatusConflict
+	case errors.Is(err, turbine.ErrNotRunning):
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+var errBadRequest = errors.New("bad request")
+
+func intParam(params httprouter.Params, name string) (int, error) {
+	v, err := strconv.Atoi(params.ByName(name))
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid %s %q", errBadRequest, name, params.ByName(name))
+	}
+	return v, nil
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+	return nil
+}
+
+func (s *Server) getSystems(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, s.ctrl.Systems())
+}
+
+func (s *Server) getControlChannels(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, s.ctrl.ControlChannels())
+}
+
+func (s *Server) getVoiceChannels(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, s.ctrl.VoiceChannels())
+}
+
+func (s *Server) getCalls(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	calls := s.ctrl.ActiveCalls()
+	if calls == nil {
+		calls = []*call.Record{}
+	}
+	writeJSON(w, http.StatusOK, calls)
+}
+
+func (s *Server) getDevice(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, s.ctrl.DeviceStatus())
+}
+
+// setDeviceSettings changes the settings given, such as `{"gains": {"lna": 24}}`, and returns the device's status.
+func (s *Server) setDeviceSettings(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var changes device.Settings
+	if err := decodeBody(r, &changes); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.ctrl.SetDeviceSettings(changes); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.ctrl.DeviceStatus())
+}
+
+func (s *Server) getOutputs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	writeJSON(w, http.StatusOK, s.ctrl.OutputHealth())
+}
+
+type controlChannelRequest struct {
+	Frequency int `json:"frequency"`
+}
+
+func (s *Server) addControlChannel(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	systemID, err := intParam(params, "system")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req controlChannelRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.ctrl.AddControlFrequency(systemID, req.Frequency); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.ctrl.ControlChannels())
+}
+
+func (s *Server) removeControlChannel(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	systemID, err := intParam(params, "system")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	freq, err := intParam(params, "frequency")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := s.ctrl.RemoveControlFrequency(systemID, freq); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, s.ctrl.ControlChannels())
+}
+
+func (s *Server) setMuted(w http.ResponseWriter, params httprouter.Params, muted bool) {
+	systemID, err := intParam(params, "system")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	tgid, err := intParam(params, "tgid")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if muted {
+		err = s.ctrl.MuteTalkGroup(systemID, tgid)
+	} else {
+		err = s.ctrl.UnmuteTalkGroup(systemID, tgid)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) muteTalkGroup(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	s.setMuted(w, params, true)
+}
+
+func (s *Server) unmuteTalkGroup(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	s.setMuted(w, params, false)
+}
+
+type squelchRequest struct {
+	Level *int `json:"level"`
+}
+
+func (s *Server) setSquelch(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	systemID, err := intParam(params, "system")
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req squelchRequest
+	if err := decodeBody(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+	if req.Level == nil {
+		writeError(w, fmt.Errorf("%w: missing level", errBadRequest))
+		return
+	}
+	if err := s.ctrl.SetSquelch(systemID, *req.Level); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type snapshotRequest struct {
+	Comment string `json:"comment"`
+}
+
+type snapshotResponse struct {
+	Paths []string `json:"paths"`
+}
+
+// takeSnapshot writes out the last few seconds of samples.  The body is optional.
+func (s *Server) takeSnapshot(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req snapshotRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		writeError(w, fmt.Errorf("%w: %s", errBadRequest, err))
+		return
+	}
+	paths, err := s.ctrl.Snapshot(req.Comment)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, snapshotResponse{Paths: paths})
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/norasector/turbine/pkg/turbine"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/feed"
+)
+
+type fakeController struct {
+	muted   map[int]bool
+	squelch int
+	feed    *feed.Bus
+	running bool
+}
+
+func (f *fakeController) Systems() []turbine.SystemStatus                 { return nil }
+func (f *fakeController) ControlChannels() []turbine.ControlChannelStatus { return nil }
+func (f *fakeController) VoiceChannels() []turbine.VoiceChannelStatus     { return nil }
+func (f *fakeController) ActiveCalls() []*call.Record                     { return nil }
+func (f *fakeController) DeviceStatus() turbine.DeviceStatus              { return turbine.DeviceStatus{} }
+func (f *fakeController) OutputHealth() []turbine.OutputStatus            { return nil }
+func (f *fakeController) Feed() *feed.Bus                                 { return f.feed }
+
+func (f *fakeController) AddControlFrequency(systemID, freq int) error {
+	if !f.running {
+		return turbine.ErrNotRunning
+	}
+	return f.checkSystem(systemID)
+}
+
+func (f *fakeController) RemoveControlFrequency(systemID, freq int) error { return nil }
+
+func (f *fakeController) checkSystem(systemID int) error {
+	if systemID != 604 {
+		return fmt.Errorf("%w: %d", turbine.ErrUnknownSystem, systemID)
+	}
+	return nil
+}
+
+func (f *fakeController) MuteTalkGroup(systemID, tgid int) error {
+	f.muted[tgid] = true
+	return f.checkSystem(systemID)
+}
+
+func (f *fakeController) UnmuteTalkGroup(systemID, tgid int) error {
+	delete(f.muted, tgid)
+	return f.checkSystem(systemID)
+}
+
+func (f *fakeController) SetSquelch(systemID, level int) error {
+	f.squelch = level
+	return f.checkSystem(systemID)
+}
+
+func (f *fakeController) SetDeviceSettings(changes device.Settings) error {
+	return device.Check([]device.GainStage{{Name: "lna", Max: 40, Step: 8}}, device.Settings{}, changes)
+}
+
+func (f *fakeController) Snapshot(comment string) ([]string, error) {
+	if comment == "" {
+		return nil, turbine.ErrNoSnapshot
+	}
+	return []string{"snapshot.sigmf-meta"}, nil
+}
+
+func TestServer(t *testing.T) {
+	ctrl := &fakeController{muted: make(map[int]bool), feed: feed.NewBus()}
+	handler := NewServer("", 0, ctrl).Handler()
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPut, "/api/systems/604/muted/1200", "", http.StatusNoContent},
+		{http.MethodPut, "/api/systems/605/muted/1200", "", http.StatusNotFound},
+		{http.MethodPut, "/api/systems/604/muted/abc", "", http.StatusBadRequest},
+		{http.MethodPut, "/api/systems/604/squelch", `{"level": -45}`, http.StatusNoContent},
+		{http.MethodPut, "/api/systems/604/squelch", `{}`, http.StatusBadRequest},
+		{http.MethodGet, "/api/calls", "", http.StatusOK},
+		{http.MethodPost, "/api/snapshots", `{"comment": "odd grant"}`, http.StatusAccepted},
+		{http.MethodPost, "/api/snapshots", "", http.StatusConflict},
+		{http.MethodPost, "/api/snapshots", "{", http.StatusBadRequest},
+		{http.MethodPut, "/api/device/settings", `{"gains": {"lna": 24}}`, http.StatusOK},
+		{http.MethodPut, "/api/device/settings", `{"gains": {"lna": 50}}`, http.StatusBadRequest},
+		{http.MethodPut, "/api/device/settings", `{"bias_tee": true}`, http.StatusBadRequest},
+		{http.MethodPost, "/api/systems/604/control_channels", `{"frequency": 851412500}`, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: got status %d, want %d (%s)", tt.method, tt.path, rec.Code, tt.status, rec.Body.String())
+		}
+	}
+
+	if !ctrl.muted[1200] {
+		t.Errorf("talkgroup not muted")
+	}
+	if ctrl.squelch != -45 {
+		t.Errorf("got squelch %d, want -45", ctrl.squelch)
+	}
+
+	ctrl.running = true
+	req := httptest.NewRequest(http.MethodPost, "/api/systems/604/control_channels", strings.NewReader(`{"frequency": 851412500}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("adding a control channel once running: got status %d (%s)", rec.Code, rec.Body.String())
+	}
+}
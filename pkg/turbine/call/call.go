@@ -0,0 +1,150 @@
+package call
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/catalog"
+)
+
+type EventType string
+
+const (
+	EventTypeStart  EventType = "call_start"
+	EventTypeUpdate EventType = "call_update"
+	EventTypeEnd    EventType = "call_end"
+)
+
+// Event is emitted whenever a call starts, changes, or ends.
+type Event struct {
+	Type      EventType `json:"type"`
+	Timestamp time.Time `json:"timestamp"`
+	Call      *Record   `json:"call"`
+}
+
+// Source is a radio unit heard during a call.
+type Source struct {
+	ID        int       `json:"id"`
+	Alias     string    `json:"alias,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// Signal describes the quality of a received channel.  On a call record, each value is the average while the
+// squelch was open.
+type Signal struct {
+	// LevelDB is the squelch level.
+	LevelDB float64 `json:"level_db"`
+	// RSSIDB, NoiseFloorDB and SNRDB are measured within the channel bandwidth.  Levels are in dB relative to
+	// the device's full scale: comparable between channels, but not calibrated.
+	RSSIDB       float64 `json:"rssi_db"`
+	NoiseFloorDB float64 `json:"noise_floor_db"`
+	SNRDB        float64 `json:"snr_db"`
+	// FrequencyErrorHz is how far the signal is above its assigned frequency, from the FM discriminator.
+	FrequencyErrorHz float64 `json:"frequency_error_hz"`
+}
+
+// Record is a call detail record.  Records attached to events and audio are snapshots: they are safe to
+// retain but are shared, so must not be modified.
+type Record struct {
+	ID          string `json:"id"`
+	SystemID    int    `json:"system_id"`
+	SystemName  string `json:"system_name,omitempty"`
+	TalkGroupID int    `json:"tgid"`
+	// TalkGroup is the talkgroup's catalog entry, if it has one.
+	TalkGroup *catalog.Talkgroup `json:"talkgroup,omitempty"`
+	Frequency int                `json:"frequency"`
+	Sources   []Source           `json:"sources"`
+	StartTime time.Time          `json:"start_time"`
+	EndTime   *time.Time         `json:"end_time,omitempty"`
+	// Duration is the length of the call in seconds, from the first grant until the last activity seen.
+	Duration  float64 `json:"duration"`
+	Emergency bool    `json:"emergency"`
+	Encrypted bool    `json:"encrypted"`
+	Signal    Signal  `json:"signal"`
+}
+
+// TaggedAudio is demodulated audio along with the call it belongs to.
+type TaggedAudio struct {
+	*types.TaggedAudioSampleFloat32
+	// Call is the most recent snapshot of the call, or nil if the audio arrived before its call was tracked.
+	Call *Record
+	// SourceAlias is the alias of the unit currently transmitting, if it has one.
+	SourceAlias string
+}
+
+// AlphaTag returns the catalog alpha tag of the call's talkgroup, or an empty string if it has none.
+func (r *Record) AlphaTag() string {
+	if r == nil || r.TalkGroup == nil {
+		return ""
+	}
+	return r.TalkGroup.AlphaTag
+}
+
+// Category returns the catalog category of the call's talkgroup, or an empty string if it has none.
+func (r *Record) Category() string {
+	if r == nil || r.TalkGroup == nil {
+		return ""
+	}
+	return r.TalkGroup.Category
+}
+
+func NewRecord(systemID, tgid, freq int, start time.Time) *Record {
+	return &Record{
+		ID:          fmt.Sprintf("%d-%d-%d", systemID, tgid, start.UnixNano()),
+		SystemID:    systemID,
+		TalkGroupID: tgid,
+		Frequency:   freq,
+		StartTime:   start,
+	}
+}
+
+// AddSource records a source ID and its alias, returning true if it had not been heard on this call before.
+func (r *Record) AddSource(id int, alias string, ts time.Time) bool {
+	if id == 0 {
+		return false
+	}
+	for _, src := range r.Sources {
+		if src.ID == id {
+			return false
+		}
+	}
+	r.Sources = append(r.Sources, Source{ID: id, Alias: alias, FirstSeen: ts})
+	return true
+}
+
+// SourceAliases returns the aliases of all sources heard on the call, in the order they were first heard.  Sources
+// without an alias are given as their ID.
+func (r *Record) SourceAliases() []string {
+	ret := make([]string, 0, len(r.Sources))
+	for _, src := range r.Sources {
+		if src.Alias != "" {
+			ret = append(ret, src.Alias)
+		} else {
+			ret = append(ret, strconv.Itoa(src.ID))
+		}
+	}
+	return ret
+}
+
+// SourceIDs returns the IDs of all sources heard on the call, in the order they were first heard.
+func (r *Record) SourceIDs() []int {
+	ret := make([]int, 0, len(r.Sources))
+	for _, src := range r.Sources {
+		ret = append(ret, src.ID)
+	}
+	return ret
+}
+
+// Copy returns a deep copy of the record.
+func (r *Record) Copy() *Record {
+	ret := *r
+	ret.Sources = make([]Source, len(r.Sources))
+	copy(ret.Sources, r.Sources)
+	if r.EndTime != nil {
+		end := *r.EndTime
+		ret.EndTime = &end
+	}
+	return &ret
+}
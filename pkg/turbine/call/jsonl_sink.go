@@ -0,0 +1,59 @@
+package call
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+const sinkBufferLength = 64
+
+// JSONLinesSink appends each call event to a file as a single line of JSON.
+type JSONLinesSink struct {
+	path     string
+	recvChan chan *Event
+}
+
+func NewJSONLinesSink(path string) *JSONLinesSink {
+	return &JSONLinesSink{
+		path:     path,
+		recvChan: make(chan *Event, sinkBufferLength),
+	}
+}
+
+func (j *JSONLinesSink) Receive() chan<- *Event {
+	return j.recvChan
+}
+
+func (j *JSONLinesSink) Start(ctx context.Context) error {
+	f, err := os.OpenFile(j.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+	enc := json.NewEncoder(w)
+
+	flushTicker := time.NewTicker(time.Second)
+	defer flushTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-flushTicker.C:
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		case ev := <-j.recvChan:
+			// Encode writes a trailing newline after each value.
+			if err := enc.Encode(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package call
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/rs/zerolog/log"
+)
+
+// UDPSink sends each call event as a JSON datagram to every configured destination.
+type UDPSink struct {
+	dests    []config.OutputDestination
+	recvChan chan *Event
+}
+
+func NewUDPSink(dests []config.OutputDestination) *UDPSink {
+	return &UDPSink{
+		dests:    dests,
+		recvChan: make(chan *Event, sinkBufferLength),
+	}
+}
+
+func (u *UDPSink) Receive() chan<- *Event {
+	return u.recvChan
+}
+
+func (u *UDPSink) Start(ctx context.Context) error {
+	destAddrs := make([]*net.UDPAddr, 0, len(u.dests))
+	for _, dest := range u.dests {
+		ips, err := net.LookupIP(dest.Host)
+		if err != nil {
+			return err
+		}
+		if len(ips) == 0 {
+			return fmt.Errorf("no IPs returned for %s", dest.Host)
+		}
+
+		destAddr := &net.UDPAddr{IP: ips[0], Port: dest.Port}
+		destAddrs = append(destAddrs, destAddr)
+		log.Info().IPAddr("dest_ip", destAddr.IP).Int("port", dest.Port).Msg("call event output starting")
+	}
+
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-u.recvChan:
+			encoded, err := json.Marshal(ev)
+			if err != nil {
+				log.Warn().Err(err).Msg("error marshaling call event")
+				continue
+			}
+
+			for _, destAddr := range destAddrs {
+				if _, err := conn.WriteToUDP(encoded, destAddr); err != nil {
+					log.Error().Err(err).Msg("error writing call event")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package turbine
+
+import (
+	"time"
+
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/turbine/call"
+)
+
+const (
+	// callHangTime is how long a call is held open after its grant updates stop and its squelch closes.
+	callHangTime = time.Second
+	// callExpiryInterval is how often active calls are checked for expiry.
+	callExpiryInterval = time.Millisecond * 250
+)
+
+type activeCall struct {
+	record *call.Record
+	// published is the snapshot most recently sent with an event, which is also attached to the call's audio.
+	published    *call.Record
+	lastGrant    time.Time
+	lastActivity time.Time
+	// signalSum accumulates the signal measured while the squelch was open, to be averaged over signalCount.
+	signalSum   call.Signal
+	signalCount int
+}
+
+type squelchState struct {
+	open       bool
+	signal     call.Signal
+	lastOpen   time.Time
+	lastUpdate time.Time
+}
+
+func (a *activeCall) snapshot() *call.Record {
+	rec := a.record.Copy()
+	rec.Duration = a.lastActivity.Sub(rec.StartTime).Seconds()
+	if a.signalCount > 0 {
+		n := float64(a.signalCount)
+		rec.Signal = call.Signal{
+			LevelDB:          a.signalSum.LevelDB / n,
+			RSSIDB:           a.signalSum.RSSIDB / n,
+			NoiseFloorDB:     a.signalSum.NoiseFloorDB / n,
+			SNRDB:            a.signalSum.SNRDB / n,
+			FrequencyErrorHz: a.signalSum.FrequencyErrorHz / n,
+		}
+	}
+	return rec
+}
+
+func (v *VoiceManager) newEvent(eventType call.EventType, ac *activeCall, now time.Time) *call.Event {
+	rec := ac.snapshot()
+	if eventType == call.EventTypeEnd {
+		end := ac.lastActivity
+		rec.EndTime = &end
+	}
+	ac.published = rec
+	return &call.Event{
+		Type:      eventType,
+		Timestamp: now,
+		Call:      rec,
+	}
+}
+
+// trackGrant updates call state for a grant.  Must be called with v.mu held.
+func (v *VoiceManager) trackGrant(update op25.DataPacket, now time.Time) []*call.Event {
+	var events []*call.Event
+	tgid := int(update.DestTGID)
+	sourceID := int(update.SrcID)
+
+	var alias string
+	if v.units != nil {
+		v.units.Observe(sourceID, tgid, now)
+		alias = v.units.Alias(sourceID)
+	}
+
+	// A frequency carries one call at a time, so a grant for a different talkgroup ends whatever was there.
+	for otherTGID, ac := range v.calls {
+		if otherTGID != tgid && ac.record.Frequency == update.TargetFreq {
+			events = append(events, v.newEvent(call.EventTypeEnd, ac, now))
+			delete(v.calls, otherTGID)
+		}
+	}
+
+	ac, ok := v.calls[tgid]
+	if ok && ac.record.Frequency != update.TargetFreq {
+		events = append(events, v.newEvent(call.EventTypeEnd, ac, now))
+		delete(v.calls, tgid)
+		ok = false
+	}
+
+	if !ok {
+		ac = &activeCall{
+			record:       call.NewRecord(v.systemID, tgid, update.TargetFreq, now),
+			lastGrant:    now,
+			lastActivity: now,
+		}
+		ac.record.SystemName = v.systemName
+		if v.catalog != nil {
+			ac.record.TalkGroup = v.catalog.Lookup(tgid)
+		}
+		ac.record.AddSource(sourceID, alias, now)
+		ac.record.Emergency = update.Emergency
+		ac.record.Encrypted = update.Encrypted
+		v.calls[tgid] = ac
+		return append(events, v.newEvent(call.EventTypeStart, ac, now))
+	}
+
+	ac.lastGrant = now
+	ac.lastActivity = now
+
+	changed := ac.record.AddSource(sourceID, alias, now)
+	// Flags are sticky for the life of the call: once a call is flagged we want to keep reporting it.
+	if update.Emergency && !ac.record.Emergency {
+		ac.record.Emergency = true
+		changed = true
+	}
+	if update.Encrypted && !ac.record.Encrypted {
+		ac.record.Encrypted = true
+		changed = true
+	}
+	if changed {
+		events = append(events, v.newEvent(call.EventTypeUpdate, ac, now))
+	}
+
+	return events
+}
+
+// UpdateSquelch records the squelch state and signal of a voice frequency after a segment has been processed.
+func (v *VoiceManager) UpdateSquelch(freq int, open bool, signal call.Signal) {
+	now := time.Now()
+	v.mu.Lock()
+	sq, ok := v.squelch[freq]
+	if !ok {
+		sq = &squelchState{}
+		v.squelch[freq] = sq
+	}
+	sq.open = open
+	sq.signal = signal
+	sq.lastUpdate = now
+	if open {
+		sq.lastOpen = now
+		for _, ac := range v.calls {
+			if ac.record.Frequency == freq {
+				ac.lastActivity = now
+				ac.signalSum.LevelDB += signal.LevelDB
+				ac.signalSum.RSSIDB += signal.RSSIDB
+				ac.signalSum.NoiseFloorDB += signal.NoiseFloorDB
+				ac.signalSum.SNRDB += signal.SNRDB
+				ac.signalSum.FrequencyErrorHz += signal.FrequencyErrorHz
+				ac.signalCount++
+			}
+		}
+	}
+	v.mu.Unlock()
+}
+
+// SquelchState returns the squelch state and signal of a voice frequency as of the last segment processed.
+func (v *VoiceManager) SquelchState(freq int) (open bool, signal call.Signal, lastOpen time.Time) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if sq, ok := v.squelch[freq]; ok {
+		return sq.open, sq.signal, sq.lastOpen
+	}
+	return false, call.Signal{}, time.Time{}
+}
+
+// ExpireCalls ends any call whose grants have stopped and whose channel has gone quiet.
+func (v *VoiceManager) ExpireCalls(now time.Time) {
+	var events []*call.Event
+
+	v.mu.Lock()
+	for tgid, ac := range v.calls {
+		sinceGrant := now.Sub(ac.lastGrant)
+		squelchClosed := true
+		if sq, ok := v.squelch[ac.record.Frequency]; ok {
+			squelchClosed = !sq.open && now.Sub(sq.lastOpen) > callHangTime
+		}
+
+		if sinceGrant > v.purgeTime || (sinceGrant > callHangTime && squelchClosed) {
+			events = append(events, v.newEvent(call.EventTypeEnd, ac, now))
+			delete(v.calls, tgid)
+		}
+	}
+	v.mu.Unlock()
+
+	v.emitAll(events)
+}
+
+// CallForTalkGroup returns the most recently published snapshot of a talkgroup's active call, or nil if it has
+// none.  The record is shared and must not be modified.
+func (v *VoiceManager) CallForTalkGroup(tgid int) *call.Record {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if ac, ok := v.calls[tgid]; ok {
+		return ac.published
+	}
+	return nil
+}
+
+// ActiveCalls returns a snapshot of the calls currently in progress.
+func (v *VoiceManager) ActiveCalls() []*call.Record {
+	v.mu.RLock()
+	ret := make([]*call.Record, 0, len(v.calls))
+	for _, ac := range v.calls {
+		ret = append(ret, ac.snapshot())
+	}
+	v.mu.RUnlock()
+	return ret
+}
+
+func (v *VoiceManager) emitAll(events []*call.Event) {
+	if v.emit == nil {
+		return
+	}
+	for _, ev := range events {
+		v.emit(ev)
+	}
+}
+
+// ExpireCalls expires calls for every system.
+func (s *SystemManager) ExpireCalls(now time.Time) {
+	s.mu.Lock()
+	vms := make([]*VoiceManager, 0, len(s.VMs))
+	for _, vm := range s.VMs {
+		vms = append(vms, vm)
+	}
+	s.mu.Unlock()
+
+	for _, vm := range vms {
+		vm.ExpireCalls(now)
+	}
+}
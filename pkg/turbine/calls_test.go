@@ -0,0 +1,108 @@
+package turbine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/rs/zerolog"
+)
+
+func TestCallLifecycle(t *testing.T) {
+	var events []*call.Event
+	vm := NewVoiceManager(604, func(ev *call.Event) {
+		events = append(events, ev)
+	})
+
+	grant := op25.DataPacket{DestTGID: 1200, SrcID: 34512, TargetFreq: 851412500, SystemID: 604}
+	vm.UpdateGroup(grant)
+	vm.UpdateGroup(grant)
+
+	grant.SrcID = 34513
+	grant.Emergency = true
+	vm.UpdateGroup(grant)
+
+	vm.UpdateSquelch(grant.TargetFreq, true, call.Signal{LevelDB: -20})
+
+	if calls := vm.ActiveCalls(); len(calls) != 1 {
+		t.Fatalf("expected 1 active call, got %d", len(calls))
+	}
+
+	// Still inside the hang time: nothing should end.
+	vm.ExpireCalls(time.Now())
+
+	vm.ExpireCalls(time.Now().Add(vm.purgeTime + time.Second))
+
+	want := []call.EventType{call.EventTypeStart, call.EventTypeUpdate, call.EventTypeEnd}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, ev := range events {
+		if ev.Type != want[i] {
+			t.Errorf("event %d: got %s want %s", i, ev.Type, want[i])
+		}
+	}
+
+	end := events[2].Call
+	if got := end.SourceIDs(); len(got) != 2 || got[0] != 34512 || got[1] != 34513 {
+		t.Errorf("unexpected sources %v", got)
+	}
+	if !end.Emergency || end.Encrypted {
+		t.Errorf("unexpected flags emergency=%v encrypted=%v", end.Emergency, end.Encrypted)
+	}
+	if end.EndTime == nil {
+		t.Errorf("end event missing end time")
+	}
+	if end.Signal.LevelDB != -20 {
+		t.Errorf("got level %f want -20", end.Signal.LevelDB)
+	}
+}
+
+func TestCallEndsOnFrequencyReuse(t *testing.T) {
+	var events []*call.Event
+	vm := NewVoiceManager(604, func(ev *call.Event) {
+		events = append(events, ev)
+	})
+
+	vm.UpdateGroup(op25.DataPacket{DestTGID: 1200, SrcID: 1, TargetFreq: 851412500, SystemID: 604})
+	vm.UpdateGroup(op25.DataPacket{DestTGID: 1300, SrcID: 2, TargetFreq: 851412500, SystemID: 604})
+
+	if len(events) != 3 {
+		t.Fatalf("got %d events, want 3", len(events))
+	}
+	if events[1].Type != call.EventTypeEnd || events[1].Call.TalkGroupID != 1200 {
+		t.Errorf("expected call on 1200 to end, got %s on %d", events[1].Type, events[1].Call.TalkGroupID)
+	}
+	if events[2].Type != call.EventTypeStart || events[2].Call.TalkGroupID != 1300 {
+		t.Errorf("expected call on 1300 to start, got %s on %d", events[2].Type, events[2].Call.TalkGroupID)
+	}
+}
+
+func TestIgnoredTalkGroupNotDemodulated(t *testing.T) {
+	tb, err := NewTurbine(nil, Options{
+		CenterFreq:            852000000,
+		SampleRate:            1000000,
+		VoiceOutputSampleRate: 8000,
+		Systems: []config.System{{
+			ID:            604,
+			SystemType:    op25.SystemTypeSmartnet,
+			SymbolRate:    3600,
+			TalkgroupDeny: []int{1300},
+		}},
+	}, WithLogger(zerolog.Nop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tb.appendVoiceFrequency(604, 1300, 851762500)
+	if len(tb.voiceFreqs) != 0 {
+		t.Errorf("ignored talkgroup given a voice channel: %+v", tb.voiceFreqs)
+	}
+
+	tb.appendVoiceFrequency(604, 1200, 851762500)
+	if len(tb.voiceFreqs) != 1 || tb.voiceFreqs[0].Frequency != 851762500 {
+		t.Errorf("unexpected voice channels %+v", tb.voiceFreqs)
+	}
+}
@@ -0,0 +1,312 @@
+package catalog
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Action determines which outputs receive a talkgroup's audio.
+type Action uint8
+
+const (
+	ActionStream Action = 1 << iota
+	ActionRecord
+
+	ActionIgnore Action = 0
+	ActionAll           = ActionStream | ActionRecord
+)
+
+func ParseAction(s string) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "stream":
+		return ActionStream, nil
+	case "record":
+		return ActionRecord, nil
+	case "", "all", "both", "stream+record", "record+stream":
+		return ActionAll, nil
+	case "ignore", "none":
+		return ActionIgnore, nil
+	default:
+		return ActionIgnore, fmt.Errorf("unknown talkgroup action %q", s)
+	}
+}
+
+func (a Action) Has(other Action) bool {
+	return a&other != 0
+}
+
+func (a Action) String() string {
+	switch a {
+	case ActionStream:
+		return "stream"
+	case ActionRecord:
+		return "record"
+	case ActionAll:
+		return "all"
+	default:
+		return "ignore"
+	}
+}
+
+func (a Action) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// Talkgroup is a catalog entry.  Entries are shared and must not be modified.
+type Talkgroup struct {
+	ID          int    `json:"id"`
+	Mode        string `json:"mode,omitempty"`
+	AlphaTag    string `json:"alpha_tag,omitempty"`
+	Description string `json:"description,omitempty"`
+	Tag         string `json:"tag,omitempty"`
+	Category    string `json:"category,omitempty"`
+	Priority    int    `json:"priority,omitempty"`
+	Action      Action `json:"action"`
+}
+
+type Options struct {
+	// File is a talkgroup CSV.  It may be empty, in which case only the default action and the
+	// allow/deny lists apply.
+	File string
+	// DefaultAction applies to talkgroups that are not in the file, or whose row has no action.
+	DefaultAction Action
+	// Allow, if not empty, ignores every talkgroup not listed.
+	Allow []int
+	// Deny ignores every talkgroup listed.
+	Deny []int
+}
+
+// Catalog holds the talkgroups of a single system.  It is safe for concurrent use and can be reloaded
+// while in use.
+type Catalog struct {
+	opts    Options
+	allow   map[int]struct{}
+	deny    map[int]struct{}
+	mu      sync.RWMutex
+	entries map[int]*Talkgroup
+	modTime time.Time
+}
+
+func New(opts Options) (*Catalog, error) {
+	c := &Catalog{
+		opts:    opts,
+		allow:   make(map[int]struct{}),
+		deny:    make(map[int]struct{}),
+		entries: make(map[int]*Talkgroup),
+	}
+	for _, id := range opts.Allow {
+		c.allow[id] = struct{}{}
+	}
+	for _, id := range opts.Deny {
+		c.deny[id] = struct{}{}
+	}
+
+	if opts.File != "" {
+		if err := c.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Reload re-reads the catalog file.  On error the previous entries are kept.
+func (c *Catalog) Reload() error {
+	if c.opts.File == "" {
+		return nil
+	}
+
+	info, err := os.Stat(c.opts.File)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(c.opts.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	entries, err := Parse(f, c.opts.DefaultAction)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.opts.File, err)
+	}
+
+	c.mu.Lock()
+	c.entries = entries
+	c.modTime = info.ModTime()
+	c.mu.Unlock()
+	return nil
+}
+
+// ReloadIfChanged reloads the catalog if its file has been modified since it was last loaded.
+func (c *Catalog) ReloadIfChanged() (bool, error) {
+	if c.opts.File == "" {
+		return false, nil
+	}
+
+	info, err := os.Stat(c.opts.File)
+	if err != nil {
+		return false, err
+	}
+
+	c.mu.RLock()
+	changed := !info.ModTime().Equal(c.modTime)
+	c.mu.RUnlock()
+
+	if !changed {
+		return false, nil
+	}
+	return true, c.Reload()
+}
+
+// Lookup returns the catalog entry for a talkgroup, or nil if there is none.
+func (c *Catalog) Lookup(tgid int) *Talkgroup {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.entries[tgid]
+}
+
+// ActionFor returns the action for a talkgroup after applying the allow and deny lists.
+func (c *Catalog) ActionFor(tgid int) Action {
+	if _, ok := c.deny[tgid]; ok {
+		return ActionIgnore
+	}
+	if _, ok := c.allow[tgid]; len(c.allow) > 0 && !ok {
+		return ActionIgnore
+	}
+	if tg := c.Lookup(tgid); tg != nil {
+		return tg.Action
+	}
+	return c.opts.DefaultAction
+}
+
+// Len returns the number of talkgroups in the catalog.
+func (c *Catalog) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+type column int
+
+const (
+	colDecimal column = iota
+	colHex
+	colMode
+	colAlphaTag
+	colDescription
+	colTag
+	colCategory
+	colPriority
+	colAction
+	numColumns
+)
+
+// defaultColumns is the common scanner layout, used when the file has no header row.
+var defaultColumns = []column{colDecimal, colHex, colMode, colAlphaTag, colDescription, colTag, colCategory, colPriority, colAction}
+
+var headerNames = map[string]column{
+	"decimal":     colDecimal,
+	"dec":         colDecimal,
+	"hex":         colHex,
+	"mode":        colMode,
+	"alpha tag":   colAlphaTag,
+	"alphatag":    colAlphaTag,
+	"description": colDescription,
+	"tag":         colTag,
+	"category":    colCategory,
+	"group":       colCategory,
+	"priority":    colPriority,
+	"action":      colAction,
+}
+
+// Parse reads a talkgroup CSV.  Columns are matched by header name if the first row is a header, and
+// otherwise are taken to be Decimal, Hex, Mode, Alpha Tag, Description, Tag, Category, Priority and an
+// optional Action (stream, record, all or ignore).
+func Parse(r io.Reader, defaultAction Action) (map[int]*Talkgroup, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := defaultColumns
+	if len(rows) > 0 && isHeader(rows[0]) {
+		columns = make([]column, len(rows[0]))
+		for i, name := range rows[0] {
+			col, ok := headerNames[strings.ToLower(strings.TrimSpace(name))]
+			if !ok {
+				col = numColumns
+			}
+			columns[i] = col
+		}
+		rows = rows[1:]
+	}
+
+	entries := make(map[int]*Talkgroup, len(rows))
+	for lineIdx, row := range rows {
+		var fields [numColumns]string
+		for i, value := range row {
+			if i < len(columns) && columns[i] < numColumns {
+				fields[columns[i]] = strings.TrimSpace(value)
+			}
+		}
+
+		tg := &Talkgroup{
+			Mode:        fields[colMode],
+			AlphaTag:    fields[colAlphaTag],
+			Description: fields[colDescription],
+			Tag:         fields[colTag],
+			Category:    fields[colCategory],
+			Action:      defaultAction,
+		}
+
+		switch {
+		case fields[colDecimal] != "":
+			tg.ID, err = strconv.Atoi(fields[colDecimal])
+		case fields[colHex] != "":
+			var id int64
+			id, err = strconv.ParseInt(strings.TrimPrefix(strings.ToLower(fields[colHex]), "0x"), 16, 32)
+			tg.ID = int(id)
+		default:
+			err = fmt.Errorf("missing talkgroup ID")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", lineIdx+1, err)
+		}
+
+		if fields[colPriority] != "" {
+			if tg.Priority, err = strconv.Atoi(fields[colPriority]); err != nil {
+				return nil, fmt.Errorf("row %d: bad priority: %w", lineIdx+1, err)
+			}
+		}
+		if fields[colAction] != "" {
+			if tg.Action, err = ParseAction(fields[colAction]); err != nil {
+				return nil, fmt.Errorf("row %d: %w", lineIdx+1, err)
+			}
+		}
+
+		entries[tg.ID] = tg
+	}
+
+	return entries, nil
+}
+
+func isHeader(row []string) bool {
+	for _, field := range row {
+		if _, ok := headerNames[strings.ToLower(strings.TrimSpace(field))]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package catalog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[int]Talkgroup
+	}{{
+		"header",
+		"Decimal,Hex,Alpha Tag,Mode,Description,Tag,Category\n" +
+			"1200,4b0,PD Dispatch,D,Police Dispatch,Law Dispatch,Police\n",
+		map[int]Talkgroup{
+			1200: {ID: 1200, Mode: "D", AlphaTag: "PD Dispatch", Description: "Police Dispatch", Tag: "Law Dispatch", Category: "Police", Action: ActionAll},
+		},
+	}, {
+		"positional with action",
+		"1200,4b0,D,PD Dispatch,Police Dispatch,Law Dispatch,Police,2,record\n" +
+			",0x4c0,A,FD Tac,,,Fire,,ignore\n",
+		map[int]Talkgroup{
+			1200: {ID: 1200, Mode: "D", AlphaTag: "PD Dispatch", Description: "Police Dispatch", Tag: "Law Dispatch", Category: "Police", Priority: 2, Action: ActionRecord},
+			1216: {ID: 1216, Mode: "A", AlphaTag: "FD Tac", Category: "Fire", Action: ActionIgnore},
+		},
+	}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(strings.NewReader(tt.input), ActionAll)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d talkgroups, want %d", len(got), len(tt.want))
+			}
+			for id, want := range tt.want {
+				if tg, ok := got[id]; !ok || *tg != want {
+					t.Errorf("talkgroup %d: got %+v, want %+v", id, tg, want)
+				}
+			}
+		})
+	}
+}
+
+func TestActionFor(t *testing.T) {
+	c, err := New(Options{DefaultAction: ActionStream, Allow: []int{1200, 1216}, Deny: []int{1216}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for tgid, want := range map[int]Action{1200: ActionStream, 1216: ActionIgnore, 1232: ActionIgnore} {
+		if got := c.ActionFor(tgid); got != want {
+			t.Errorf("talkgroup %d: got %s, want %s", tgid, got, want)
+		}
+	}
+}
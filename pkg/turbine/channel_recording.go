@@ -0,0 +1,313 @@
+package turbine
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"github.com/norasector/turbine/pkg/turbine/device/sigmf"
+	"github.com/rs/zerolog"
+)
+
+// channelRecorder records chosen channels' samples after their lowpass decimator, where they're centered and
+// narrow enough to attach to a bug report, while they go on being decoded.  Channels are recorded the whole
+// time they're demodulated if their frequency is chosen, or for the length of each call on a chosen
+// talkgroup.  Either way the calls heard are annotated.
+type channelRecorder struct {
+	directory   string
+	frequencies map[int]struct{}
+	talkgroups  map[int]struct{}
+	// info describes the device as it is when each recording starts, since its gain can change.
+	info   func() device.Info
+	logger zerolog.Logger
+
+	lock sync.Mutex
+	taps map[int]*channelTap
+}
+
+// deviceInfo describes the device, if it can describe itself.
+func (t *Turbine) deviceInfo() device.Info {
+	if describer, ok := t.device.(device.Describer); ok {
+		return describer.Info()
+	}
+	return device.Info{}
+}
+
+// deviceFormat is the format the device's samples arrive in, or cf32 if they don't come in one.
+func (t *Turbine) deviceFormat() iq.Format {
+	if formatter, ok := t.device.(device.Formatter); ok {
+		return formatter.Format()
+	}
+	return iq.FormatCF32
+}
+
+// newChannelRecorder returns nil if channel recording isn't configured.
+func newChannelRecorder(cfg config.ChannelRecording, info func() device.Info, logger zerolog.Logger) *channelRecorder {
+	if cfg.Directory == "" {
+		return nil
+	}
+	r := &channelRecorder{
+		directory:   cfg.Directory,
+		frequencies: make(map[int]struct{}),
+		talkgroups:  make(map[int]struct{}),
+		info:        info,
+		logger:      logger,
+		taps:        make(map[int]*channelTap),
+	}
+	for _, freq := range cfg.Frequencies {
+		r.frequencies[freq] = struct{}{}
+	}
+	for _, tg := range cfg.TalkGroups {
+		r.talkgroups[tg] = struct{}{}
+	}
+	return r
+}
+
+// tap returns the block to add after a channel's lowpass decimator, or nil if there's no recorder.  Its
+// recording starts now if its frequency is chosen.
+func (r *channelRecorder) tap(systemID, freq, sampleRate int) *channelTap {
+	if r == nil {
+		return nil
+	}
+	tap := r.tapFor(freq)
+	tap.lock.Lock()
+	defer tap.lock.Unlock()
+	tap.systemID = systemID
+	tap.sampleRate = sampleRate
+	if _, ok := r.frequencies[freq]; ok && tap.pending == "" && tap.rec == nil {
+		tap.pending = filepath.Join(r.directory,
+			strconv.Itoa(systemID),
+			strconv.Itoa(freq),
+			time.Now().UTC().Format("20060102T150405Z"))
+	}
+	return tap
+}
+
+// tapFor returns the tap on freq, creating it if it's not been demodulated yet.
+func (r *channelRecorder) tapFor(freq int) *channelTap {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	tap, ok := r.taps[freq]
+	if !ok {
+		tap = &channelTap{
+			recorder:  r,
+			frequency: freq,
+			calls:     make(map[string]heardCall),
+		}
+		r.taps[freq] = tap
+	}
+	return tap
+}
+
+// callEvent starts recording calls on chosen talkgroups, and annotates calls on channels being recorded.
+func (r *channelRecorder) callEvent(ev *call.Event) {
+	if r == nil {
+		return
+	}
+	rec := ev.Call
+	tap := r.tapFor(rec.Frequency)
+	tap.lock.Lock()
+	defer tap.lock.Unlock()
+
+	if heard, ok := tap.calls[rec.ID]; ok {
+		heard.rec = rec
+		tap.calls[rec.ID] = heard
+	}
+	switch ev.Type {
+	case call.EventTypeStart:
+		if _, ok := r.talkgroups[rec.TalkGroupID]; ok && tap.pending == "" && tap.rec == nil {
+			tap.pending = filepath.Join(r.directory,
+				strconv.Itoa(rec.SystemID),
+				strconv.Itoa(rec.TalkGroupID),
+				rec.StartTime.UTC().Format("2006-01-02"),
+				rec.ID)
+			tap.until = rec.ID
+		}
+		if tap.rec != nil || tap.pending != "" {
+			tap.calls[rec.ID] = heardCall{start: tap.samples(), rec: rec}
+		}
+	case call.EventTypeEnd:
+		heard, ok := tap.calls[rec.ID]
+		if !ok {
+			return
+		}
+		delete(tap.calls, rec.ID)
+		if tap.rec != nil {
+			tap.rec.Annotate(callAnnotation(rec, heard.start, tap.rec.Samples()))
+		}
+		if tap.until == rec.ID {
+			tap.closeLocked()
+		}
+	}
+}
+
+// closeChannel ends the recording of a channel that's no longer demodulated.
+func (r *channelRecorder) closeChannel(freq int) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	tap := r.taps[freq]
+	r.lock.Unlock()
+	if tap == nil {
+		return
+	}
+	tap.lock.Lock()
+	defer tap.lock.Unlock()
+	tap.closeLocked()
+}
+
+// close ends every recording.
+func (r *channelRecorder) close() {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, tap := range r.taps {
+		tap.lock.Lock()
+		tap.closeLocked()
+		tap.lock.Unlock()
+	}
+}
+
+func callAnnotation(rec *call.Record, start, end int64) sigmf.Annotation {
+	label := rec.AlphaTag()
+	if label == "" {
+		label = "TG " + strconv.Itoa(rec.TalkGroupID)
+	}
+	sources := make([]string, 0, len(rec.Sources))
+	for _, id := range rec.SourceIDs() {
+		sources = append(sources, strconv.Itoa(id))
+	}
+	comment := fmt.Sprintf("call %s on system %d, talkgroup %d, sources %s", rec.ID, rec.SystemID, rec.TalkGroupID,
+		strings.Join(sources, ", "))
+	if rec.Emergency {
+		comment += ", emergency"
+	}
+	if rec.Encrypted {
+		comment += ", encrypted"
+	}
+	return sigmf.Annotation{
+		SampleStart: start,
+		SampleCount: end - start,
+		Label:       label,
+		Comment:     comment,
+	}
+}
+
+// channelTap passes a channel's samples through unchanged, recording them while it's been asked to.
+type channelTap struct {
+	recorder  *channelRecorder
+	frequency int
+
+	lock       sync.Mutex
+	systemID   int
+	sampleRate int
+	rec        *sigmf.Writer
+	// pending is the path of a recording to start with the next samples.
+	pending string
+	// until is the call whose end ends the recording, if it's recording a call.
+	until string
+	// calls are the calls heard during the recording, by ID.
+	calls map[string]heardCall
+}
+
+// heardCall is a call heard on a channel being recorded.
+type heardCall struct {
+	rec *call.Record
+	// start is the sample the call started at.
+	start int64
+}
+
+func (c *channelTap) PredictOutputSize(inputSize int) int {
+	return inputSize
+}
+
+func (c *channelTap) WorkBuffer(input, output []complex64) int {
+	copy(output, input)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.pending != "" {
+		c.open()
+	}
+	if c.rec != nil {
+		if err := c.rec.Write(input); err != nil {
+			c.recorder.logger.Error().Err(err).Str("frequency", op25.MHzToString(c.frequency)).Msg("failed to record channel")
+			c.closeLocked()
+		}
+	}
+	return len(input)
+}
+
+func (c *channelTap) Work(data []complex64) []complex64 {
+	ret := make([]complex64, len(data))
+	c.WorkBuffer(data, ret)
+	return ret
+}
+
+// open starts the pending recording.  Samples are recorded as cf32, since they're no longer whole numbers.
+func (c *channelTap) open() {
+	path := c.pending
+	c.pending = ""
+	now := time.Now().UTC()
+	info := c.recorder.info()
+	capture := sigmf.Capture{
+		Frequency: float64(c.frequency),
+		DateTime:  &now,
+	}
+	if info.Gain != 0 {
+		capture.Gain = &info.Gain
+	}
+	rec, err := sigmf.Create(path, iq.FormatCF32, sigmf.Global{
+		SampleRate:  float64(c.sampleRate),
+		Hardware:    info.Name,
+		Description: fmt.Sprintf("System %d channel %s", c.systemID, op25.MHzToString(c.frequency)),
+	}, capture)
+	if err != nil {
+		c.recorder.logger.Error().Err(err).Str("path", path).Msg("failed to start channel recording")
+		c.until = ""
+		c.calls = make(map[string]heardCall)
+		return
+	}
+	c.recorder.logger.Info().Str("frequency", op25.MHzToString(c.frequency)).Str("path", path).Msg("recording channel")
+	c.rec = rec
+}
+
+// samples is how many samples have been recorded, which is none if the recording hasn't started yet.
+func (c *channelTap) samples() int64 {
+	if c.rec == nil {
+		return 0
+	}
+	return c.rec.Samples()
+}
+
+// closeLocked ends the recording, annotating the calls still going on as they are so far.
+func (c *channelTap) closeLocked() {
+	defer func() {
+		c.pending = ""
+		c.until = ""
+		c.calls = make(map[string]heardCall)
+	}()
+	if c.rec == nil {
+		return
+	}
+	for _, heard := range c.calls {
+		if heard.rec != nil {
+			c.rec.Annotate(callAnnotation(heard.rec, heard.start, c.rec.Samples()))
+		}
+	}
+	if err := c.rec.Close(); err != nil {
+		c.recorder.logger.Error().Err(err).Str("frequency", op25.MHzToString(c.frequency)).Msg("failed to finish channel recording")
+	}
+	c.rec = nil
+}
@@ -0,0 +1,97 @@
+package turbine
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/sigmf"
+	"github.com/rs/zerolog"
+)
+
+func callEvent(typ call.EventType, id string, freq, tgid int, start time.Time) *call.Event {
+	return &call.Event{
+		Type: typ,
+		Call: &call.Record{ID: id, SystemID: 604, TalkGroupID: tgid, Frequency: freq, StartTime: start},
+	}
+}
+
+func testDeviceInfo() device.Info {
+	return device.Info{Name: "test"}
+}
+
+// readRecording returns a channel recording's meta and how many samples it holds.
+func readRecording(t *testing.T, path string) (*sigmf.Meta, int64) {
+	t.Helper()
+	metaPath, dataPath := sigmf.Paths(path)
+	meta, err := sigmf.ReadMeta(metaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := os.Stat(dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return meta, stat.Size() / 8
+}
+
+func TestChannelRecording(t *testing.T) {
+	const (
+		controlFreq = 851612500
+		voiceFreq   = 851762500
+		sampleRate  = 12500
+	)
+	dir := t.TempDir()
+	r := newChannelRecorder(config.ChannelRecording{
+		Directory:   dir,
+		Frequencies: []int{controlFreq},
+		TalkGroups:  []int{0x2a30},
+	}, testDeviceInfo, zerolog.Nop())
+	start := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// The control channel is recorded from the start, and annotated with the calls heard on it, including one
+	// still going when the recording closes.
+	control := r.tap(604, controlFreq, sampleRate)
+	control.Work(make([]complex64, 10))
+	r.callEvent(callEvent(call.EventTypeStart, "a", controlFreq, 0x100, start))
+	control.Work(make([]complex64, 10))
+	r.callEvent(callEvent(call.EventTypeEnd, "a", controlFreq, 0x100, start))
+	r.callEvent(callEvent(call.EventTypeStart, "c", controlFreq, 0x200, start))
+	control.Work(make([]complex64, 5))
+
+	// The voice channel's call is on a chosen talkgroup, and starts before the channel is demodulated.
+	r.callEvent(callEvent(call.EventTypeStart, "b", voiceFreq, 0x2a30, start))
+	voice := r.tap(604, voiceFreq, sampleRate)
+	voice.Work(make([]complex64, 7))
+	r.callEvent(callEvent(call.EventTypeEnd, "b", voiceFreq, 0x2a30, start))
+	voice.Work(make([]complex64, 3))
+
+	r.close()
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "604", "851612500", "*"+sigmf.MetaExtension))
+	if len(matches) != 1 {
+		t.Fatalf("found control channel recordings %v", matches)
+	}
+	meta, samples := readRecording(t, matches[0])
+	if samples != 25 || meta.Global.SampleRate != sampleRate || meta.Global.Datatype != sigmf.DatatypeCF32 ||
+		meta.Captures[0].Frequency != controlFreq || meta.Global.Hardware != "test" {
+		t.Errorf("unexpected control channel recording of %d samples %+v", samples, meta)
+	}
+	if len(meta.Annotations) != 2 || meta.Annotations[0].SampleStart != 10 || meta.Annotations[0].SampleCount != 10 ||
+		meta.Annotations[1].SampleStart != 20 || meta.Annotations[1].SampleCount != 5 || meta.Annotations[1].Label != "TG 512" {
+		t.Errorf("unexpected control channel annotations %+v", meta.Annotations)
+	}
+
+	meta, samples = readRecording(t, filepath.Join(dir, "604", "10800", "2022-01-02", "b"))
+	if samples != 7 || meta.Captures[0].Frequency != voiceFreq {
+		t.Errorf("unexpected call recording of %d samples %+v", samples, meta)
+	}
+	if len(meta.Annotations) != 1 || meta.Annotations[0].SampleStart != 0 || meta.Annotations[0].SampleCount != 7 ||
+		meta.Annotations[0].Label != "TG 10800" {
+		t.Errorf("unexpected call annotations %+v", meta.Annotations)
+	}
+}
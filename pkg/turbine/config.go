@@ -4,23 +4,42 @@ import (
 	"time"
 
 	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/turbine/catalog"
 	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/units"
 )
 
 type Options struct {
 	CenterFreq            int
 	SampleRate            int
+	PPM                   float64
+	FixedPPM              bool
 	VoiceOutputSampleRate int
 	Gain                  int
 	Squelch               int
 	Systems               []config.System
 	AudioOutputs          []AudioOutput
+	EventSinks            []EventSink
+	IQOutputs             []config.IQOutput
+	ChannelRecording      config.ChannelRecording
+	Snapshots             config.Snapshots
 	FrequencyTimeout      time.Duration
 	RecordLocation        string
 	PlaybackLocation      string
+	// DeviceSettings are applied to the device before it starts.
+	DeviceSettings device.Settings
+	FrontEndAGC    config.FrontEndAGC
+	// DeviceSupervisor reopens the device when it fails or its samples stop.
+	DeviceSupervisor config.DeviceSupervisor
 }
 
 type internalSystem struct {
 	config.System
 	dataPacketChan chan op25.OSWPacket
+	catalog        *catalog.Catalog
+	units          *units.Store
+	// squelchLevel is the squelch threshold for the system's voice channels, which may be changed at runtime.
+	// Accessed atomically.
+	squelchLevel int32
 }
@@ -4,11 +4,16 @@ import (
 	"time"
 
 	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/turbine/device"
 )
 
 type Config struct {
-	CenterFreq            int                 `yaml:"center_freq"`
-	SampleRate            int                 `yaml:"sample_rate"`
+	CenterFreq int `yaml:"center_freq"`
+	SampleRate int `yaml:"sample_rate"`
+	// PPM is the device's known frequency error in parts per million, positive if its oscillator runs fast.
+	// It's refined from the control channels unless FixedPPM is set.
+	PPM                   float64             `yaml:"ppm"`
+	FixedPPM              bool                `yaml:"fixed_ppm"`
 	VoiceSampleOutputRate int                 `yaml:"output_rate"`
 	Systems               []System            `yaml:"systems"`
 	OutputDestinations    []OutputDestination `yaml:"output_destinations"`
@@ -21,11 +26,210 @@ type Config struct {
 		Port           int           `yaml:"port"`
 		UpdateInterval time.Duration `yaml:"update_interval_ms"`
 	} `yaml:"viz_server"`
+	API struct {
+		// Port serves the JSON status and control API.  The API is disabled if it's zero.
+		Port int `yaml:"port"`
+		// Address is the interface the API listens on.  Defaults to 127.0.0.1, as the API is unauthenticated and
+		// can change what's received.
+		Address string `yaml:"address"`
+	} `yaml:"api"`
 	InfluxDB struct {
 		Host         string `yaml:"host"`
 		Organization string `yaml:"organization"`
 		Bucket       string `yaml:"bucket"`
+		// Interval is how often metrics are written.  Defaults to 10s.
+		Interval time.Duration `yaml:"interval"`
 	}
+	Prometheus struct {
+		// Port serves metrics for Prometheus to scrape at /metrics.  Disabled if it's zero.
+		Port int `yaml:"port"`
+	} `yaml:"prometheus"`
+	CallEvents CallEvents `yaml:"call_events"`
+	Recorder   Recorder   `yaml:"recorder"`
+	Synthetic  Synthetic  `yaml:"synthetic"`
+	RTLTCP     RTLTCP     `yaml:"rtl_tcp"`
+	IQStream   IQStream   `yaml:"iq_stream"`
+	IQOutputs  []IQOutput `yaml:"iq_outputs"`
+	// DeviceSettings are the device's gains, amp, bias-tee and so on, which can also be changed through the API.
+	// Settings left out keep the device's defaults.
+	DeviceSettings device.Settings `yaml:"device_settings"`
+	// FrontEndAGC adjusts the device's gains to keep its samples from clipping.
+	FrontEndAGC FrontEndAGC `yaml:"front_end_agc"`
+	// DeviceSupervisor reopens the device when it fails or its samples stop.
+	DeviceSupervisor DeviceSupervisor `yaml:"device_supervisor"`
+	// ChannelRecording records chosen channels' IQ, which is far smaller than the whole band's.
+	ChannelRecording ChannelRecording `yaml:"channel_recording"`
+	// Snapshots write out the samples from just before a decode failure or emergency.
+	Snapshots Snapshots `yaml:"snapshots"`
+	// PlaybackFormat is the sample format of a raw playback file: cs8 (HackRF, the default), cu8 (RTL-SDR),
+	// cs16 (Airspy, SDRplay) or cf32 (GNU Radio).  SigMF and WAV recordings say their own.
+	PlaybackFormat string `yaml:"playback_format"`
+	// PlaybackStart and PlaybackEnd play only the part of the recording between them, such as "90s".
+	PlaybackStart time.Duration `yaml:"playback_start"`
+	PlaybackEnd   time.Duration `yaml:"playback_end"`
+	// PlaybackLoop plays the recording over and over rather than exiting at its end.
+	PlaybackLoop bool `yaml:"playback_loop"`
+	// PlaybackFast plays the recording as fast as it can be decoded rather than in real time.
+	PlaybackFast bool `yaml:"playback_fast"`
+}
+
+// RTLTCP configures the rtl_tcp server the rtl_tcp device takes its samples from.
+type RTLTCP struct {
+	// Address is the server's host:port.  Defaults to 127.0.0.1:1234, where rtl_tcp listens by default.
+	Address string `yaml:"address"`
+	// Gain is the tuner gain in dB.  Zero leaves it to the tuner's automatic gain control.
+	Gain float64 `yaml:"gain"`
+	// PPM is a frequency correction the dongle applies itself, in parts per million.  The top level ppm is
+	// applied on top of it.
+	PPM int `yaml:"ppm"`
+}
+
+// IQStream configures where the IQ stream device takes its frames of samples from.
+type IQStream struct {
+	// Network is "tcp" to connect to a sender, such as another Turbine's IQ output, or "udp" to listen for
+	// datagrams.  Defaults to tcp.
+	Network string `yaml:"network"`
+	// Address is the sender's host:port for tcp, or the address to listen on for udp.
+	Address string `yaml:"address"`
+	// Format is the samples' format: cs8 (the default), cu8, cs16 or cf32.
+	Format string `yaml:"format"`
+}
+
+// IQOutput re-sends the device's samples to TCP clients, in the frames the iq_stream device takes.
+type IQOutput struct {
+	// Port is listened on for clients.
+	Port int `yaml:"port"`
+	// Format is the samples' format: cs8 (the default), cu8, cs16 or cf32.
+	Format string `yaml:"format"`
+	// Decimation, if more than 1, sends only the sub-band around CenterFreq, at the device's sample rate divided
+	// by Decimation.  CenterFreq defaults to the device's.
+	Decimation int `yaml:"decimation"`
+	CenterFreq int `yaml:"center_freq"`
+}
+
+// ChannelRecording records channels' samples after their first decimation, as SigMF annotated with the calls
+// heard on them.  Nothing is recorded unless Directory is set.
+type ChannelRecording struct {
+	Directory string `yaml:"directory"`
+	// Frequencies are control or voice channels recorded the whole time they're demodulated.
+	Frequencies []int `yaml:"frequencies,flow"`
+	// TalkGroups have each of their calls recorded, in any system.
+	TalkGroups []int `yaml:"talkgroups,flow"`
+}
+
+// Snapshots keeps the last few seconds of samples in memory, to be written out as SigMF when something odd
+// happens.  Nothing is kept unless Directory is set.
+type Snapshots struct {
+	Directory string `yaml:"directory"`
+	// Duration is how much is kept.  Defaults to 10s.
+	Duration time.Duration `yaml:"duration"`
+	// Frequencies, if set, keeps only these channels' samples after their first decimation rather than the whole
+	// band's, which take 2 bytes per sample: 160MB for 10s at 8 Msps.
+	Frequencies []int `yaml:"frequencies,flow"`
+	// OnEmergency takes a snapshot when a call is first flagged as an emergency.
+	OnEmergency bool `yaml:"on_emergency"`
+	// CRCFailures, if set, takes a snapshot when a control channel fails this many CRCs within a second.
+	CRCFailures int `yaml:"crc_failures"`
+}
+
+// FrontEndAGC steps the device's gains to keep its samples from clipping while leaving them as strong as they
+// can be.
+type FrontEndAGC struct {
+	Enabled bool `yaml:"enabled"`
+	// Stages are the gain stages adjusted, raised in this order and lowered in the reverse.  Defaults to all the
+	// device's, over their whole range.
+	Stages []FrontEndAGCStage `yaml:"stages"`
+	// MaxClipping is the fraction of samples that can be at full scale before the gain is lowered.  Defaults to
+	// 0.001.
+	MaxClipping float64 `yaml:"max_clipping"`
+	// Headroom is how far below full scale the loudest 0.1% of samples are kept, in dB.  The gain is raised once
+	// they're more than a step below that.  Defaults to 6.
+	Headroom float64 `yaml:"headroom"`
+	// Interval is how long the samples are measured for between adjustments.  Defaults to 1s.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// DeviceSupervisor reopens the device when it fails or stops delivering samples, while everything else carries
+// on.
+type DeviceSupervisor struct {
+	// Disabled exits when the device fails instead.
+	Disabled bool `yaml:"disabled"`
+	// StallTimeout is how long the device can go without delivering samples before it's reopened.  Defaults to
+	// 5s.  Devices that reconnect by themselves, such as rtl_tcp, are only reopened when they fail.
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+	// MinBackoff and MaxBackoff bound the wait before reopening, which doubles while the device keeps failing.
+	// They default to 1s and 30s.
+	MinBackoff time.Duration `yaml:"min_backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// FrontEndAGCStage limits the front end AGC's adjustment of a gain stage, in dB.
+type FrontEndAGCStage struct {
+	Name string  `yaml:"name"`
+	Min  float64 `yaml:"min"`
+	// Max defaults to the stage's maximum.
+	Max float64 `yaml:"max"`
+	// Step defaults to the stage's own step, or 2 dB if it has none.
+	Step float64 `yaml:"step"`
+}
+
+// Synthetic configures the signals the synthetic device generates, in place of a radio.
+type Synthetic struct {
+	// Noise is the standard deviation of the noise added to each of I and Q, in 8-bit device units.
+	Noise float64 `yaml:"noise"`
+	// Seed seeds the noise.  Runs with the same seed and config generate the same samples.
+	Seed            int64                     `yaml:"seed"`
+	ControlChannels []SyntheticControlChannel `yaml:"control_channels"`
+	Calls           []SyntheticCall           `yaml:"calls"`
+	// Fast generates samples as fast as they're taken rather than in real time.  Calls keep to the samples'
+	// time, so they run faster too.
+	Fast bool `yaml:"fast"`
+}
+
+// SyntheticControlChannel is a SmartNet control channel, which idles until it has a call to grant.
+type SyntheticControlChannel struct {
+	Frequency int `yaml:"freq"`
+	// Amplitude is in 8-bit device units, up to 127.  Defaults to 40.
+	Amplitude float64 `yaml:"amplitude"`
+}
+
+// SyntheticCall is a call granted on a control channel and carried on an FM voice channel.
+type SyntheticCall struct {
+	// ControlFrequency is the control channel that grants the call.  Defaults to the first one.
+	ControlFrequency int `yaml:"control_freq"`
+	Frequency        int `yaml:"freq"`
+	TalkGroupID      int `yaml:"talkgroup"`
+	SourceID         int `yaml:"source"`
+	// Amplitude is in 8-bit device units, up to 127.  Defaults to 40.
+	Amplitude float64 `yaml:"amplitude"`
+	// ToneFreq is the tone the carrier holds, in Hz, unless WAVFile is set.  Defaults to 1000.
+	ToneFreq float64 `yaml:"tone_freq"`
+	// WAVFile is a 16-bit PCM WAV file the carrier holds instead of a tone.  It repeats if it's shorter than the
+	// call.
+	WAVFile string `yaml:"wav_file"`
+	// Start is how long after the device starts the call is first granted.
+	Start time.Duration `yaml:"start"`
+	// Duration is how long the call lasts.  Defaults to 5s.
+	Duration time.Duration `yaml:"duration"`
+	// Interval, if set, repeats the call this long after each start.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// Recorder configures the per-call audio recorder.  Recording is disabled unless Directory is set.
+type Recorder struct {
+	Directory string `yaml:"directory"`
+	// Format is either "opus" (Ogg Opus, the default) or "wav".
+	Format    string        `yaml:"format"`
+	MaxAge    time.Duration `yaml:"max_age"`
+	MaxSizeMB int64         `yaml:"max_size_mb"`
+}
+
+// CallEvents configures where call start/update/end events are sent.
+type CallEvents struct {
+	// File, if set, is appended to with one JSON event per line.
+	File string `yaml:"file"`
+	// Destinations receive one JSON event per UDP datagram.
+	Destinations []OutputDestination `yaml:"destinations"`
 }
 
 type OutputDestination struct {
@@ -41,4 +245,24 @@ type System struct {
 	SymbolRate         int             `yaml:"symbol_rate"`
 	VoiceBandwidth     int             `yaml:"voice_bandwidth"`
 	SquelchLevel       int             `yaml:"squelch_level"`
+	// SoftDecision decodes control channels using the demodulator's confidence in each bit, which recovers
+	// more packets from weak sites at a little more CPU.
+	SoftDecision bool `yaml:"soft_decision"`
+	// OSWLog, if set, is appended to with every OSW decoded from the system's control channels, to be replayed
+	// with oswreplay.  Files ending .json or .jsonl are written as JSON lines, anything else as binary.
+	OSWLog string `yaml:"osw_log"`
+	// TalkgroupsFile is a talkgroup CSV in the common scanner layout, optionally with an Action column.
+	TalkgroupsFile string `yaml:"talkgroups_file"`
+	// TalkgroupDefaultAction is one of stream, record, all (the default) or ignore, and applies to talkgroups
+	// not in the catalog or with no action of their own.
+	TalkgroupDefaultAction string `yaml:"talkgroup_default_action"`
+	// TalkgroupAllow, if not empty, ignores every talkgroup not listed.
+	TalkgroupAllow []int `yaml:"talkgroup_allow,flow"`
+	// TalkgroupDeny ignores every talkgroup listed.
+	TalkgroupDeny []int `yaml:"talkgroup_deny,flow"`
+	// UnitAliasesFile is a CSV of unit IDs and aliases, imported on start.
+	UnitAliasesFile string `yaml:"unit_aliases_file"`
+	// UnitDatabase is a JSON file where aliases and what has been learned about each unit are kept across
+	// restarts.
+	UnitDatabase string `yaml:"unit_database"`
 }
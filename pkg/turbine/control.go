@@ -4,26 +4,37 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strconv"
+	"sync/atomic"
 	"time"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go"
 	"github.com/norasector/turbine-common/types"
 	"github.com/norasector/turbine/pkg/dsp/agc/rmsagc"
 	"github.com/norasector/turbine/pkg/dsp/demodulators/quad"
 	"github.com/norasector/turbine/pkg/dsp/filters/fir"
-	"github.com/norasector/turbine/pkg/dsp/mixer"
+	"github.com/norasector/turbine/pkg/dsp/meter"
 	"github.com/norasector/turbine/pkg/dsp/processor"
 	"github.com/norasector/turbine/pkg/op25"
 	"github.com/norasector/turbine/pkg/op25/frame"
 	"github.com/norasector/turbine/pkg/op25/frame/smartnet"
 	"github.com/norasector/turbine/pkg/op25/modem/fsk4"
 	"github.com/norasector/turbine/pkg/op25/slicer"
-	"github.com/norasector/turbine/pkg/util"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/feed"
 	"github.com/racerxdl/segdsp/dsp"
 )
 
 const (
 	ifRate = 18000 // target rate for processing symbols, 18000/3600=5
+
+	// controlHuntInterval is how often control channels are checked for retiring or bringing back.
+	controlHuntInterval = time.Second
+	// controlRetireTime is how long a control channel can go unhealthy, while another of its system's is
+	// healthy, before it's retired.
+	controlRetireTime = time.Minute
+	// controlRestoreTime is how long a system can go without a healthy control channel before its retired ones
+	// are brought back.
+	controlRestoreTime = 10 * time.Second
 )
 
 func NewControlFrequency(
@@ -33,10 +44,11 @@ func NewControlFrequency(
 ) *ControlFrequency {
 
 	f := &ControlFrequency{
-		SystemID:   sys.ID,
-		SymbolRate: sys.SymbolRate,
-		Frequency:  freq,
-		SystemType: sys.SystemType,
+		SystemID:    sys.ID,
+		SymbolRate:  sys.SymbolRate,
+		Frequency:   freq,
+		SystemType:  sys.SystemType,
+		lastHealthy: time.Now(),
 	}
 
 	f.init(t, sys)
@@ -60,6 +72,9 @@ func (freq *ControlFrequency) init(t *Turbine, sys *internalSystem) {
 }
 
 type ControlFrequency struct {
+	// sampleNum counts segments processed.  Accessed atomically, so kept first for alignment.
+	sampleNum int64
+
 	SystemID int
 
 	SystemType op25.SystemType
@@ -68,44 +83,80 @@ type ControlFrequency struct {
 	SymbolRate int
 
 	initialized bool
+	// softDecision passes the demodulator's confidence in each bit to the assembler, rather than slicing.
+	softDecision bool
 
-	sampleNum int
-
-	proc *processor.Processor
+	proc          *processor.Processor
+	tuner         *channelTuner
+	meter         *meter.SignalMeter
+	discriminator *meter.FrequencyMeter
 
 	assembler frame.Assembler
+	// lastStats are the decode statistics as of the previous segment, to count what each segment decoded.
+	lastStats frame.Stats
+	// lastHealthy is when the channel was last seen decoding healthily, or when it was added.  Guarded by the
+	// Turbine's controlMu.
+	lastHealthy time.Time
 }
 
-func (t *Turbine) processControlChannel(ctx context.Context, buf *types.SegmentComplex64, freq *ControlFrequency) error {
-	start := time.Now()
-	metrics := map[string]interface{}{
-		"sample_length": len(buf.Data),
-		"sample_bytes":  len(buf.Data) * 8,
-	}
+func (freq *ControlFrequency) segments() int {
+	return int(atomic.LoadInt64(&freq.sampleNum))
+}
 
-	defer func() {
+// Signal returns the channel's signal measurements.
+func (freq *ControlFrequency) Signal() call.Signal {
+	return channelSignal(freq.meter, freq.discriminator)
+}
 
-		metrics["duration"] = time.Since(start).Microseconds()
+// Stats returns the channel's decode statistics.
+func (freq *ControlFrequency) Stats() frame.Stats {
+	if sr, ok := freq.assembler.(frame.StatsReporter); ok {
+		return sr.Stats()
+	}
+	return frame.Stats{}
+}
 
-		go t.writeAPI.WritePoint(influxdb2.NewPoint("control.processed",
-			map[string]string{
-				"frequency":    op25.MHzToString(freq.Frequency),
-				"sample_type":  "complex64",
-				"channel_type": "control",
-			},
-			metrics, start))
-	}()
+func (t *Turbine) processControlChannel(ctx context.Context, buf *types.SegmentComplex64, freq *ControlFrequency) error {
+	start := time.Now()
+	defer t.metrics.stageDuration.With("control", "total").Since(start)
 
-	sliced, err := freq.proc.ProcessComplexToBinary(buf, metrics)
-	if err != nil {
-		return err
-	}
+	freq.tuner.retune(t.correction.PPM())
 
-	metrics["assembler_duration"] = util.TimeOperationMicroseconds(func() {
+	var assemblerStart time.Time
+	if soft, ok := freq.assembler.(frame.SoftAssembler); ok && freq.softDecision {
+		bits, err := freq.proc.ProcessComplexToFloat(buf, t.metrics.stageTimer("control"))
+		if err != nil {
+			return err
+		}
+		assemblerStart = time.Now()
+		soft.ReceiveSoft(bits.Data)
+	} else {
+		sliced, err := freq.proc.ProcessComplexToBinary(buf, t.metrics.stageTimer("control"))
+		if err != nil {
+			return err
+		}
+		assemblerStart = time.Now()
 		freq.assembler.Receive(sliced.Data)
-	})
-
-	freq.sampleNum++
+	}
+	t.metrics.stageDuration.With("control", "assembler").Since(assemblerStart)
+
+	atomic.AddInt64(&freq.sampleNum, 1)
+	t.metrics.segmentsProcessed.With("control").Inc()
+	t.metrics.samplesProcessed.With("control").Add(float64(len(buf.Data)))
+
+	systemID, frequency := strconv.Itoa(freq.SystemID), op25.MHzToString(freq.Frequency)
+	stats := freq.Stats()
+	t.metrics.observeDecodeStats(systemID, frequency, freq.lastStats, stats)
+	t.snapshots.decodeStats(freq.SystemID, freq.Frequency, freq.lastStats, stats)
+	freq.lastStats = stats
+	t.metrics.signal.observe("control", systemID, frequency, freq.Signal(), true)
+
+	// The control channel transmits continuously, so while it's decoding, its frequency error is the device's.
+	// The FSK4 demodulator tracks its own correction too, but after the AGC, where it no longer maps to Hz.
+	if !t.opts.FixedPPM && decodeHealthy(stats, time.Now()) {
+		t.correction.observe(freq.Frequency, freq.discriminator.Offset(), freq.tuner.ppm)
+		t.metrics.devicePPM.Set(t.correction.PPM())
+	}
 
 	return nil
 }
@@ -134,17 +185,8 @@ func (freq *ControlFrequency) initSmartnet(t *Turbine, sys *internalSystem) {
 	if1 := float64(t.opts.SampleRate) / float64(dec1)
 	if2 := float64(if1) / float64(dec2)
 
-	// Offset from center
-	shiftFreq := freq.Frequency - t.opts.CenterFreq
-
-	bfoFreq := float64(shiftFreq) / if1
-	bfoFreq -= math.Floor(bfoFreq)
-	if bfoFreq < -0.5 {
-		bfoFreq += 1.0
-	}
-	if bfoFreq > 0.5 {
-		bfoFreq -= 1.0
-	}
+	// The band-pass decimator and BFO are retuned as the device's frequency error is learned.
+	freq.tuner = newChannelTuner(freq.Frequency, t.opts.CenterFreq, t.opts.SampleRate, dec1, t.correction.PPM())
 
 	t.logger.Info().
 		Int("system_id", freq.SystemID).
@@ -155,18 +197,11 @@ func (freq *ControlFrequency) initSmartnet(t *Turbine, sys *internalSystem) {
 		Int("intermediate_freq_1", int(if1)).
 		Int("intermediate_freq_2", int(if2)).
 		Int("intermediate_rate", ifRate).
-		Str("shift_freq", op25.MHzToString(shiftFreq)).
-		Str("bfo_freq", op25.MHzToString(int(if1*bfoFreq))).
+		Str("shift_freq", op25.MHzToString(int(freq.tuner.shiftFreq()))).
+		Str("bfo_freq", op25.MHzToString(freq.tuner.bfoFreq())).
+		Float64("ppm", freq.tuner.ppm).
 		Msg("initializing channel")
 
-	bpfCoeffs := fir.MakeComplexBandPass(1.0,
-		float64(t.opts.SampleRate),
-		float64(shiftFreq)-if1/2.0,
-		float64(shiftFreq)+if1/2.0,
-		if1/2,
-		fir.Hamming,
-	)
-
 	// 8M / 80 = 100k
 	// freq.bandpassDecimator =
 
@@ -175,7 +210,7 @@ func (freq *ControlFrequency) initSmartnet(t *Turbine, sys *internalSystem) {
 		"Bandpass Decimator",
 		t.opts.SampleRate,
 		int(if1),
-		dsp.MakeDecimationCTFirFilter(dec1, bpfCoeffs),
+		freq.tuner.bandpass,
 	))
 
 	// Low pass -> get to 100k
@@ -187,7 +222,17 @@ func (freq *ControlFrequency) initSmartnet(t *Turbine, sys *internalSystem) {
 		"BFO Mixer",
 		int(if1),
 		int(if1),
-		mixer.NewWaveformMixer(int(if1), int(if1*bfoFreq)),
+		freq.tuner.bfo,
+	))
+
+	// Measure here, where the channel is centered but there's still spectrum either side to find the noise in.
+	freq.meter = meter.NewSignalMeter(int(if1), defaultChannelBandwidth, deviceFullScale)
+	freq.proc.AddBlock(processor.NewDSPWorkerCC(
+		"signal_meter",
+		"Signal Meter",
+		int(if1),
+		int(if1),
+		freq.meter,
 	))
 
 	// Mixer -- band pass
@@ -203,6 +248,26 @@ func (freq *ControlFrequency) initSmartnet(t *Turbine, sys *internalSystem) {
 		dsp.MakeDecimationFirFilter(dec2, lpfCoeffs),
 	))
 
+	if tap := t.channelRecorder.tap(sys.ID, freq.Frequency, int(if2)); tap != nil {
+		freq.proc.AddBlock(processor.NewDSPWorkerCC(
+			"channel_recorder",
+			"Channel Recorder",
+			int(if2),
+			int(if2),
+			tap,
+		))
+	}
+
+	if tap := t.snapshots.tap(sys.ID, freq.Frequency, int(if2)); tap != nil {
+		freq.proc.AddBlock(processor.NewDSPWorkerCC(
+			"snapshot_buffer",
+			"Snapshot Buffer",
+			int(if2),
+			int(if2),
+			tap,
+		))
+	}
+
 	freq.proc.AddBlock(processor.NewDSPWorkerCC(
 		"resampler",
 		"Rational Resampler",
@@ -228,14 +293,22 @@ func (freq *ControlFrequency) initSmartnet(t *Turbine, sys *internalSystem) {
 		dsp.MakeFirFilter(cutoffLpfCoeffs),
 	))
 
+	demodGain := ifRate / (2 * math.Pi * float32(freq.SymbolRate))
 	freq.proc.AddBlock(processor.NewDSPWorkerCF(
 		"quad_demod",
 		"FM Demodulation",
 		int(ifRate),
 		int(ifRate),
-		quad.MakeQuadDemod(
-			ifRate/(2*math.Pi*float32(freq.SymbolRate)),
-		)))
+		quad.MakeQuadDemod(demodGain)))
+
+	// The chain's spectrum is mirrored relative to the air, so the discriminator reads offsets inverted.
+	freq.discriminator = meter.NewFrequencyMeter(ifRate, float64(demodGain)).Invert()
+	freq.proc.AddBlock(processor.NewDSPWorkerFF(
+		"frequency_meter",
+		"Frequency Meter",
+		int(ifRate),
+		int(ifRate),
+		freq.discriminator))
 
 	freq.proc.AddBlock(processor.NewDSPWorkerFF(
 		"baseband_amp",
@@ -264,12 +337,85 @@ func (freq *ControlFrequency) initSmartnet(t *Turbine, sys *internalSystem) {
 		processor.WithVizLength(26),
 	))
 
-	freq.proc.AddBlock(processor.NewDSPWorkerFB(
-		"binary_slicer",
-		"Binary Slicer",
-		freq.SymbolRate,
-		freq.SymbolRate,
-		slicer.NewBinarySlicer(true)))
+	freq.softDecision = sys.SoftDecision
+	if freq.softDecision {
+		freq.proc.AddBlock(processor.NewDSPWorkerFF(
+			"soft_slicer",
+			"Soft Slicer",
+			freq.SymbolRate,
+			freq.SymbolRate,
+			slicer.NewSoftSlicer(true)))
+	} else {
+		freq.proc.AddBlock(processor.NewDSPWorkerFB(
+			"binary_slicer",
+			"Binary Slicer",
+			freq.SymbolRate,
+			freq.SymbolRate,
+			slicer.NewBinarySlicer(true)))
+	}
 
 	freq.assembler = smartnet.NewSmartnetAssembler(t.ctx, freq.SystemID, sys.dataPacketChan, t.logger)
 }
+
+// retiredControlFrequency is a control channel retired for staying unhealthy.
+type retiredControlFrequency struct {
+	systemID int
+	retired  time.Time
+}
+
+// watchControlChannels hunts for the control channels worth decoding, until Turbine stops.
+func (t *Turbine) watchControlChannels() error {
+	ticker := time.NewTicker(controlHuntInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		case now := <-ticker.C:
+			t.huntControlChannels(now)
+		}
+	}
+}
+
+// huntControlChannels retires control channels that have stayed unhealthy while another of their system's is
+// healthy, so the samples aren't spent on them.  When none of a system's channels has been healthy for a while,
+// its retired channels are brought back to hunt for one that is.
+func (t *Turbine) huntControlChannels(now time.Time) {
+	t.controlMu.Lock()
+	defer t.controlMu.Unlock()
+
+	healthy := make(map[int]bool)
+	lastHealthy := make(map[int]time.Time)
+	for _, ch := range t.controlFreqs {
+		if decodeHealthy(ch.Stats(), now) {
+			ch.lastHealthy = now
+			healthy[ch.SystemID] = true
+		}
+		if ch.lastHealthy.After(lastHealthy[ch.SystemID]) {
+			lastHealthy[ch.SystemID] = ch.lastHealthy
+		}
+	}
+
+	for idx := 0; idx < len(t.controlFreqs); idx++ {
+		ch := t.controlFreqs[idx]
+		if !healthy[ch.SystemID] || now.Sub(ch.lastHealthy) < controlRetireTime {
+			continue
+		}
+		t.dropControlFrequencyLocked(idx)
+		idx--
+		t.retiredControlFreqs[ch.Frequency] = retiredControlFrequency{systemID: ch.SystemID, retired: now}
+
+		t.logger.Info().Int("system_id", ch.SystemID).Str("frequency", op25.MHzToString(ch.Frequency)).Msg("retired unhealthy control frequency")
+		t.publishControlChannel(ch.SystemID, ch.Frequency, feed.ControlChannelRetired)
+	}
+
+	for freq, retired := range t.retiredControlFreqs {
+		if last, ok := lastHealthy[retired.systemID]; ok && now.Sub(last) < controlRestoreTime {
+			continue
+		}
+		delete(t.retiredControlFreqs, freq)
+		t.addControlFrequencyLocked(t.systemMap[retired.systemID], freq)
+
+		t.logger.Info().Int("system_id", retired.systemID).Str("frequency", op25.MHzToString(freq)).Msg("brought back retired control frequency")
+	}
+}
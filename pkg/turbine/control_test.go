@@ -0,0 +1,99 @@
+package turbine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/op25/frame"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/feed"
+	"github.com/rs/zerolog"
+)
+
+func controlFrequencies(tb *Turbine) []int {
+	var ret []int
+	for _, ch := range tb.ControlChannels() {
+		ret = append(ret, ch.Frequency)
+	}
+	return ret
+}
+
+// healthyAssembler reports a channel decoding everything it's sent, as of lastPacket.
+type healthyAssembler struct {
+	lastPacket time.Time
+}
+
+func (a healthyAssembler) Receive([]byte) {}
+
+func (a healthyAssembler) Stats() frame.Stats {
+	return frame.Stats{InSync: true, LastPacket: a.lastPacket, DecodeRate: 42.9, ExpectedRate: 42.9}
+}
+
+func TestHuntControlChannels(t *testing.T) {
+	const (
+		good = 851612500
+		bad  = 851637500
+	)
+	tb, err := NewTurbine(nil, Options{
+		CenterFreq:            852000000,
+		SampleRate:            1000000,
+		VoiceOutputSampleRate: 8000,
+		Systems: []config.System{{
+			ID:         604,
+			SystemType: op25.SystemTypeSmartnet,
+			SymbolRate: 3600,
+		}},
+	}, WithLogger(zerolog.Nop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The channels decode as Start would set them up, but nothing reads their packets.
+	var cancel context.CancelFunc
+	tb.ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	tb.systemMap[604].dataPacketChan = make(chan op25.OSWPacket, 64)
+	sub := tb.Feed().Subscribe(feed.Filter{Types: map[feed.EventType]struct{}{feed.EventTypeControlChannel: {}}})
+	tb.appendControlFrequency(604, good)
+	tb.appendControlFrequency(604, bad)
+
+	tb.controlFreqs[0].assembler = healthyAssembler{lastPacket: time.Now()}
+
+	tb.huntControlChannels(time.Now())
+	if freqs := controlFrequencies(tb); len(freqs) != 2 {
+		t.Fatalf("channel retired before its time: %v", freqs)
+	}
+
+	tb.controlFreqs[1].lastHealthy = time.Now().Add(-controlRetireTime)
+	tb.huntControlChannels(time.Now())
+	if freqs := controlFrequencies(tb); len(freqs) != 1 || freqs[0] != good {
+		t.Fatalf("unhealthy channel wasn't retired: %v", freqs)
+	}
+	// Announcing a retired channel doesn't bring it back while the system has a healthy one.
+	tb.appendControlFrequency(604, bad)
+	if freqs := controlFrequencies(tb); len(freqs) != 1 {
+		t.Fatalf("retired channel re-added: %v", freqs)
+	}
+
+	// Once the good channel has gone quiet, the retired one is hunted again.
+	tb.huntControlChannels(time.Now().Add(controlRestoreTime + decodeHealthTimeout))
+	if freqs := controlFrequencies(tb); len(freqs) != 2 {
+		t.Fatalf("retired channel wasn't brought back: %v", freqs)
+	}
+
+	var actions []feed.ControlChannelAction
+	for len(actions) < 3 {
+		select {
+		case ev := <-sub.Events():
+			if cc := ev.Data.(feed.ControlChannel); cc.Frequency == bad {
+				actions = append(actions, cc.Action)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("got control channel events %v", actions)
+		}
+	}
+	if actions[0] != feed.ControlChannelAdded || actions[1] != feed.ControlChannelRetired || actions[2] != feed.ControlChannelAdded {
+		t.Errorf("got control channel events %v", actions)
+	}
+}
@@ -2,10 +2,12 @@ package turbine
 
 import (
 	"fmt"
+	"io"
 
 	"github.com/norasector/turbine/pkg/op25"
 	"github.com/norasector/turbine/pkg/op25/frame"
 	"github.com/norasector/turbine/pkg/op25/frame/smartnet"
+	"github.com/norasector/turbine/pkg/turbine/catalog"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -16,16 +18,32 @@ func (t *Turbine) processDataPackets() error {
 	for _, sys := range t.systemMap {
 
 		var proc frame.Processor
+		var packetLog io.Closer
 		switch sys.SystemType {
 		case op25.SystemTypeSmartnet:
-			proc = smartnet.NewProcessor(sys.ID, sys.dataPacketChan, t.updateChan, t.writeAPI, t.logger)
+			opts := []smartnet.ProcessorOption{smartnet.WithMessageHandler(t.handleControlMessage)}
+			if sys.OSWLog != "" {
+				oswLog, err := smartnet.CreateOSWLog(sys.OSWLog)
+				if err != nil {
+					return fmt.Errorf("system %d: %w", sys.ID, err)
+				}
+				opts = append(opts, smartnet.WithPacketLog(oswLog))
+				packetLog = oswLog
+			}
+			proc = smartnet.NewProcessor(sys.ID, sys.dataPacketChan, t.updateChan, t.metricsRegistry, t.logger, opts...)
 
 		default:
 			return fmt.Errorf("unrecognized system: %s", sys.SystemType)
 		}
 
 		eg.Go(func() error {
-			return proc.Start(ctx)
+			err := proc.Start(ctx)
+			if packetLog != nil {
+				if closeErr := packetLog.Close(); closeErr != nil {
+					t.logger.Warn().Err(closeErr).Msg("failed to close OSW log")
+				}
+			}
+			return err
 		})
 	}
 
@@ -37,8 +55,9 @@ func (t *Turbine) processDataPackets() error {
 			case update := <-t.updateChan:
 
 				if update.DestTGID > 0 {
-					go t.appendVoiceFrequency(update.SystemID, update.TargetFreq)
-					go t.sm.VMForSystemID(update.SystemID).UpdateGroup(int(update.DestTGID), int(update.SrcID), update.TargetFreq)
+					t.publishGrant(update)
+					go t.appendVoiceFrequency(update.SystemID, int(update.DestTGID), update.TargetFreq)
+					go t.sm.VMForSystemID(update.SystemID).UpdateGroup(update)
 				} else {
 					go t.appendControlFrequency(update.SystemID, update.TargetFreq)
 				}
@@ -56,7 +75,13 @@ func (t *Turbine) freqWithinBounds(freq int) bool {
 	return freq >= min && freq <= max
 }
 
-func (t *Turbine) appendVoiceFrequency(systemID, freq int) {
+// appendVoiceFrequency starts demodulating a frequency a talkgroup has been granted, unless the talkgroup is
+// ignored.
+func (t *Turbine) appendVoiceFrequency(systemID, tgid, freq int) {
+	if t.sm.VMForSystemID(systemID).ActionFor(tgid) == catalog.ActionIgnore {
+		return
+	}
+
 	t.mu.Lock()
 	if _, ok := t.voiceFreqCache[freq]; !ok && t.freqWithinBounds(freq) {
 		sys := t.systemMap[systemID]
@@ -78,7 +103,9 @@ func (t *Turbine) appendVoiceFrequency(systemID, freq int) {
 
 func (t *Turbine) appendControlFrequency(systemID, freq int) {
 	t.controlMu.Lock()
-	if _, ok := t.controlFreqCache[freq]; !ok && t.freqWithinBounds(freq) {
+	_, removed := t.removedControlFreqs[freq]
+	_, retired := t.retiredControlFreqs[freq]
+	if _, ok := t.controlFreqCache[freq]; !ok && !removed && !retired && t.freqWithinBounds(freq) {
 		t.logger.Debug().Str("freq", op25.MHzToString(freq)).Msg("got new control freq")
 
 		sys := t.systemMap[systemID]
@@ -86,10 +113,7 @@ func (t *Turbine) appendControlFrequency(systemID, freq int) {
 			panic("could not find system")
 		}
 
-		ch := NewControlFrequency(t, sys, freq)
-
-		t.controlFreqs = append(t.controlFreqs, ch)
-		t.controlFreqCache[freq] = struct{}{}
+		t.addControlFrequencyLocked(sys, freq)
 	}
 	t.controlMu.Unlock()
 }
@@ -2,8 +2,10 @@ package device
 
 import (
 	"context"
+	"errors"
 
 	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
 )
 
 type Device interface {
@@ -11,3 +13,48 @@ type Device interface {
 	Stop() error
 	MaxSampleRate() int
 }
+
+// Info describes a device, for the metadata kept with recordings.
+type Info struct {
+	// Name is the kind of device, such as "HackRF One".
+	Name string
+	// Gain is the device's total gain in dB, or 0 if it's automatic or unknown.
+	Gain float64
+}
+
+// Describer is implemented by devices that can describe themselves.
+type Describer interface {
+	Info() Info
+}
+
+// FixedTuning is implemented by devices whose samples come with their own center frequency and sample rate,
+// such as recordings.  The receiver has to be set up with those rather than the configured ones.
+type FixedTuning interface {
+	CenterFreq() int
+	SampleRate() int
+}
+
+// Formatter is implemented by devices whose samples arrive in one of the iq formats, so they can be recorded
+// exactly as they arrived.
+type Formatter interface {
+	Format() iq.Format
+}
+
+// Dropper is implemented by devices that can lose samples on their way to the receiver, such as over a
+// network.
+type Dropper interface {
+	// DroppedSamples is how many samples have been lost since the device was created.
+	DroppedSamples() uint64
+}
+
+// Reconnector is implemented by devices that can recover by themselves when their samples stop, such as network
+// devices that reconnect.
+type Reconnector interface {
+	// Reconnects is whether the device recovers by itself, so it's only reopened when it fails, not whenever its
+	// samples stop.
+	Reconnects() bool
+}
+
+// ErrEndOfSamples is returned from Start by devices that have delivered all their samples, such as recordings
+// played to the end.  The receiver shuts down cleanly when it sees it.
+var ErrEndOfSamples = errors.New("end of samples")
@@ -1,74 +1,305 @@
+// Package file plays IQ recordings back as a device: SigMF recordings, WAV IQ recordings such as SDR#'s, and
+// raw interleaved samples in any of the formats in package iq.
 package file
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"github.com/norasector/turbine/pkg/turbine/device/sigmf"
 )
 
+// segmentLength is how many samples are read at a time, about 16ms at 8M samples per second.
+const segmentLength = 131072
+
+type Options struct {
+	// Format, SampleRate and CenterFreq describe raw recordings, which don't describe themselves.  SigMF and
+	// WAV recordings use what they say instead, except that a WAV recording without a center frequency takes
+	// CenterFreq.  Format defaults to iq.FormatCS8, as a HackRF records.
+	Format     iq.Format
+	SampleRate int
+	CenterFreq int
+
+	// Start and End play only part of the recording, from Start to End into it.  End defaults to the end.
+	Start time.Duration
+	End   time.Duration
+	// Loop plays the recording again from Start each time it reaches End, rather than finishing.
+	Loop bool
+	// Fast plays the recording as fast as it's taken rather than in real time, for decoding it in batch.
+	Fast bool
+}
+
 type FileDevice struct {
-	readFile    *os.File
-	readSize    int
-	timeBetween time.Duration
-	outputChan  chan *types.SegmentComplex64
-	sampleRate  int
-	centerFreq  int
+	// data is the part of the file being played.
+	data   *io.SectionReader
+	format iq.Format
+	info   device.Info
+	loop   bool
+	fast   bool
+
+	sampleRate int
+	centerFreq int
+
+	// path and opts reopen the recording if it's started again after being stopped.
+	path string
+	opts Options
+	// pos is how far into data playback has got, so it carries on from there if restarted.  Only Start uses
+	// it.
+	pos int64
+
+	lock     sync.Mutex
+	readFile *os.File
 }
 
-func NewFileDevice(file string, readSize int, sampleRate int, centerFreq int, timeBetween time.Duration) (*FileDevice, error) {
-	f, err := os.Open(file)
+// NewFileDevice opens the recording at path.  A SigMF recording may be named by its meta file, its data file
+// or neither.
+func NewFileDevice(path string, opts Options) (*FileDevice, error) {
+	f, dataOffset, dataLength, err := open(path, opts)
 	if err != nil {
 		return nil, err
 	}
+	if err := f.selectPart(dataOffset, dataLength, opts); err != nil {
+		f.readFile.Close()
+		return nil, err
+	}
+	f.loop = opts.Loop
+	f.fast = opts.Fast
+	f.path = path
+	f.opts = opts
+	return f, nil
+}
+
+// reopen opens the recording again if it's been stopped.
+func (f *FileDevice) reopen() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.readFile != nil {
+		return nil
+	}
+	g, dataOffset, dataLength, err := open(f.path, f.opts)
+	if err != nil {
+		return err
+	}
+	if err := g.selectPart(dataOffset, dataLength, f.opts); err != nil {
+		g.readFile.Close()
+		return err
+	}
+	f.readFile = g.readFile
+	f.data = g.data
+	return nil
+}
+
+// open opens the recording at path, and returns where its samples are in the file.  A dataLength of -1
+// means they run to the end of it.
+func open(path string, opts Options) (f *FileDevice, dataOffset, dataLength int64, err error) {
+	if sigmf.IsRecording(path) {
+		f, err := openSigMF(path)
+		return f, 0, -1, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, err
+	}
 
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(file, header)
+	if isWAV(header[:n]) {
+		w, err := readWAVIQ(file, path)
+		if err != nil {
+			file.Close()
+			return nil, 0, 0, err
+		}
+		centerFreq := w.centerFreq
+		if centerFreq == 0 {
+			centerFreq = opts.CenterFreq
+		}
+		return &FileDevice{
+			readFile:   file,
+			format:     w.format,
+			sampleRate: w.sampleRate,
+			centerFreq: centerFreq,
+		}, w.dataOffset, w.dataLength, nil
+	}
+
+	format := opts.Format
+	if format == "" {
+		format = iq.FormatCS8
+	}
+	if format.SampleSize() == 0 {
+		file.Close()
+		return nil, 0, 0, errors.New("unknown sample format " + string(format))
+	}
 	return &FileDevice{
-		readFile:    f,
-		readSize:    readSize,
-		timeBetween: timeBetween,
-		sampleRate:  sampleRate,
-		centerFreq:  centerFreq,
+		readFile:   file,
+		format:     format,
+		sampleRate: opts.SampleRate,
+		centerFreq: opts.CenterFreq,
+	}, 0, -1, nil
+}
+
+func openSigMF(path string) (*FileDevice, error) {
+	metaPath, dataPath := sigmf.Paths(path)
+	meta, err := sigmf.ReadMeta(metaPath)
+	if err != nil {
+		return nil, err
+	}
+	format, err := sigmf.Format(meta.Global.Datatype)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Global.SampleRate <= 0 || len(meta.Captures) == 0 || meta.Captures[0].Frequency <= 0 {
+		return nil, errors.New("SigMF recording is missing its sample rate or center frequency")
+	}
+
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	info := device.Info{Name: meta.Global.Hardware}
+	if gain := meta.Captures[0].Gain; gain != nil {
+		info.Gain = *gain
+	}
+	return &FileDevice{
+		readFile:   f,
+		format:     format,
+		info:       info,
+		sampleRate: int(meta.Global.SampleRate),
+		centerFreq: int(meta.Captures[0].Frequency),
 	}, nil
+}
+
+// selectPart limits playback to the samples between opts.Start and opts.End, of those at dataOffset.
+func (f *FileDevice) selectPart(dataOffset, dataLength int64, opts Options) error {
+	stat, err := f.readFile.Stat()
+	if err != nil {
+		return err
+	}
+	if f.sampleRate <= 0 {
+		return errors.New("recording has no sample rate")
+	}
+
+	size := int64(f.format.SampleSize())
+	// A WAV's data chunk claims more than was written if the recording was cut short.
+	if dataLength < 0 || dataLength > stat.Size()-dataOffset {
+		dataLength = stat.Size() - dataOffset
+	}
+	samples := dataLength / size
+	start := f.samplesIn(opts.Start)
+	end := samples
+	if opts.End > 0 && f.samplesIn(opts.End) < end {
+		end = f.samplesIn(opts.End)
+	}
+	if start >= end {
+		return fmt.Errorf("nothing to play between %s and %s of a %s recording", opts.Start, opts.End,
+			time.Duration(samples)*time.Second/time.Duration(f.sampleRate))
+	}
+
+	f.data = io.NewSectionReader(f.readFile, dataOffset+start*size, (end-start)*size)
+	return nil
+}
+
+func (f *FileDevice) samplesIn(d time.Duration) int64 {
+	return int64(d.Seconds() * float64(f.sampleRate))
+}
+
+// CenterFreq is the center frequency the recording was made at.
+func (f *FileDevice) CenterFreq() int {
+	return f.centerFreq
+}
+
+// SampleRate is the sample rate the recording was made at.
+func (f *FileDevice) SampleRate() int {
+	return f.sampleRate
+}
+
+// Format is the recording's sample format.
+func (f *FileDevice) Format() iq.Format {
+	return f.format
+}
 
+// Info describes the device the recording was made with, if it says.
+func (f *FileDevice) Info() device.Info {
+	return f.info
 }
 
+// Start plays the recording, and returns device.ErrEndOfSamples once it's over.  It's played in real time
+// unless the device is fast, and over and over if it loops.  Started again after being stopped, it reopens the
+// recording and carries on from where it got to.
 func (f *FileDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
-	tick := time.NewTicker(f.timeBetween)
+	if err := f.reopen(); err != nil {
+		return err
+	}
+	size := f.format.SampleSize()
+	buf := make([]byte, segmentLength*size)
+
+	start := time.Now()
+	var played int64
 	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-tick.C:
-			buf := make([]byte, f.readSize)
-			n, err := f.readFile.Read(buf)
-			if err != nil {
+		n, err := f.data.ReadAt(buf, f.pos)
+		n -= n % size
+		if n < size {
+			if err != io.EOF {
 				return err
 			}
-
-			seg := types.SegmentCS8Raw{
-				SampleRate: f.sampleRate,
-				Data:       make([]byte, len(buf[:n])),
-				Frequency:  f.centerFreq,
+			if !f.loop {
+				return device.ErrEndOfSamples
 			}
-			copy(seg.Data, buf)
+			f.pos = 0
+			continue
+		}
+		f.pos += int64(n)
 
-			complexSegment := seg.ToComplex64()
+		// Samples are converted straight from the read buffer into the segment.
+		seg := &types.SegmentComplex64{
+			SampleRate: f.sampleRate,
+			Frequency:  f.centerFreq,
+			Data:       make([]complex64, n/size),
+		}
+		f.format.Decode(buf[:n], seg.Data)
 
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case complexSamples <- complexSegment:
-			}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case complexSamples <- seg:
+		}
 
+		if f.fast {
+			continue
+		}
+		// Pacing from the samples played so far, rather than per segment, keeps timer slop from adding up.
+		played += int64(len(seg.Data))
+		due := start.Add(time.Duration(float64(played) / float64(f.sampleRate) * float64(time.Second)))
+		timer := time.NewTimer(time.Until(due))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
 		}
 	}
-
 }
 
+// Stop closes the recording.
 func (f *FileDevice) Stop() error {
-	return f.readFile.Close()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.readFile == nil {
+		return nil
+	}
+	err := f.readFile.Close()
+	f.readFile = nil
+	return err
 }
+
 func (f *FileDevice) MaxSampleRate() int {
 	return 20e6
 }
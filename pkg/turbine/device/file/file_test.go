@@ -0,0 +1,254 @@
+package file
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/synth"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"github.com/norasector/turbine/pkg/turbine/device/sigmf"
+)
+
+const (
+	testCenterFreq = 852000000
+	testSampleRate = 1000000
+)
+
+// segmentDevice delivers the segments it's given, then waits to be stopped.
+type segmentDevice struct {
+	segments []*types.SegmentComplex64
+}
+
+func (d *segmentDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
+	for _, seg := range d.segments {
+		complexSamples <- seg
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (d *segmentDevice) Stop() error {
+	return nil
+}
+
+func (d *segmentDevice) MaxSampleRate() int {
+	return 20e6
+}
+
+func (d *segmentDevice) Info() device.Info {
+	return device.Info{Name: "test", Gain: 20}
+}
+
+// rounded generates n samples of noise, rounded to what an 8-bit device could deliver.
+func rounded(n int) []complex64 {
+	seg := synth.NewGenerator(testSampleRate, testCenterFreq, synth.WithNoise(20)).Segment(n)
+	iq.FormatCS8.Decode(iq.FormatCS8.Encode(nil, seg.Data), seg.Data)
+	return seg.Data
+}
+
+// playAll plays f through and returns its samples.
+func playAll(t *testing.T, f *FileDevice) []complex64 {
+	t.Helper()
+	played := make(chan *types.SegmentComplex64, 64)
+	if err := f.Start(context.Background(), 0, 0, played); err != device.ErrEndOfSamples {
+		t.Fatal(err)
+	}
+	close(played)
+	var ret []complex64
+	for seg := range played {
+		ret = append(ret, seg.Data...)
+	}
+	return ret
+}
+
+func expectSamples(t *testing.T, got, want []complex64) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("played %d samples, expected %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sample %d played as %v, expected %v", i, got[i], want[i])
+		}
+	}
+}
+
+// formatDevice is a segmentDevice whose samples arrive in format.
+type formatDevice struct {
+	*segmentDevice
+	format iq.Format
+}
+
+func (d *formatDevice) Format() iq.Format {
+	return d.format
+}
+
+func TestSigMFRecordAndPlayback(t *testing.T) {
+	for _, format := range []iq.Format{"", iq.FormatCS8, iq.FormatCU8, iq.FormatCS16, iq.FormatCF32} {
+		testSigMFRecordAndPlayback(t, format)
+	}
+}
+
+// testSigMFRecordAndPlayback records a device with samples in format, or with none if it's empty, and checks
+// they play back exactly.
+func testSigMFRecordAndPlayback(t *testing.T, format iq.Format) {
+	var segments []*types.SegmentComplex64
+	var want []complex64
+	for i := 0; i < 3; i++ {
+		seg := &types.SegmentComplex64{Data: rounded(1000)}
+		if format != "" {
+			format.Decode(format.Encode(nil, seg.Data), seg.Data)
+		}
+		segments = append(segments, seg)
+		want = append(want, seg.Data...)
+	}
+
+	path := filepath.Join(t.TempDir(), "capture")
+	var dev device.Device = &segmentDevice{segments: segments}
+	recorded := iq.FormatCF32
+	if format != "" {
+		dev, recorded = &formatDevice{segmentDevice: dev.(*segmentDevice), format: format}, format
+	}
+	rec := sigmf.NewRecordingDevice(dev, path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan *types.SegmentComplex64, len(segments))
+	done := make(chan error, 1)
+	go func() {
+		done <- rec.Start(ctx, testCenterFreq, testSampleRate, out)
+	}()
+	for range segments {
+		<-out
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	f, err := NewFileDevice(path+sigmf.DataExtension, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	if f.CenterFreq() != testCenterFreq || f.SampleRate() != testSampleRate || f.Format() != recorded {
+		t.Errorf("%q device recorded as %s at %d Hz, %d samples per second", format, f.Format(), f.CenterFreq(), f.SampleRate())
+	}
+	if info := f.Info(); info.Name != "test" || info.Gain != 20 {
+		t.Errorf("unexpected device info %+v", info)
+	}
+	expectSamples(t, playAll(t, f), want)
+}
+
+func TestWAVPlayback(t *testing.T) {
+	want := rounded(1000)
+	data := iq.FormatCS16.Encode(nil, want)
+
+	// A 2-channel 16-bit WAV as SDR# writes them, with the center frequency in an auxi chunk.
+	var wav []byte
+	chunk := func(id string, body []byte) {
+		var size [4]byte
+		binary.LittleEndian.PutUint32(size[:], uint32(len(body)))
+		wav = append(wav, id...)
+		wav = append(wav, size[:]...)
+		wav = append(wav, body...)
+	}
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint16(format[0:2], wavFormatPCM)
+	binary.LittleEndian.PutUint16(format[2:4], 2)
+	binary.LittleEndian.PutUint32(format[4:8], testSampleRate)
+	binary.LittleEndian.PutUint32(format[8:12], testSampleRate*4)
+	binary.LittleEndian.PutUint16(format[12:14], 4)
+	binary.LittleEndian.PutUint16(format[14:16], 16)
+	auxi := make([]byte, 164)
+	binary.LittleEndian.PutUint32(auxi[auxiCenterFreqOffset:], testCenterFreq)
+
+	wav = append(wav, "RIFF\x00\x00\x00\x00WAVE"...)
+	chunk("fmt ", format)
+	chunk("auxi", auxi)
+	chunk("data", data)
+	binary.LittleEndian.PutUint32(wav[4:8], uint32(len(wav)-8))
+
+	path := filepath.Join(t.TempDir(), "capture.wav")
+	if err := os.WriteFile(path, wav, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewFileDevice(path, Options{Format: iq.FormatCU8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	if f.CenterFreq() != testCenterFreq || f.SampleRate() != testSampleRate || f.Format() != iq.FormatCS16 {
+		t.Errorf("recording is %s at %d Hz, %d samples per second", f.Format(), f.CenterFreq(), f.SampleRate())
+	}
+	expectSamples(t, playAll(t, f), want)
+}
+
+func TestRawPlayback(t *testing.T) {
+	want := rounded(1000)
+	path := filepath.Join(t.TempDir(), "capture.cf32")
+	if err := os.WriteFile(path, iq.FormatCF32.Encode(nil, want), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewFileDevice(path, Options{Format: iq.FormatCF32, SampleRate: testSampleRate, CenterFreq: testCenterFreq})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	expectSamples(t, playAll(t, f), want)
+}
+
+func TestPlaybackPartAndLoop(t *testing.T) {
+	samples := rounded(3000)
+	path := filepath.Join(t.TempDir(), "capture.cs8")
+	if err := os.WriteFile(path, iq.FormatCS8.Encode(nil, samples), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{
+		SampleRate: testSampleRate,
+		CenterFreq: testCenterFreq,
+		Start:      time.Millisecond,
+		End:        2 * time.Millisecond,
+	}
+
+	f, err := NewFileDevice(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSamples(t, playAll(t, f), samples[1000:2000])
+	f.Stop()
+
+	opts.Loop = true
+	opts.Fast = true
+	f, err = NewFileDevice(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	played := make(chan *types.SegmentComplex64)
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Start(ctx, 0, 0, played)
+	}()
+	for i := 0; i < 3; i++ {
+		expectSamples(t, (<-played).Data, samples[1000:2000])
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	opts.Start = 5 * time.Millisecond
+	opts.End = 0
+	if _, err := NewFileDevice(path, opts); err == nil {
+		t.Error("played from past the end of the recording")
+	}
+}
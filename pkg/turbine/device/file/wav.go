@@ -0,0 +1,123 @@
+package file
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xfffe
+
+	// auxiCenterFreqOffset is where SDR#'s auxi chunk keeps the center frequency, after two SYSTEMTIMEs.
+	auxiCenterFreqOffset = 32
+)
+
+// sdrSharpFreq finds the center frequency in the file names SDR# gives its recordings, like
+// SDRSharp_20220101_120000Z_852000000Hz_IQ.wav.
+var sdrSharpFreq = regexp.MustCompile(`_(\d+)Hz`)
+
+// wavIQ is what the header of a 2-channel WAV IQ recording says about it.
+type wavIQ struct {
+	format     iq.Format
+	sampleRate int
+	// centerFreq is 0 if the recording doesn't say.
+	centerFreq int
+	dataOffset int64
+	dataLength int64
+}
+
+func isWAV(header []byte) bool {
+	return len(header) >= 12 && string(header[0:4]) == "RIFF" && string(header[8:12]) == "WAVE"
+}
+
+// readWAVIQ reads the header of a WAV IQ recording, with I on the left channel and Q on the right.
+func readWAVIQ(r io.ReadSeeker, path string) (*wavIQ, error) {
+	if _, err := r.Seek(12, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	ret := &wavIQ{}
+	offset := int64(12)
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return nil, fmt.Errorf("no data chunk: %w", err)
+		}
+		offset += 8
+		size := int64(binary.LittleEndian.Uint32(header[4:8]))
+
+		switch string(header[0:4]) {
+		case "fmt ":
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, err
+			}
+			if err := ret.parseFormat(chunk); err != nil {
+				return nil, err
+			}
+		case "auxi":
+			chunk := make([]byte, size)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, err
+			}
+			if len(chunk) >= auxiCenterFreqOffset+4 {
+				ret.centerFreq = int(binary.LittleEndian.Uint32(chunk[auxiCenterFreqOffset:]))
+			}
+		case "data":
+			if ret.format == "" {
+				return nil, errors.New("WAV data before format")
+			}
+			ret.dataOffset = offset
+			ret.dataLength = size
+			if ret.centerFreq == 0 {
+				if m := sdrSharpFreq.FindStringSubmatch(filepath.Base(path)); m != nil {
+					ret.centerFreq, _ = strconv.Atoi(m[1])
+				}
+			}
+			return ret, nil
+		}
+		// Skip the rest of the chunk.  Chunks are padded to an even length.
+		offset += size + size%2
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (w *wavIQ) parseFormat(chunk []byte) error {
+	if len(chunk) < 16 {
+		return errors.New("short WAV format chunk")
+	}
+	tag := binary.LittleEndian.Uint16(chunk[0:2])
+	channels := binary.LittleEndian.Uint16(chunk[2:4])
+	bits := binary.LittleEndian.Uint16(chunk[14:16])
+	if tag == wavFormatExtensible && len(chunk) >= 26 {
+		// The real format leads the subformat GUID.
+		tag = binary.LittleEndian.Uint16(chunk[24:26])
+	}
+	if channels != 2 {
+		return fmt.Errorf("WAV IQ needs 2 channels, not %d", channels)
+	}
+	w.sampleRate = int(binary.LittleEndian.Uint32(chunk[4:8]))
+
+	switch {
+	case tag == wavFormatPCM && bits == 8:
+		w.format = iq.FormatCU8
+	case tag == wavFormatPCM && bits == 16:
+		w.format = iq.FormatCS16
+	case tag == wavFormatFloat && bits == 32:
+		w.format = iq.FormatCF32
+	default:
+		return fmt.Errorf("unsupported WAV format %d with %d bit samples", tag, bits)
+	}
+	return nil
+}
@@ -2,13 +2,29 @@ package hackrf
 
 import (
 	"context"
-	"os"
+	"sync"
 
 	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
 	"github.com/samuel/go-hackrf/hackrf"
 )
 
-const maxSampleRate = 20e6
+const (
+	maxSampleRate = 20e6
+
+	// defaultLNAGain is the LNA gain asked for unless one is configured, which the HackRF rounds down to 32 dB.  The
+	// VGA is left as the HackRF has it.
+	defaultLNAGain = 39
+	// ampGain is the nominal gain of the RF amplifier.
+	ampGain = 14
+)
+
+// gainStages are the LNA (IF) and VGA (baseband) gains, which the HackRF rounds down to their steps.
+var gainStages = []device.GainStage{
+	{Name: "lna", Min: 0, Max: 40, Step: 8},
+	{Name: "vga", Min: 0, Max: 62, Step: 2},
+}
 
 func (r *HackRFDevice) MaxSampleRate() int {
 	return maxSampleRate
@@ -23,49 +39,86 @@ type HackRFDevice struct {
 	outputChan chan *types.SegmentComplex64
 	ctx        context.Context
 
-	recordLocation string
-	outputFile     *os.File
+	// lock guards the device, which is closed and reopened as it's stopped and started, and its settings.
+	lock     sync.Mutex
+	settings device.Settings
+	// started is whether the device is receiving, so settings are applied as they're changed.
+	started bool
 }
 
-func NewRecordingHackRFDevice(recordLocation string) (*HackRFDevice, error) {
-
-	device, err := hackrf.Open()
-	if err != nil {
-		return nil, err
-	}
+func NewHackRFDevice() (*HackRFDevice, error) {
 
-	outFile, err := os.Create(recordLocation)
+	dev, err := hackrf.Open()
 	if err != nil {
 		return nil, err
 	}
 
+	amp, biasTee := true, false
 	return &HackRFDevice{
-		device:         device,
-		outputFile:     outFile,
-		recordLocation: recordLocation,
+		device: dev,
+		settings: device.Settings{
+			Gains:   map[string]float64{"lna": defaultLNAGain},
+			Amp:     &amp,
+			BiasTee: &biasTee,
+		},
 	}, nil
 }
 
-func NewHackRFDevice() (*HackRFDevice, error) {
+func (h *HackRFDevice) Info() device.Info {
+	return device.Info{Name: "HackRF One", Gain: h.Settings().TotalGain(ampGain)}
+}
 
-	device, err := hackrf.Open()
-	if err != nil {
-		return nil, err
-	}
+func (h *HackRFDevice) GainStages() []device.GainStage {
+	return gainStages
+}
 
-	return &HackRFDevice{
-		device: device,
-	}, nil
+// Settings are the settings asked for, with the gains rounded down to their steps as the HackRF applies them.
+func (h *HackRFDevice) Settings() device.Settings {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.settings.Rounded(gainStages)
 }
 
-func (h *HackRFDevice) callback(buf []byte) error {
-	if h.outputFile != nil {
-		if _, err := h.outputFile.Write(buf); err != nil {
+func (h *HackRFDevice) Apply(changes device.Settings) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if err := device.Check(gainStages, h.settings, changes); err != nil {
+		return err
+	}
+	settings := h.settings.With(changes)
+	if h.started {
+		if err := h.set(settings); err != nil {
 			return err
 		}
+	}
+	h.settings = settings
+	return nil
+}
 
-		return nil
+// set applies settings to the hardware.  A gain stage without a gain is left as it is.
+func (h *HackRFDevice) set(settings device.Settings) error {
+	if gain, ok := settings.Gains["lna"]; ok {
+		if err := h.device.SetLNAGain(int(gain)); err != nil {
+			return err
+		}
+	}
+	if gain, ok := settings.Gains["vga"]; ok {
+		if err := h.device.SetVGAGain(int(gain)); err != nil {
+			return err
+		}
+	}
+	if err := h.device.SetAmpEnable(*settings.Amp); err != nil {
+		return err
 	}
+	return h.device.SetAntennaEnable(*settings.BiasTee)
+}
+
+// Format is cs8, which the HackRF delivers.
+func (h *HackRFDevice) Format() iq.Format {
+	return iq.FormatCS8
+}
+
+func (h *HackRFDevice) callback(buf []byte) error {
 	seg := types.SegmentCS8Raw{
 		SampleRate: h.sampleRate,
 		Data:       make([]byte, len(buf)),
@@ -83,7 +136,21 @@ func (h *HackRFDevice) callback(buf []byte) error {
 	return nil
 }
 
+// Start receives from the device, reopening it if it's been stopped.  It returns once the device is receiving,
+// which goes on in the background until it's stopped.
 func (h *HackRFDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.device == nil {
+		dev, err := hackrf.Open()
+		if err != nil {
+			return err
+		}
+		h.device = dev
+	}
 	h.ctx = ctx
 	h.outputChan = complexSamples
 	h.centerFreq = centerFreq
@@ -94,22 +161,31 @@ func (h *HackRFDevice) Start(ctx context.Context, centerFreq int, sampleRate int
 	if err := h.device.SetSampleRateManual(h.sampleRate*2, 2); err != nil {
 		return err
 	}
-
-	if err := h.device.SetLNAGain(39); err != nil {
+	if err := h.device.SetBasebandFilterBandwidth(h.sampleRate); err != nil {
 		return err
 	}
-	if err := h.device.SetBasebandFilterBandwidth(h.sampleRate); err != nil {
+	if err := h.set(h.settings); err != nil {
 		return err
 	}
-	if err := h.device.SetAmpEnable(true); err != nil {
+	if err := h.device.StartRX(h.callback); err != nil {
 		return err
 	}
-	return h.device.StartRX(h.callback)
+	h.started = true
+	return nil
 }
 
+// Stop stops receiving and closes the device, so it can be reopened if it's misbehaving.
 func (h *HackRFDevice) Stop() error {
-	if h.outputFile != nil {
-		defer h.outputFile.Close()
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.started = false
+	if h.device == nil {
+		return nil
+	}
+	err := h.device.StopRX()
+	if closeErr := h.device.Close(); err == nil {
+		err = closeErr
 	}
-	return h.device.StopRX()
+	h.device = nil
+	return err
 }
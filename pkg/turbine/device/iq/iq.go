@@ -0,0 +1,115 @@
+// Package iq converts between complex64 samples and the interleaved I/Q formats SDRs and SDR software write.
+//
+// Samples are kept as types.SegmentCS8Raw.ToComplex64 leaves a HackRF's: at the scale of a signed 8-bit
+// device, with Q as the real part and I as the imaginary.  Every format is converted to that, so the channel
+// chains see the same levels and spectrum whatever the samples came from.
+package iq
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+type Format string
+
+const (
+	// FormatCS8 is signed 8-bit, as a HackRF delivers.
+	FormatCS8 Format = "cs8"
+	// FormatCU8 is unsigned 8-bit, centered on 127.5, as an RTL-SDR delivers.
+	FormatCU8 Format = "cu8"
+	// FormatCS16 is signed little endian 16-bit, as Airspy and SDRplay captures are.
+	FormatCS16 Format = "cs16"
+	// FormatCF32 is little endian 32-bit float from -1 to 1, as GNU Radio writes.
+	FormatCF32 Format = "cf32"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatCS8, FormatCU8, FormatCS16, FormatCF32:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown sample format %q", s)
+	}
+}
+
+// SampleSize is the size of a sample, I and Q together, in bytes.
+func (f Format) SampleSize() int {
+	switch f {
+	case FormatCS8, FormatCU8:
+		return 2
+	case FormatCS16:
+		return 4
+	case FormatCF32:
+		return 8
+	default:
+		return 0
+	}
+}
+
+// Decode converts the whole samples in buf into out, straight from the bytes, and returns how many it
+// converted.
+func (f Format) Decode(buf []byte, out []complex64) int {
+	n := len(buf) / f.SampleSize()
+	if n > len(out) {
+		n = len(out)
+	}
+
+	switch f {
+	case FormatCS8:
+		for k := 0; k < n; k++ {
+			out[k] = complex(float32(int8(buf[2*k+1])), float32(int8(buf[2*k])))
+		}
+	case FormatCU8:
+		for k := 0; k < n; k++ {
+			out[k] = complex(float32(buf[2*k+1])-127.5, float32(buf[2*k])-127.5)
+		}
+	case FormatCS16:
+		for k := 0; k < n; k++ {
+			i := int16(binary.LittleEndian.Uint16(buf[4*k:]))
+			q := int16(binary.LittleEndian.Uint16(buf[4*k+2:]))
+			out[k] = complex(float32(q)/256, float32(i)/256)
+		}
+	case FormatCF32:
+		for k := 0; k < n; k++ {
+			i := math.Float32frombits(binary.LittleEndian.Uint32(buf[8*k:]))
+			q := math.Float32frombits(binary.LittleEndian.Uint32(buf[8*k+4:]))
+			out[k] = complex(q*128, i*128)
+		}
+	}
+	return n
+}
+
+// Encode appends samples to buf in the format.  Values beyond an integer format's range are clipped.
+func (f Format) Encode(buf []byte, samples []complex64) []byte {
+	switch f {
+	case FormatCS8:
+		for _, s := range samples {
+			buf = append(buf, byte(int8(clip(imag(s), math.MinInt8, math.MaxInt8))), byte(int8(clip(real(s), math.MinInt8, math.MaxInt8))))
+		}
+	case FormatCU8:
+		for _, s := range samples {
+			buf = append(buf, byte(clip(imag(s)+127.5, 0, math.MaxUint8)), byte(clip(real(s)+127.5, 0, math.MaxUint8)))
+		}
+	case FormatCS16:
+		var b [4]byte
+		for _, s := range samples {
+			binary.LittleEndian.PutUint16(b[0:2], uint16(int16(clip(imag(s)*256, math.MinInt16, math.MaxInt16))))
+			binary.LittleEndian.PutUint16(b[2:4], uint16(int16(clip(real(s)*256, math.MinInt16, math.MaxInt16))))
+			buf = append(buf, b[:]...)
+		}
+	case FormatCF32:
+		var b [8]byte
+		for _, s := range samples {
+			binary.LittleEndian.PutUint32(b[0:4], math.Float32bits(imag(s)/128))
+			binary.LittleEndian.PutUint32(b[4:8], math.Float32bits(real(s)/128))
+			buf = append(buf, b[:]...)
+		}
+	}
+	return buf
+}
+
+// clip rounds v to the nearest integer within min and max.
+func clip(v float32, min, max float64) float64 {
+	return math.Max(min, math.Min(max, math.Round(float64(v))))
+}
@@ -0,0 +1,35 @@
+package iq
+
+import (
+	"testing"
+
+	"github.com/norasector/turbine-common/types"
+)
+
+func TestFormats(t *testing.T) {
+	// I of 10 and Q of -20, as each format would hold them.
+	raw := map[Format][]byte{
+		FormatCS8:  {10, 0xec},
+		FormatCU8:  {138, 108},
+		FormatCS16: {0x00, 0x0a, 0x00, 0xec},
+		FormatCF32: {0x00, 0x00, 0xa0, 0x3d, 0x00, 0x00, 0x20, 0xbe},
+	}
+
+	// Every format should come out as a HackRF's samples do.
+	hackrf := (&types.SegmentCS8Raw{Data: raw[FormatCS8]}).ToComplex64().Data[0]
+
+	for format, b := range raw {
+		out := make([]complex64, 1)
+		if n := format.Decode(b, out); n != 1 {
+			t.Fatalf("%s: decoded %d samples", format, n)
+		}
+		if d := out[0] - hackrf; real(d) > 0.5 || real(d) < -0.5 || imag(d) > 0.5 || imag(d) < -0.5 {
+			t.Errorf("%s: decoded %v, expected %v", format, out[0], hackrf)
+		}
+
+		encoded := format.Encode(nil, []complex64{hackrf})
+		if string(encoded) != string(b) {
+			t.Errorf("%s: encoded %v, expected %v", format, encoded, b)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package iqstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Magic starts every frame.  Its last byte is the version of the framing.
+	Magic = "TIQ\x01"
+	// HeaderLength is the length of a frame's header, which is followed by its samples.
+	HeaderLength = 24
+	// MaxFrameLength bounds the samples in a frame, so a corrupt stream can't ask for unbounded memory.
+	MaxFrameLength = 16 << 20
+)
+
+var ErrBadMagic = errors.New("not an IQ stream frame")
+
+// Header precedes the samples in each frame.  Everything is little-endian:
+//
+//	0   magic        "TIQ\x01"
+//	4   sequence     uint32, one more than the last frame's
+//	8   sample rate  uint32
+//	12  center freq  uint64, in Hz
+//	20  length       uint32, bytes of samples following the header
+type Header struct {
+	Sequence   uint32
+	SampleRate uint32
+	CenterFreq uint64
+	Length     uint32
+}
+
+// Marshal returns the header as it's sent.
+func (h Header) Marshal() []byte {
+	b := make([]byte, HeaderLength)
+	copy(b, Magic)
+	binary.LittleEndian.PutUint32(b[4:8], h.Sequence)
+	binary.LittleEndian.PutUint32(b[8:12], h.SampleRate)
+	binary.LittleEndian.PutUint64(b[12:20], h.CenterFreq)
+	binary.LittleEndian.PutUint32(b[20:24], h.Length)
+	return b
+}
+
+// Unmarshal reads a header from the start of b.
+func (h *Header) Unmarshal(b []byte) error {
+	if len(b) < HeaderLength || string(b[0:4]) != Magic {
+		return ErrBadMagic
+	}
+	h.Sequence = binary.LittleEndian.Uint32(b[4:8])
+	h.SampleRate = binary.LittleEndian.Uint32(b[8:12])
+	h.CenterFreq = binary.LittleEndian.Uint64(b[12:20])
+	h.Length = binary.LittleEndian.Uint32(b[20:24])
+	if h.Length > MaxFrameLength {
+		return fmt.Errorf("frame of %d bytes is too long", h.Length)
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+// Package iqstream is a device taking framed IQ samples over the network from other software, such as a GNU
+// Radio flowgraph or another Turbine's IQ output.  Each frame is a Header followed by its samples.
+package iqstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	maxSampleRate = 20e6
+
+	// queueLength is how many frames are held for the receiver before they're dropped, so a slow moment
+	// doesn't back up the sender.
+	queueLength = 64
+
+	// maxDatagramLength is the largest UDP datagram, so the largest frame that can be received over UDP.
+	maxDatagramLength = 65535
+
+	dialTimeout = 5 * time.Second
+	// readTimeout is how long the sender can go without sending samples over TCP before it's given up on.
+	readTimeout = 5 * time.Second
+
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+
+	// warningInterval limits how often drops and mismatched frames are logged.
+	warningInterval = time.Second
+)
+
+type IQStreamDevice struct {
+	cfg            config.IQStream
+	format         iq.Format
+	reconnectDelay time.Duration
+
+	// dropped counts samples lost in sequence gaps or because the receiver was behind.  Accessed atomically.
+	dropped uint64
+	// stopped is set once Stop is called.  Accessed atomically.
+	stopped int32
+
+	// These are only used by the goroutine receiving frames.
+	sequence    uint32
+	haveFrame   bool
+	lastWarning time.Time
+
+	lock   sync.Mutex
+	closer io.Closer
+}
+
+// NewIQStreamDevice creates a device receiving frames as cfg describes.
+func NewIQStreamDevice(cfg config.IQStream) (*IQStreamDevice, error) {
+	if cfg.Network == "" {
+		cfg.Network = "tcp"
+	}
+	if cfg.Network != "tcp" && cfg.Network != "udp" {
+		return nil, fmt.Errorf("IQ stream network must be tcp or udp, not %s", cfg.Network)
+	}
+	if cfg.Address == "" {
+		return nil, errors.New("IQ stream needs an address")
+	}
+	format := iq.Format(cfg.Format)
+	if format == "" {
+		format = iq.FormatCS8
+	}
+	if format.SampleSize() == 0 {
+		return nil, errors.New("unknown sample format " + string(format))
+	}
+	return &IQStreamDevice{
+		cfg:            cfg,
+		format:         format,
+		reconnectDelay: minReconnectDelay,
+	}, nil
+}
+
+func (d *IQStreamDevice) MaxSampleRate() int {
+	return maxSampleRate
+}
+
+func (d *IQStreamDevice) Info() device.Info {
+	return device.Info{Name: "IQ stream (" + d.cfg.Network + " " + d.cfg.Address + ")"}
+}
+
+// Format is the stream's sample format.
+func (d *IQStreamDevice) Format() iq.Format {
+	return d.format
+}
+
+// Reconnects is true, since a TCP stream is reconnected whenever it drops, and a UDP stream may simply be waiting
+// for its sender.
+func (d *IQStreamDevice) Reconnects() bool {
+	return true
+}
+
+// DroppedSamples is how many samples have been lost, either missing from the stream or thrown away because
+// the receiver wasn't keeping up.
+func (d *IQStreamDevice) DroppedSamples() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+func (d *IQStreamDevice) Stop() error {
+	atomic.StoreInt32(&d.stopped, 1)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closer != nil {
+		return d.closer.Close()
+	}
+	return nil
+}
+
+// Start receives frames until ctx is done or the device is stopped.  Over TCP it reconnects whenever the
+// connection is lost.
+func (d *IQStreamDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	queue := make(chan *types.SegmentComplex64, queueLength)
+
+	eg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case seg := <-queue:
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case complexSamples <- seg:
+				}
+			}
+		}
+	})
+
+	eg.Go(func() error {
+		if d.cfg.Network == "udp" {
+			err := d.receiveUDP(ctx, centerFreq, sampleRate, queue)
+			if atomic.LoadInt32(&d.stopped) != 0 {
+				return context.Canceled
+			}
+			return err
+		}
+
+		delay := d.reconnectDelay
+		for {
+			connected, err := d.receiveTCP(ctx, centerFreq, sampleRate, queue)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if atomic.LoadInt32(&d.stopped) != 0 {
+				return context.Canceled
+			}
+			if connected {
+				delay = d.reconnectDelay
+			}
+			log.Warn().
+				Err(err).
+				Str("address", d.cfg.Address).
+				Dur("retry_in", delay).
+				Msg("IQ stream connection lost")
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			if delay *= 2; delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+		}
+	})
+
+	return eg.Wait()
+}
+
+// receiveTCP connects to the sender and queues the frames it sends until the connection fails.  connected is
+// whether it got as far as a frame.
+func (d *IQStreamDevice) receiveTCP(ctx context.Context, centerFreq int, sampleRate int, queue chan *types.SegmentComplex64) (connected bool, err error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", d.cfg.Address)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if !d.setCloser(conn) {
+		return false, context.Canceled
+	}
+	defer d.setCloser(nil)
+	defer closeOnDone(ctx, conn)()
+
+	// A new connection starts a new sequence.
+	d.haveFrame = false
+	header := make([]byte, HeaderLength)
+	var payload []byte
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return connected, err
+		}
+		var h Header
+		if err := h.Unmarshal(header); err != nil {
+			// There's no finding the next frame in a stream that's lost its place.
+			return connected, err
+		}
+		connected = true
+		if cap(payload) < int(h.Length) {
+			payload = make([]byte, h.Length)
+		}
+		payload = payload[:h.Length]
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			return connected, err
+		}
+		d.receive(h, payload, centerFreq, sampleRate, queue)
+	}
+}
+
+// receiveUDP listens for frames, one to a datagram, and queues them until the device is stopped.
+func (d *IQStreamDevice) receiveUDP(ctx context.Context, centerFreq int, sampleRate int, queue chan *types.SegmentComplex64) error {
+	conn, err := net.ListenPacket("udp", d.cfg.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !d.setCloser(conn) {
+		return context.Canceled
+	}
+	defer d.setCloser(nil)
+	defer closeOnDone(ctx, conn)()
+
+	buf := make([]byte, maxDatagramLength)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		var h Header
+		if err := h.Unmarshal(buf[:n]); err != nil || int(h.Length) != n-HeaderLength {
+			d.warn().Int("length", n).Msg("ignoring malformed IQ stream datagram")
+			continue
+		}
+		d.receive(h, buf[HeaderLength:n], centerFreq, sampleRate, queue)
+	}
+}
+
+// receive queues a frame's samples, counting any frames missing before it as dropped.
+func (d *IQStreamDevice) receive(h Header, payload []byte, centerFreq int, sampleRate int, queue chan *types.SegmentComplex64) {
+	samples := len(payload) / d.format.SampleSize()
+
+	// Frames are assumed to be the same length as this one when counting what's missing.  A sequence that
+	// goes backwards is a sender that's restarted, or a late datagram, and isn't counted.
+	if gap := h.Sequence - d.sequence - 1; d.haveFrame && gap != 0 && gap < 1<<31 {
+		dropped := atomic.AddUint64(&d.dropped, uint64(gap)*uint64(samples))
+		d.warn().Uint32("frames", gap).Uint64("dropped", dropped).Msg("IQ stream frames missing")
+	}
+	d.sequence = h.Sequence
+	d.haveFrame = true
+
+	if int(h.CenterFreq) != centerFreq || int(h.SampleRate) != sampleRate {
+		atomic.AddUint64(&d.dropped, uint64(samples))
+		d.warn().
+			Uint64("center_freq", h.CenterFreq).
+			Uint32("sample_rate", h.SampleRate).
+			Msg("IQ stream isn't tuned as configured, dropping it")
+		return
+	}
+
+	seg := &types.SegmentComplex64{
+		SampleRate: sampleRate,
+		Frequency:  centerFreq,
+		Data:       make([]complex64, samples),
+	}
+	d.format.Decode(payload, seg.Data)
+
+	select {
+	case queue <- seg:
+	default:
+		dropped := atomic.AddUint64(&d.dropped, uint64(samples))
+		d.warn().Uint64("dropped", dropped).Msg("receiver is behind, dropping IQ stream samples")
+	}
+}
+
+// warn returns a warning to log about the stream, or a disabled event if one has been logged recently.
+func (d *IQStreamDevice) warn() *zerolog.Event {
+	if time.Since(d.lastWarning) < warningInterval {
+		return nil
+	}
+	d.lastWarning = time.Now()
+	return log.Warn().Str("address", d.cfg.Address)
+}
+
+// setCloser records what Stop has to close to stop the device, and reports false if it's already stopped.
+func (d *IQStreamDevice) setCloser(c io.Closer) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.closer = c
+	return atomic.LoadInt32(&d.stopped) == 0
+}
+
+// closeOnDone closes c if ctx is done before the returned function is called, to break off reads that don't
+// watch ctx.
+func closeOnDone(ctx context.Context, c io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
@@ -0,0 +1,130 @@
+package iqstream
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+)
+
+const (
+	testCenterFreq = 852000000
+	testSampleRate = 1000000
+)
+
+var testSamples = []complex64{complex(-20, 10), complex(5, -127), complex(0, 0)}
+
+func frame(sequence uint32, centerFreq uint64) []byte {
+	samples := iq.FormatCS16.Encode(nil, testSamples)
+	h := Header{
+		Sequence:   sequence,
+		SampleRate: testSampleRate,
+		CenterFreq: centerFreq,
+		Length:     uint32(len(samples)),
+	}
+	return append(h.Marshal(), samples...)
+}
+
+// startDevice starts d, and returns its samples and a function stopping it.
+func startDevice(t *testing.T, d *IQStreamDevice) (chan *types.SegmentComplex64, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan *types.SegmentComplex64)
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Start(ctx, testCenterFreq, testSampleRate, out)
+	}()
+	return out, func() {
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Error(err)
+		}
+	}
+}
+
+func expectSegment(t *testing.T, seg *types.SegmentComplex64) {
+	t.Helper()
+	if seg.Frequency != testCenterFreq || seg.SampleRate != testSampleRate {
+		t.Errorf("segment at %d Hz, %d samples per second", seg.Frequency, seg.SampleRate)
+	}
+	if len(seg.Data) != len(testSamples) {
+		t.Fatalf("got %d samples, expected %d", len(seg.Data), len(testSamples))
+	}
+	for i := range testSamples {
+		if seg.Data[i] != testSamples[i] {
+			t.Errorf("sample %d was %v, expected %v", i, seg.Data[i], testSamples[i])
+		}
+	}
+}
+
+func TestTCPStream(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		// The third frame is missing, and the fourth is tuned elsewhere.
+		conn.Write(frame(7, testCenterFreq))
+		conn.Write(frame(8, testCenterFreq))
+		conn.Write(frame(10, testCenterFreq+1000))
+		conn.Write(frame(11, testCenterFreq))
+		time.Sleep(time.Second)
+	}()
+
+	d, err := NewIQStreamDevice(config.IQStream{Address: listener.Addr().String(), Format: "cs16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, stop := startDevice(t, d)
+	for i := 0; i < 3; i++ {
+		expectSegment(t, <-out)
+	}
+	stop()
+	if dropped := d.DroppedSamples(); dropped != uint64(2*len(testSamples)) {
+		t.Errorf("dropped %d samples, expected %d", dropped, 2*len(testSamples))
+	}
+}
+
+func TestUDPStream(t *testing.T) {
+	// Find a free port to listen on.
+	probe, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.LocalAddr().String()
+	probe.Close()
+
+	d, err := NewIQStreamDevice(config.IQStream{Network: "udp", Address: addr, Format: "cs16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, stop := startDevice(t, d)
+	defer stop()
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// Datagrams sent before the device is listening are lost, so keep sending until one arrives.
+	var sequence uint32
+	for {
+		conn.Write(frame(sequence, testCenterFreq))
+		sequence++
+		select {
+		case seg := <-out:
+			expectSegment(t, seg)
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
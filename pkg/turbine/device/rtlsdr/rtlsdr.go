@@ -2,14 +2,22 @@ package rtlsdr
 
 import (
 	"context"
+	"errors"
+	"math"
 	"sync"
 
 	gsdr "github.com/jpoirier/gortlsdr"
 	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
 )
 
 const maxSampleRate = 2e6
 
+// tunerGain is the range of the common R820T tuner's gain, until the dongle is opened and says what its tuner
+// supports.
+var tunerGain = device.GainStage{Name: "tuner", Min: 0, Max: 49.6}
+
 type RTLSDRDevice struct {
 	deviceIdx int
 	device    *gsdr.Context
@@ -20,16 +28,91 @@ type RTLSDRDevice struct {
 	outputChan chan *types.SegmentComplex64
 	ctx        context.Context
 	wg         sync.WaitGroup
+
+	lock       sync.Mutex
+	gainStages []device.GainStage
+	settings   device.Settings
+	// started is whether the dongle is open, so settings are applied as they're changed.
+	started bool
 }
 
 func NewRTLSDRDevice(deviceIdx int) (*RTLSDRDevice, error) {
-	return &RTLSDRDevice{deviceIdx: deviceIdx}, nil
+	autoGain, biasTee, ppm := true, false, 0
+	return &RTLSDRDevice{
+		deviceIdx:  deviceIdx,
+		gainStages: []device.GainStage{tunerGain},
+		settings: device.Settings{
+			// The gain is left to the tuner's automatic gain control until it's set.
+			Gains:    map[string]float64{"tuner": 29.7},
+			AutoGain: &autoGain,
+			BiasTee:  &biasTee,
+			PPM:      &ppm,
+		},
+	}, nil
 }
 
 func (r *RTLSDRDevice) MaxSampleRate() int {
 	return maxSampleRate
 }
 
+// Info describes the device.  Its gain is 0 if it's left to the tuner's automatic gain control.
+func (r *RTLSDRDevice) Info() device.Info {
+	return device.Info{Name: "RTL-SDR", Gain: r.Settings().TotalGain(0)}
+}
+
+func (r *RTLSDRDevice) GainStages() []device.GainStage {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.gainStages
+}
+
+func (r *RTLSDRDevice) Settings() device.Settings {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.settings.With(device.Settings{})
+}
+
+func (r *RTLSDRDevice) Apply(changes device.Settings) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := device.Check(r.gainStages, r.settings, changes); err != nil {
+		return err
+	}
+	settings := r.settings.With(changes)
+	if r.started {
+		if err := r.set(settings); err != nil {
+			return err
+		}
+	}
+	r.settings = settings
+	return nil
+}
+
+// set applies settings to the dongle.  The tuner picks the closest gain it supports.
+func (r *RTLSDRDevice) set(settings device.Settings) error {
+	if *settings.AutoGain {
+		if err := r.device.SetTunerGainMode(false); err != nil {
+			return err
+		}
+	} else {
+		if err := r.device.SetTunerGainMode(true); err != nil {
+			return err
+		}
+		if err := r.device.SetTunerGain(int(math.Round(settings.Gains["tuner"] * 10))); err != nil {
+			return err
+		}
+	}
+	if err := r.device.SetFreqCorrection(*settings.PPM); err != nil {
+		return err
+	}
+	return r.device.SetBiasTee(*settings.BiasTee)
+}
+
+// Format is cs8: the device's bytes are converted as if they were signed, so cs8 gives them back unchanged.
+func (r *RTLSDRDevice) Format() iq.Format {
+	return iq.FormatCS8
+}
+
 func (r *RTLSDRDevice) callback(buf []byte) {
 	r.wg.Add(1)
 	defer r.wg.Done()
@@ -46,40 +129,77 @@ func (r *RTLSDRDevice) callback(buf []byte) {
 	}
 }
 
+// Stop stops reading and closes the dongle.  Only the first of concurrent calls closes it.
 func (r *RTLSDRDevice) Stop() error {
+	r.lock.Lock()
+	r.started = false
+	dev := r.device
+	r.device = nil
+	r.lock.Unlock()
+	if dev == nil {
+		return nil
+	}
 
-	err := r.device.CancelAsync()
+	err := dev.CancelAsync()
 
 	r.wg.Wait()
 	if err != nil {
+		dev.Close()
 		return err
 	}
 
-	return r.device.Close()
+	return dev.Close()
 }
 
 func (r *RTLSDRDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
-	var err error
-	r.device, err = gsdr.Open(r.deviceIdx)
+	dev, err := gsdr.Open(r.deviceIdx)
 	if err != nil {
 		return err
 	}
+	r.lock.Lock()
+	r.device = dev
+	r.lock.Unlock()
 	r.ctx = ctx
 	r.centerFreq = centerFreq
 	r.sampleRate = sampleRate
 	r.outputChan = complexSamples
 
-	if err := r.device.SetCenterFreq(r.centerFreq); err != nil {
+	if err := dev.SetCenterFreq(r.centerFreq); err != nil {
+		return err
+	}
+	if err := dev.SetSampleRate(r.sampleRate); err != nil {
 		return err
 	}
-	if err := r.device.SetSampleRate(r.sampleRate); err != nil {
+	if err := r.configure(); err != nil {
 		return err
 	}
-	if err := r.device.ResetBuffer(); err != nil {
+	if err := dev.ResetBuffer(); err != nil {
 		return err
 	}
 
 	r.wg.Add(1)
 	defer r.wg.Done()
-	return r.device.ReadAsync(r.callback, nil, 0, 0)
+	return dev.ReadAsync(r.callback, nil, 0, 0)
+}
+
+// configure finds the tuner's gain range and applies the settings.
+func (r *RTLSDRDevice) configure() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.device == nil {
+		return errors.New("device stopped while starting")
+	}
+	if gains, err := r.device.GetTunerGains(); err == nil && len(gains) > 0 {
+		stage := device.GainStage{Name: tunerGain.Name, Min: float64(gains[0]) / 10, Max: float64(gains[0]) / 10}
+		for _, gain := range gains {
+			stage.Min = math.Min(stage.Min, float64(gain)/10)
+			stage.Max = math.Max(stage.Max, float64(gain)/10)
+		}
+		r.gainStages = []device.GainStage{stage}
+	}
+	if err := r.set(r.settings); err != nil {
+		return err
+	}
+	r.started = true
+	return nil
 }
@@ -0,0 +1,365 @@
+// Package rtltcp is a device taking its samples from an rtl_tcp server, so the dongle can be somewhere else
+// on the network.
+package rtltcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	maxSampleRate = 3.2e6
+
+	defaultAddress = "127.0.0.1:1234"
+
+	// segmentLength is how many samples are delivered at a time, about 27ms at 2.4M samples per second.
+	segmentLength = 65536
+	// queueLength is how many segments are held for the receiver before they're dropped, so a slow moment
+	// doesn't back up the connection.
+	queueLength = 32
+
+	dialTimeout = 5 * time.Second
+	// readTimeout is how long the server can go without sending samples before it's given up on.
+	readTimeout = 5 * time.Second
+
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+
+	// dropWarningInterval limits how often drops are logged.
+	dropWarningInterval = time.Second
+)
+
+// Commands are a byte followed by a big-endian uint32 parameter.
+const (
+	cmdSetFrequency  = 0x01
+	cmdSetSampleRate = 0x02
+	cmdSetGainMode   = 0x03
+	cmdSetGain       = 0x04
+	cmdSetFreqCorr   = 0x05
+	cmdSetBiasTee    = 0x0e
+)
+
+// tunerGain is the range of the common R820T tuner's gain.  The server picks the closest gain its tuner supports.
+var tunerGain = device.GainStage{Name: "tuner", Min: 0, Max: 49.6}
+
+// headerMagic starts the header the server sends on connecting, followed by the tuner type and how many gains
+// it supports.
+const (
+	headerMagic  = "RTL0"
+	headerLength = 12
+)
+
+var tunerNames = map[uint32]string{
+	1: "E4000",
+	2: "FC0012",
+	3: "FC0013",
+	4: "FC2580",
+	5: "R820T",
+	6: "R828D",
+}
+
+type RTLTCPDevice struct {
+	cfg            config.RTLTCP
+	reconnectDelay time.Duration
+
+	// dropped counts samples the receiver was too far behind to take.  Accessed atomically.
+	dropped uint64
+	// stopped is set once Stop is called.  Accessed atomically.
+	stopped int32
+	// lastDropWarning is only used by the goroutine reading from the server.
+	lastDropWarning time.Time
+
+	lock sync.Mutex
+	conn net.Conn
+	// settings are sent to the server on connecting, and as they're changed.
+	settings device.Settings
+}
+
+func NewRTLTCPDevice(cfg config.RTLTCP) *RTLTCPDevice {
+	if cfg.Address == "" {
+		cfg.Address = defaultAddress
+	}
+	autoGain, biasTee, ppm := cfg.Gain == 0, false, cfg.PPM
+	gain := cfg.Gain
+	if autoGain {
+		gain = 29.7
+	}
+	return &RTLTCPDevice{
+		cfg:            cfg,
+		reconnectDelay: minReconnectDelay,
+		settings: device.Settings{
+			Gains:    map[string]float64{tunerGain.Name: gain},
+			AutoGain: &autoGain,
+			BiasTee:  &biasTee,
+			PPM:      &ppm,
+		},
+	}
+}
+
+func (d *RTLTCPDevice) MaxSampleRate() int {
+	return maxSampleRate
+}
+
+// Info describes the device.  Its gain is 0 if it's left to the tuner's automatic gain control.
+func (d *RTLTCPDevice) Info() device.Info {
+	return device.Info{Name: "RTL-SDR (rtl_tcp)", Gain: d.Settings().TotalGain(0)}
+}
+
+func (d *RTLTCPDevice) GainStages() []device.GainStage {
+	return []device.GainStage{tunerGain}
+}
+
+func (d *RTLTCPDevice) Settings() device.Settings {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.settings.With(device.Settings{})
+}
+
+// Apply changes the settings, sending them to the server if it's connected.
+func (d *RTLTCPDevice) Apply(changes device.Settings) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := device.Check(d.GainStages(), d.settings, changes); err != nil {
+		return err
+	}
+	settings := d.settings.With(changes)
+	if d.conn != nil {
+		if err := sendCommands(d.conn, settingsCommands(settings)); err != nil {
+			return err
+		}
+	}
+	d.settings = settings
+	return nil
+}
+
+// Format is cu8, which rtl_tcp sends.
+func (d *RTLTCPDevice) Format() iq.Format {
+	return iq.FormatCU8
+}
+
+// Reconnects is true, since the device reconnects to the server whenever its samples stop.
+func (d *RTLTCPDevice) Reconnects() bool {
+	return true
+}
+
+// DroppedSamples is how many samples have been thrown away because the receiver wasn't keeping up.
+func (d *RTLTCPDevice) DroppedSamples() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
+
+func (d *RTLTCPDevice) Stop() error {
+	atomic.StoreInt32(&d.stopped, 1)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.conn != nil {
+		return d.conn.Close()
+	}
+	return nil
+}
+
+// Start streams samples from the server until ctx is done or the device is stopped, reconnecting whenever
+// the connection is lost.
+func (d *RTLTCPDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
+	eg, ctx := errgroup.WithContext(ctx)
+	queue := make(chan *types.SegmentComplex64, queueLength)
+
+	eg.Go(func() error {
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case seg := <-queue:
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case complexSamples <- seg:
+				}
+			}
+		}
+	})
+
+	eg.Go(func() error {
+		delay := d.reconnectDelay
+		for {
+			connected, err := d.stream(ctx, centerFreq, sampleRate, queue)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if atomic.LoadInt32(&d.stopped) != 0 {
+				return context.Canceled
+			}
+			if connected {
+				delay = d.reconnectDelay
+			}
+			log.Warn().
+				Err(err).
+				Str("address", d.cfg.Address).
+				Dur("retry_in", delay).
+				Msg("rtl_tcp connection lost")
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			if delay *= 2; delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+		}
+	})
+
+	return eg.Wait()
+}
+
+// stream connects to the server, tunes it and queues its samples until the connection fails.  connected is
+// whether it got as far as the server's header.
+func (d *RTLTCPDevice) stream(ctx context.Context, centerFreq int, sampleRate int, queue chan *types.SegmentComplex64) (connected bool, err error) {
+	dialer := net.Dialer{Timeout: dialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", d.cfg.Address)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	d.setConn(conn)
+	defer d.setConn(nil)
+	if atomic.LoadInt32(&d.stopped) != 0 {
+		return false, context.Canceled
+	}
+
+	// Reads don't watch ctx, so the connection is closed under them instead.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	header := make([]byte, headerLength)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return false, fmt.Errorf("reading header: %w", err)
+	}
+	if string(header[0:4]) != headerMagic {
+		return false, errors.New("not an rtl_tcp server")
+	}
+	tuner := binary.BigEndian.Uint32(header[4:8])
+	log.Info().
+		Str("address", d.cfg.Address).
+		Str("tuner", tunerName(tuner)).
+		Uint32("gains", binary.BigEndian.Uint32(header[8:12])).
+		Msg("connected to rtl_tcp server")
+
+	if err := d.configure(conn, centerFreq, sampleRate); err != nil {
+		return true, err
+	}
+
+	buf := make([]byte, segmentLength*iq.FormatCU8.SampleSize())
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		n, err := io.ReadFull(conn, buf)
+		if n >= iq.FormatCU8.SampleSize() {
+			seg := &types.SegmentComplex64{
+				SampleRate: sampleRate,
+				Frequency:  centerFreq,
+				Data:       make([]complex64, n/iq.FormatCU8.SampleSize()),
+			}
+			iq.FormatCU8.Decode(buf[:n], seg.Data)
+			d.enqueue(queue, seg)
+		}
+		if err != nil {
+			return true, err
+		}
+	}
+}
+
+// configure tunes the server and applies the settings.
+func (d *RTLTCPDevice) configure(conn net.Conn, centerFreq int, sampleRate int) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	cmds := [][2]uint32{
+		{cmdSetSampleRate, uint32(sampleRate)},
+		{cmdSetFrequency, uint32(centerFreq)},
+	}
+	return sendCommands(conn, append(cmds, settingsCommands(d.settings)...))
+}
+
+// settingsCommands are the commands that apply settings.
+func settingsCommands(settings device.Settings) [][2]uint32 {
+	cmds := [][2]uint32{{cmdSetFreqCorr, uint32(int32(*settings.PPM))}}
+	if *settings.AutoGain {
+		cmds = append(cmds, [2]uint32{cmdSetGainMode, 0})
+	} else {
+		// Gains are in tenths of a dB.
+		cmds = append(cmds,
+			[2]uint32{cmdSetGainMode, 1},
+			[2]uint32{cmdSetGain, uint32(int32(math.Round(settings.Gains[tunerGain.Name] * 10)))})
+	}
+	biasTee := uint32(0)
+	if *settings.BiasTee {
+		biasTee = 1
+	}
+	return append(cmds, [2]uint32{cmdSetBiasTee, biasTee})
+}
+
+func sendCommands(conn net.Conn, cmds [][2]uint32) error {
+	buf := make([]byte, 0, 5*len(cmds))
+	for _, cmd := range cmds {
+		var b [5]byte
+		b[0] = byte(cmd[0])
+		binary.BigEndian.PutUint32(b[1:], cmd[1])
+		buf = append(buf, b[:]...)
+	}
+	_, err := conn.Write(buf)
+	return err
+}
+
+// enqueue queues seg for the receiver, or drops it if the receiver is too far behind.
+func (d *RTLTCPDevice) enqueue(queue chan *types.SegmentComplex64, seg *types.SegmentComplex64) {
+	select {
+	case queue <- seg:
+		return
+	default:
+	}
+	dropped := atomic.AddUint64(&d.dropped, uint64(len(seg.Data)))
+	if time.Since(d.lastDropWarning) < dropWarningInterval {
+		return
+	}
+	d.lastDropWarning = time.Now()
+	log.Warn().
+		Str("address", d.cfg.Address).
+		Uint64("dropped", dropped).
+		Msg("receiver is behind, dropping rtl_tcp samples")
+}
+
+func (d *RTLTCPDevice) setConn(conn net.Conn) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.conn = conn
+}
+
+func tunerName(tuner uint32) string {
+	if name, ok := tunerNames[tuner]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown (%d)", tuner)
+}
@@ -0,0 +1,142 @@
+package rtltcp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+)
+
+const (
+	testCenterFreq = 852000000
+	testSampleRate = 2400000
+)
+
+type command struct {
+	cmd   byte
+	param uint32
+}
+
+// testServer stands in for rtl_tcp.  Each connection is sent the header, has its commands read, and is sent
+// samples before being closed.
+type testServer struct {
+	listener net.Listener
+	samples  []byte
+	commands chan []command
+}
+
+func newTestServer(t *testing.T, samples []byte) *testServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{
+		listener: listener,
+		samples:  samples,
+		commands: make(chan []command, 2),
+	}
+	go s.serve()
+	return s
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		header := make([]byte, headerLength)
+		copy(header, headerMagic)
+		binary.BigEndian.PutUint32(header[4:8], 5)
+		binary.BigEndian.PutUint32(header[8:12], 29)
+		conn.Write(header)
+
+		var cmds []command
+		buf := make([]byte, 5)
+		for i := 0; i < 6; i++ {
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				break
+			}
+			cmds = append(cmds, command{buf[0], binary.BigEndian.Uint32(buf[1:])})
+		}
+		s.commands <- cmds
+
+		conn.Write(s.samples)
+		conn.Close()
+	}
+}
+
+func TestRTLTCPDevice(t *testing.T) {
+	samples := []byte{138, 108, 0, 255, 127, 128}
+	want := make([]complex64, len(samples)/2)
+	iq.FormatCU8.Decode(samples, want)
+	server := newTestServer(t, samples)
+	defer server.listener.Close()
+
+	d := NewRTLTCPDevice(config.RTLTCP{Address: server.listener.Addr().String(), Gain: 19.7, PPM: -3})
+	d.reconnectDelay = 10 * time.Millisecond
+	biasTee := true
+	if err := d.Apply(device.Settings{BiasTee: &biasTee}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Apply(device.Settings{Amp: &biasTee}); !errors.Is(err, device.ErrUnsupportedSetting) {
+		t.Errorf("setting the amp returned %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out := make(chan *types.SegmentComplex64)
+	done := make(chan error, 1)
+	go func() {
+		done <- d.Start(ctx, testCenterFreq, testSampleRate, out)
+	}()
+
+	// The server hangs up after sending its samples, so they should come twice.
+	for i := 0; i < 2; i++ {
+		cmds := <-server.commands
+		expected := []command{
+			{cmdSetSampleRate, testSampleRate},
+			{cmdSetFrequency, testCenterFreq},
+			{cmdSetFreqCorr, uint32(0xfffffffd)},
+			{cmdSetGainMode, 1},
+			{cmdSetGain, 197},
+			{cmdSetBiasTee, 1},
+		}
+		if len(cmds) != len(expected) {
+			t.Fatalf("got commands %v, expected %v", cmds, expected)
+		}
+		for j := range expected {
+			if cmds[j] != expected[j] {
+				t.Errorf("command %d was %v, expected %v", j, cmds[j], expected[j])
+			}
+		}
+
+		seg := <-out
+		if seg.Frequency != testCenterFreq || seg.SampleRate != testSampleRate {
+			t.Errorf("segment at %d Hz, %d samples per second", seg.Frequency, seg.SampleRate)
+		}
+		if len(seg.Data) != len(want) {
+			t.Fatalf("got %d samples, expected %d", len(seg.Data), len(want))
+		}
+		for j := range want {
+			if seg.Data[j] != want[j] {
+				t.Errorf("sample %d was %v, expected %v", j, seg.Data[j], want[j])
+			}
+		}
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatal(err)
+	}
+	if d.DroppedSamples() != 0 {
+		t.Errorf("dropped %d samples", d.DroppedSamples())
+	}
+}
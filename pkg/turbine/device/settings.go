@@ -0,0 +1,174 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ErrUnsupportedSetting is returned when a device is given a setting it doesn't have.
+var ErrUnsupportedSetting = errors.New("setting not supported by device")
+
+// Settings are a device's front end settings.  When they're changes to apply, settings left out are left as
+// they are; when they're reported, settings the device doesn't have are left out.
+type Settings struct {
+	// Gains are each gain stage's gain in dB, by the stage's name.
+	Gains map[string]float64 `json:"gains,omitempty" yaml:"gains"`
+	// AutoGain leaves the gain to the device's automatic gain control.  Setting a gain turns it off.
+	AutoGain *bool `json:"auto_gain,omitempty" yaml:"auto_gain"`
+	// Amp enables the RF amplifier.
+	Amp *bool `json:"amp,omitempty" yaml:"amp"`
+	// BiasTee powers an active antenna or LNA through the antenna port.
+	BiasTee *bool `json:"bias_tee,omitempty" yaml:"bias_tee"`
+	// PPM is a frequency correction the device applies itself, in parts per million.  The receiver's own
+	// correction is applied on top of it.
+	PPM *int `json:"ppm,omitempty" yaml:"ppm"`
+	// Antenna selects which antenna port is received from.
+	Antenna string `json:"antenna,omitempty" yaml:"antenna"`
+}
+
+// GainStage describes one of a device's gain stages.
+type GainStage struct {
+	Name string  `json:"name"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	// Step is the gain's resolution in dB, or 0 if the device picks the closest gain it supports.
+	Step float64 `json:"step,omitempty"`
+}
+
+// Controller is implemented by devices whose front end settings can be changed, including while they run.
+type Controller interface {
+	GainStages() []GainStage
+	// Settings returns the current settings.
+	Settings() Settings
+	// Apply changes the settings given, leaving the rest as they are.  Settings the device doesn't have are
+	// rejected with ErrUnsupportedSetting, and none of the changes are made.
+	Apply(changes Settings) error
+}
+
+// IsEmpty is whether there are no settings at all.
+func (s Settings) IsEmpty() bool {
+	return len(s.Gains) == 0 && s.AutoGain == nil && s.Amp == nil && s.BiasTee == nil && s.PPM == nil &&
+		s.Antenna == ""
+}
+
+// With returns s with changes applied.  s isn't modified.
+func (s Settings) With(changes Settings) Settings {
+	gains := make(map[string]float64, len(s.Gains))
+	for name, gain := range s.Gains {
+		gains[name] = gain
+	}
+	for name, gain := range changes.Gains {
+		gains[name] = gain
+	}
+	s.Gains = gains
+	if len(changes.Gains) > 0 && s.AutoGain != nil && changes.AutoGain == nil {
+		changes.AutoGain = boolPtr(false)
+	}
+	if changes.AutoGain != nil {
+		s.AutoGain = boolPtr(*changes.AutoGain)
+	}
+	if changes.Amp != nil {
+		s.Amp = boolPtr(*changes.Amp)
+	}
+	if changes.BiasTee != nil {
+		s.BiasTee = boolPtr(*changes.BiasTee)
+	}
+	if changes.PPM != nil {
+		ppm := *changes.PPM
+		s.PPM = &ppm
+	}
+	if changes.Antenna != "" {
+		s.Antenna = changes.Antenna
+	}
+	return s
+}
+
+// Check rejects changes to settings current doesn't have, and gains outside their stage's range.  Gains on
+// stages with a step are rounded down to it, as the hardware would.
+func Check(stages []GainStage, current Settings, changes Settings) error {
+	var unsupported []string
+	if changes.AutoGain != nil && current.AutoGain == nil {
+		unsupported = append(unsupported, "auto_gain")
+	}
+	if changes.Amp != nil && current.Amp == nil {
+		unsupported = append(unsupported, "amp")
+	}
+	if changes.BiasTee != nil && current.BiasTee == nil {
+		unsupported = append(unsupported, "bias_tee")
+	}
+	if changes.PPM != nil && current.PPM == nil {
+		unsupported = append(unsupported, "ppm")
+	}
+	if changes.Antenna != "" && current.Antenna == "" {
+		unsupported = append(unsupported, "antenna")
+	}
+	for name, gain := range changes.Gains {
+		stage, ok := findStage(stages, name)
+		if !ok {
+			unsupported = append(unsupported, name+" gain")
+			continue
+		}
+		if gain < stage.Min || gain > stage.Max {
+			return fmt.Errorf("%w: %s gain %.1f dB outside %.1f to %.1f dB", ErrUnsupportedSetting, name, gain,
+				stage.Min, stage.Max)
+		}
+		changes.Gains[name] = stage.round(gain)
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		return fmt.Errorf("%w: %s", ErrUnsupportedSetting, strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+// Rounded returns s with gains on stages with a step rounded down to it, as the hardware applies them.  s isn't
+// modified.
+func (s Settings) Rounded(stages []GainStage) Settings {
+	s = s.With(Settings{})
+	for name, gain := range s.Gains {
+		if stage, ok := findStage(stages, name); ok {
+			s.Gains[name] = stage.round(gain)
+		}
+	}
+	return s
+}
+
+// round rounds gain down to the stage's step, if it has one.
+func (g GainStage) round(gain float64) float64 {
+	if g.Step <= 0 {
+		return gain
+	}
+	return g.Min + math.Floor((gain-g.Min)/g.Step)*g.Step
+}
+
+// TotalGain is the sum of every gain stage's gain, with ampGain added if the amp is enabled, or 0 if the gain is
+// automatic.
+func (s Settings) TotalGain(ampGain float64) float64 {
+	if s.AutoGain != nil && *s.AutoGain {
+		return 0
+	}
+	var total float64
+	for _, gain := range s.Gains {
+		total += gain
+	}
+	if s.Amp != nil && *s.Amp {
+		total += ampGain
+	}
+	return total
+}
+
+func findStage(stages []GainStage, name string) (GainStage, bool) {
+	for _, stage := range stages {
+		if stage.Name == name {
+			return stage, true
+		}
+	}
+	return GainStage{}, false
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,47 @@
+package device
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSettings(t *testing.T) {
+	stages := []GainStage{{Name: "lna", Min: 0, Max: 40, Step: 8}, {Name: "tuner", Min: 0, Max: 49.6}}
+	autoGain, ppm := true, 0
+	current := Settings{Gains: map[string]float64{"lna": 32, "tuner": 29.7}, AutoGain: &autoGain, PPM: &ppm}
+
+	changes := Settings{Gains: map[string]float64{"lna": 20, "tuner": 40.2}}
+	if err := Check(stages, current, changes); err != nil {
+		t.Fatal(err)
+	}
+	settings := current.With(changes)
+	if settings.Gains["lna"] != 16 || settings.Gains["tuner"] != 40.2 || *settings.AutoGain {
+		t.Errorf("unexpected settings %+v", settings)
+	}
+	if current.Gains["lna"] != 32 || !*current.AutoGain {
+		t.Errorf("settings changed in place %+v", current)
+	}
+	if settings.TotalGain(14) != 56.2 {
+		t.Errorf("total gain %.1f dB", settings.TotalGain(14))
+	}
+
+	amp := true
+	requested := Settings{Gains: map[string]float64{"lna": 39, "tuner": 29.7}, Amp: &amp}
+	if rounded := requested.Rounded(stages); rounded.Gains["lna"] != 32 || rounded.TotalGain(14) != 75.7 {
+		t.Errorf("unexpected rounded settings %+v", rounded)
+	}
+	if requested.Gains["lna"] != 39 {
+		t.Errorf("settings rounded in place %+v", requested)
+	}
+
+	for _, bad := range []Settings{
+		{Gains: map[string]float64{"lna": 48}},
+		{Gains: map[string]float64{"vga": 20}},
+		{Amp: &amp},
+		{Antenna: "RX2"},
+	} {
+		if err := Check(stages, current, bad); !errors.Is(err, ErrUnsupportedSetting) {
+			t.Errorf("%+v accepted: %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package sigmf reads and writes SigMF metadata, and records any device's samples as a SigMF recording.
+package sigmf
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	Version = "1.0.0"
+
+	MetaExtension = ".sigmf-meta"
+	DataExtension = ".sigmf-data"
+
+	DatatypeCI8  = "ci8"
+	DatatypeCU8  = "cu8"
+	DatatypeCI16 = "ci16_le"
+	DatatypeCF32 = "cf32_le"
+)
+
+// Meta is the contents of a .sigmf-meta file.
+type Meta struct {
+	Global      Global       `json:"global"`
+	Captures    []Capture    `json:"captures"`
+	Annotations []Annotation `json:"annotations"`
+}
+
+type Global struct {
+	Datatype    string      `json:"core:datatype"`
+	SampleRate  float64     `json:"core:sample_rate"`
+	Version     string      `json:"core:version"`
+	Description string      `json:"core:description,omitempty"`
+	Hardware    string      `json:"core:hw,omitempty"`
+	Recorder    string      `json:"core:recorder,omitempty"`
+	Extensions  []Extension `json:"core:extensions,omitempty"`
+}
+
+type Extension struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Optional bool   `json:"optional"`
+}
+
+// Capture describes the samples from SampleStart on.
+type Capture struct {
+	SampleStart int64      `json:"core:sample_start"`
+	Frequency   float64    `json:"core:frequency"`
+	DateTime    *time.Time `json:"core:datetime,omitempty"`
+	// Gain is the device's total gain in dB when the capture started.
+	Gain *float64 `json:"turbine:gain,omitempty"`
+}
+
+type Annotation struct {
+	SampleStart int64  `json:"core:sample_start"`
+	SampleCount int64  `json:"core:sample_count"`
+	Label       string `json:"core:label,omitempty"`
+	Comment     string `json:"core:comment,omitempty"`
+}
+
+// turbineExtension declares the turbine: fields, which readers are free to ignore.
+var turbineExtension = Extension{Name: "turbine", Version: "1.0.0", Optional: true}
+
+// Paths returns the meta and data file paths of the recording at path, which may name either file or neither.
+func Paths(path string) (meta, data string) {
+	base := strings.TrimSuffix(strings.TrimSuffix(path, MetaExtension), DataExtension)
+	return base + MetaExtension, base + DataExtension
+}
+
+// IsRecording reports whether path names a SigMF recording, by whether its meta file exists.
+func IsRecording(path string) bool {
+	meta, _ := Paths(path)
+	_, err := os.Stat(meta)
+	return err == nil
+}
+
+func ReadMeta(path string) (*Meta, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Meta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+func WriteMeta(path string, m *Meta) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,140 @@
+package sigmf
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"golang.org/x/sync/errgroup"
+)
+
+const recorderName = "turbine"
+
+// RecordingDevice passes a device's samples on as they are while recording them.
+type RecordingDevice struct {
+	device.Device
+	path string
+	// starts counts the times the device has been started, so a device that's restarted doesn't overwrite its
+	// earlier recordings.
+	starts int
+}
+
+// NewRecordingDevice records dev's samples to path, which is given a .sigmf-meta and .sigmf-data extension.
+// Samples are written in the device's own format if it has one, so they're kept exactly as they arrived, and as
+// cf32 otherwise.
+func NewRecordingDevice(dev device.Device, path string) *RecordingDevice {
+	return &RecordingDevice{
+		Device: dev,
+		path:   path,
+	}
+}
+
+// Format is the format the samples are recorded in.
+func (r *RecordingDevice) Format() iq.Format {
+	if d, ok := r.Device.(device.Formatter); ok {
+		return d.Format()
+	}
+	return iq.FormatCF32
+}
+
+// Info describes the device being recorded.
+func (r *RecordingDevice) Info() device.Info {
+	if d, ok := r.Device.(device.Describer); ok {
+		return d.Info()
+	}
+	return device.Info{}
+}
+
+// DroppedSamples is how many samples the device being recorded has lost, if it can lose them.
+func (r *RecordingDevice) DroppedSamples() uint64 {
+	if d, ok := r.Device.(device.Dropper); ok {
+		return d.DroppedSamples()
+	}
+	return 0
+}
+
+// Reconnects is whether the device being recorded recovers by itself when its samples stop.
+func (r *RecordingDevice) Reconnects() bool {
+	if d, ok := r.Device.(device.Reconnector); ok {
+		return d.Reconnects()
+	}
+	return false
+}
+
+func (r *RecordingDevice) GainStages() []device.GainStage {
+	if d, ok := r.Device.(device.Controller); ok {
+		return d.GainStages()
+	}
+	return nil
+}
+
+// Settings are the front end settings of the device being recorded, if it has any.
+func (r *RecordingDevice) Settings() device.Settings {
+	if d, ok := r.Device.(device.Controller); ok {
+		return d.Settings()
+	}
+	return device.Settings{}
+}
+
+func (r *RecordingDevice) Apply(changes device.Settings) error {
+	if d, ok := r.Device.(device.Controller); ok {
+		return d.Apply(changes)
+	}
+	return device.Check(nil, device.Settings{}, changes)
+}
+
+func (r *RecordingDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
+	info := r.Info()
+	now := time.Now().UTC()
+	capture := Capture{
+		Frequency: float64(centerFreq),
+		DateTime:  &now,
+	}
+	if info.Gain != 0 {
+		capture.Gain = &info.Gain
+	}
+	// A restarted device's samples are recorded to path-1, path-2 and so on.
+	path := r.path
+	if r.starts > 0 {
+		path = fmt.Sprintf("%s-%d", r.path, r.starts)
+	}
+	r.starts++
+	w, err := Create(path, r.Format(), Global{SampleRate: float64(sampleRate), Hardware: info.Name}, capture)
+	if err != nil {
+		return err
+	}
+
+	samples := make(chan *types.SegmentComplex64, cap(complexSamples))
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.Go(func() error {
+		return r.Device.Start(egCtx, centerFreq, sampleRate, samples)
+	})
+	eg.Go(func() error {
+		for {
+			select {
+			case <-egCtx.Done():
+				return egCtx.Err()
+			case seg := <-samples:
+				if err := w.Write(seg.Data); err != nil {
+					return err
+				}
+
+				select {
+				case <-egCtx.Done():
+					return egCtx.Err()
+				case complexSamples <- seg:
+				}
+			}
+		}
+	})
+
+	err = eg.Wait()
+	if closeErr := w.Close(); closeErr != nil && (err == nil || err == context.Canceled) {
+		return closeErr
+	}
+	return err
+}
@@ -0,0 +1,33 @@
+package sigmf
+
+import (
+	"fmt"
+
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+)
+
+var datatypeFormats = map[string]iq.Format{
+	DatatypeCI8:  iq.FormatCS8,
+	DatatypeCU8:  iq.FormatCU8,
+	DatatypeCI16: iq.FormatCS16,
+	DatatypeCF32: iq.FormatCF32,
+}
+
+// Format returns the sample format of a SigMF datatype.
+func Format(datatype string) (iq.Format, error) {
+	f, ok := datatypeFormats[datatype]
+	if !ok {
+		return "", fmt.Errorf("unsupported SigMF datatype %q", datatype)
+	}
+	return f, nil
+}
+
+// Datatype returns the SigMF datatype of a sample format.
+func Datatype(format iq.Format) (string, error) {
+	for datatype, f := range datatypeFormats {
+		if f == format {
+			return datatype, nil
+		}
+	}
+	return "", fmt.Errorf("no SigMF datatype for %q", format)
+}
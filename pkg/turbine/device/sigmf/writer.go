@@ -0,0 +1,96 @@
+package sigmf
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+)
+
+// Writer writes samples to a SigMF recording as they come.  The meta file is written when the recording is
+// created, so it's readable if it's never closed, and again with its annotations when it's closed.
+type Writer struct {
+	metaPath string
+	meta     Meta
+	format   iq.Format
+	data     *os.File
+	w        *bufio.Writer
+	buf      []byte
+	samples  int64
+}
+
+// Create starts a recording at path, which is given a .sigmf-meta and .sigmf-data extension, of samples in
+// format.  The datatype, version and recorder are filled in from global.
+func Create(path string, format iq.Format, global Global, capture Capture) (*Writer, error) {
+	datatype, err := Datatype(format)
+	if err != nil {
+		return nil, err
+	}
+	metaPath, dataPath := Paths(path)
+	if err := os.MkdirAll(filepath.Dir(dataPath), 0755); err != nil {
+		return nil, err
+	}
+
+	global.Datatype = datatype
+	global.Version = Version
+	global.Recorder = recorderName
+	global.Extensions = []Extension{turbineExtension}
+	w := &Writer{
+		metaPath: metaPath,
+		meta: Meta{
+			Global:      global,
+			Captures:    []Capture{capture},
+			Annotations: []Annotation{},
+		},
+		format: format,
+	}
+	if err := WriteMeta(metaPath, &w.meta); err != nil {
+		return nil, err
+	}
+	if w.data, err = os.Create(dataPath); err != nil {
+		return nil, err
+	}
+	w.w = bufio.NewWriterSize(w.data, 1<<20)
+	return w, nil
+}
+
+func (w *Writer) Write(samples []complex64) error {
+	w.buf = w.format.Encode(w.buf[:0], samples)
+	if _, err := w.w.Write(w.buf); err != nil {
+		return err
+	}
+	w.samples += int64(len(samples))
+	return nil
+}
+
+// WriteEncoded writes samples already encoded in the recording's format.
+func (w *Writer) WriteEncoded(data []byte) error {
+	if _, err := w.w.Write(data); err != nil {
+		return err
+	}
+	w.samples += int64(len(data) / w.format.SampleSize())
+	return nil
+}
+
+// Samples is how many samples have been written.
+func (w *Writer) Samples() int64 {
+	return w.samples
+}
+
+// Annotate adds an annotation, which is written to the meta file when the recording is closed.
+func (w *Writer) Annotate(a Annotation) {
+	w.meta.Annotations = append(w.meta.Annotations, a)
+}
+
+// Close flushes the samples and writes the meta file with its annotations.
+func (w *Writer) Close() error {
+	err := w.w.Flush()
+	if closeErr := w.data.Close(); err == nil {
+		err = closeErr
+	}
+	if metaErr := WriteMeta(w.metaPath, &w.meta); err == nil {
+		err = metaErr
+	}
+	return err
+}
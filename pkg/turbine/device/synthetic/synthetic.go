@@ -0,0 +1,216 @@
+// Package synthetic is a device that generates its samples instead of receiving them: SmartNet control
+// channels granting scripted calls, FM voice channels carrying them, and noise.
+package synthetic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/op25/frame/smartnet"
+	"github.com/norasector/turbine/pkg/synth"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+)
+
+const (
+	maxSampleRate = 20e6
+
+	// segmentLength is how many samples are generated at a time, about 16ms at 8M samples per second.
+	segmentLength = 131072
+
+	defaultAmplitude = 40
+	defaultToneFreq  = 1000
+	defaultDuration  = 5 * time.Second
+
+	// grantInterval is how often a call's grant is repeated while it lasts, as a real site would.
+	grantInterval = time.Second
+)
+
+type SyntheticDevice struct {
+	cfg        config.Synthetic
+	recordings map[string]*synth.Recording
+}
+
+// NewSyntheticDevice creates a device generating what cfg describes.  WAV files are loaded here, so a bad path
+// fails before anything starts.
+func NewSyntheticDevice(cfg config.Synthetic) (*SyntheticDevice, error) {
+	if len(cfg.Calls) > 0 && len(cfg.ControlChannels) == 0 {
+		return nil, errors.New("synthetic calls need a control channel to grant them")
+	}
+
+	d := &SyntheticDevice{
+		cfg:        cfg,
+		recordings: make(map[string]*synth.Recording),
+	}
+	for _, c := range cfg.Calls {
+		if _, ok := smartnet.ChannelCommand(c.Frequency); !ok {
+			return nil, fmt.Errorf("synthetic call on %d isn't on a SmartNet channel", c.Frequency)
+		}
+		if !hasControlChannel(cfg, controlFrequency(cfg, c)) {
+			return nil, fmt.Errorf("no synthetic control channel on %d", controlFrequency(cfg, c))
+		}
+		if c.WAVFile == "" || d.recordings[c.WAVFile] != nil {
+			continue
+		}
+		rec, err := synth.LoadWAV(c.WAVFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s: %w", c.WAVFile, err)
+		}
+		d.recordings[c.WAVFile] = rec
+	}
+	return d, nil
+}
+
+func (d *SyntheticDevice) MaxSampleRate() int {
+	return maxSampleRate
+}
+
+func (d *SyntheticDevice) Info() device.Info {
+	return device.Info{Name: "synthetic"}
+}
+
+func (d *SyntheticDevice) Stop() error {
+	return nil
+}
+
+// Start generates samples until ctx is done, in real time unless the config says Fast.  If generating falls
+// behind, segments are delivered as fast as they can be made.  Calls are timed by the samples generated, not the
+// clock, so they keep in step with the control channels granting them.
+func (d *SyntheticDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
+	gen := synth.NewGenerator(sampleRate, centerFreq, synth.WithNoise(d.cfg.Noise), synth.WithSeed(d.cfg.Seed))
+
+	controlChannels := make(map[int]*synth.SmartnetControlChannel)
+	for _, c := range d.cfg.ControlChannels {
+		cc := synth.NewSmartnetControlChannel(c.Frequency, amplitude(c.Amplitude))
+		controlChannels[c.Frequency] = cc
+		gen.Add(cc)
+	}
+
+	calls := make([]*scriptedCall, 0, len(d.cfg.Calls))
+	for _, c := range d.cfg.Calls {
+		calls = append(calls, d.newScriptedCall(c, controlChannels[controlFrequency(d.cfg, c)]))
+	}
+
+	period := time.Duration(float64(time.Second) * segmentLength / float64(sampleRate))
+	tick := time.NewTicker(period)
+	defer tick.Stop()
+	var samples int64
+	for {
+		elapsed := time.Duration(float64(samples) * float64(time.Second) / float64(sampleRate))
+		for _, c := range calls {
+			if err := c.update(gen, elapsed); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case complexSamples <- gen.Segment(segmentLength):
+		}
+		samples += segmentLength
+
+		if d.cfg.Fast {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+	}
+}
+
+// scriptedCall is where a configured call has got to.  It grants the call and keys up its carrier for its
+// duration, then again every interval if it repeats.
+type scriptedCall struct {
+	cfg      config.SyntheticCall
+	cc       *synth.SmartnetControlChannel
+	audio    synth.Audio
+	duration time.Duration
+
+	// carrier is set while the call is up.
+	carrier *synth.FMCarrier
+	// next is when the call next starts, end when it ends, and grant when it's next granted, since the device
+	// started.
+	next  time.Duration
+	end   time.Duration
+	grant time.Duration
+	done  bool
+}
+
+func (d *SyntheticDevice) newScriptedCall(c config.SyntheticCall, cc *synth.SmartnetControlChannel) *scriptedCall {
+	var audio synth.Audio = synth.Tone{Frequency: defaultToneFreq, Level: 1}
+	if c.ToneFreq != 0 {
+		audio = synth.Tone{Frequency: c.ToneFreq, Level: 1}
+	}
+	if c.WAVFile != "" {
+		audio = d.recordings[c.WAVFile]
+	}
+	duration := c.Duration
+	if duration == 0 {
+		duration = defaultDuration
+	}
+	return &scriptedCall{
+		cfg:      c,
+		cc:       cc,
+		audio:    audio,
+		duration: duration,
+		next:     c.Start,
+	}
+}
+
+// update starts, grants and ends the call as it falls due at elapsed.
+func (c *scriptedCall) update(gen *synth.Generator, elapsed time.Duration) error {
+	if c.carrier == nil {
+		if c.done || elapsed < c.next {
+			return nil
+		}
+		c.carrier = synth.NewFMCarrier(c.cfg.Frequency, amplitude(c.cfg.Amplitude), c.audio)
+		gen.Add(c.carrier)
+		c.end = elapsed + c.duration
+		c.grant = elapsed
+	}
+
+	if elapsed >= c.end {
+		gen.Remove(c.carrier)
+		c.carrier = nil
+		c.next += c.cfg.Interval
+		c.done = c.cfg.Interval == 0
+		return nil
+	}
+	if elapsed >= c.grant {
+		if err := c.cc.Grant(c.cfg.SourceID, c.cfg.TalkGroupID, c.cfg.Frequency); err != nil {
+			return err
+		}
+		c.grant += grantInterval
+	}
+	return nil
+}
+
+// controlFrequency is the control channel that grants c.
+func controlFrequency(cfg config.Synthetic, c config.SyntheticCall) int {
+	if c.ControlFrequency == 0 {
+		return cfg.ControlChannels[0].Frequency
+	}
+	return c.ControlFrequency
+}
+
+func hasControlChannel(cfg config.Synthetic, freq int) bool {
+	for _, c := range cfg.ControlChannels {
+		if c.Frequency == freq {
+			return true
+		}
+	}
+	return false
+}
+
+func amplitude(a float64) float64 {
+	if a == 0 {
+		return defaultAmplitude
+	}
+	return a
+}
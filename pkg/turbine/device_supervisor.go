@@ -0,0 +1,226 @@
+package turbine
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultDeviceStallTimeout = 5 * time.Second
+	defaultDeviceMinBackoff   = time.Second
+	defaultDeviceMaxBackoff   = 30 * time.Second
+	// deviceStopTimeout is how long a stalled device is given to return from Start once it's stopped.
+	deviceStopTimeout = 5 * time.Second
+)
+
+// deviceSupervisor runs the device, and closes and reopens it when it fails or stops delivering samples.  The
+// samples channel stays open throughout, so channels, outputs and trunking carry on as if the device had gone
+// quiet.
+type deviceSupervisor struct {
+	device       device.Device
+	disabled     bool
+	stallTimeout time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	stats        *deviceStats
+	metrics      *turbineMetrics
+	logger       zerolog.Logger
+
+	lock sync.Mutex
+	// down is set from when the device fails until it delivers samples again.
+	down       bool
+	downSince  time.Time
+	outages    uint64
+	lastOutage time.Time
+	lastError  string
+}
+
+func newDeviceSupervisor(cfg config.DeviceSupervisor, d device.Device, stats *deviceStats, metrics *turbineMetrics,
+	logger zerolog.Logger) *deviceSupervisor {
+	s := &deviceSupervisor{
+		device:       d,
+		disabled:     cfg.Disabled,
+		stallTimeout: cfg.StallTimeout,
+		minBackoff:   cfg.MinBackoff,
+		maxBackoff:   cfg.MaxBackoff,
+		stats:        stats,
+		metrics:      metrics,
+		logger:       logger,
+	}
+	if s.stallTimeout == 0 {
+		s.stallTimeout = defaultDeviceStallTimeout
+	}
+	if s.minBackoff == 0 {
+		s.minBackoff = defaultDeviceMinBackoff
+	}
+	if s.maxBackoff == 0 {
+		s.maxBackoff = defaultDeviceMaxBackoff
+	}
+	if s.maxBackoff < s.minBackoff {
+		s.maxBackoff = s.minBackoff
+	}
+	// Devices that reconnect by themselves go quiet while they do, which isn't a reason to reopen them.
+	if r, ok := d.(device.Reconnector); ok && r.Reconnects() {
+		s.stallTimeout = 0
+	}
+	return s
+}
+
+// run starts the device and keeps it running until ctx is done or it runs out of samples.  A device that fails
+// before it's delivered any samples is taken to be misconfigured, and its error is returned.
+func (s *deviceSupervisor) run(ctx context.Context, centerFreq, sampleRate int, samples chan *types.SegmentComplex64) error {
+	if s.disabled {
+		return s.device.Start(ctx, centerFreq, sampleRate, samples)
+	}
+
+	backoff := s.minBackoff
+	for {
+		delivered, cause, err := s.runOnce(ctx, centerFreq, sampleRate, samples)
+		if cause == "" {
+			return err
+		}
+		if cause == "error" && atomic.LoadUint64(&s.stats.segments) == 0 {
+			return err
+		}
+		if delivered {
+			backoff = s.minBackoff
+		}
+		s.outage(cause, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// runOnce starts the device and watches it until it fails or stalls, which is given as the cause of the outage.
+// It returns an empty cause when the device shouldn't be reopened.  delivered is whether the device delivered
+// any samples.
+func (s *deviceSupervisor) runOnce(ctx context.Context, centerFreq, sampleRate int,
+	samples chan *types.SegmentComplex64) (delivered bool, cause string, err error) {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- s.device.Start(runCtx, centerFreq, sampleRate, samples)
+	}()
+
+	interval := time.Second
+	if s.stallTimeout > 0 && s.stallTimeout/4 < interval {
+		interval = s.stallTimeout / 4
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	segments := atomic.LoadUint64(&s.stats.segments)
+	progress := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			if done != nil {
+				<-done
+			}
+			return delivered, "", ctx.Err()
+		case err := <-done:
+			// Some devices return from Start once they're streaming, and deliver samples in the background.
+			if err == nil {
+				done = nil
+				continue
+			}
+			if ctx.Err() != nil || errors.Is(err, device.ErrEndOfSamples) {
+				return delivered, "", err
+			}
+			if stopErr := s.device.Stop(); stopErr != nil {
+				s.logger.Debug().Err(stopErr).Msg("failed to stop device after it failed")
+			}
+			return delivered, "error", err
+		case now := <-ticker.C:
+			if n := atomic.LoadUint64(&s.stats.segments); n != segments {
+				segments, progress = n, now
+				delivered = true
+				s.recovered()
+				continue
+			}
+			if s.stallTimeout == 0 || now.Sub(progress) < s.stallTimeout {
+				continue
+			}
+			cancel()
+			if stopErr := s.device.Stop(); stopErr != nil {
+				s.logger.Debug().Err(stopErr).Msg("failed to stop stalled device")
+			}
+			if done != nil {
+				select {
+				case <-done:
+				case <-time.After(deviceStopTimeout):
+					s.logger.Warn().Msg("stalled device didn't stop, reopening it anyway")
+				}
+			}
+			return delivered, "stall", errors.New("no samples from the device")
+		}
+	}
+}
+
+// outage records that the device failed or stalled, and is to be reopened after backoff.
+func (s *deviceSupervisor) outage(cause string, err error, backoff time.Duration) {
+	now := time.Now()
+	s.lock.Lock()
+	if !s.down {
+		s.down = true
+		s.downSince = now
+	}
+	s.outages++
+	s.lastOutage = now
+	s.lastError = err.Error()
+	s.lock.Unlock()
+
+	s.logger.Warn().
+		Err(err).
+		Str("cause", cause).
+		Dur("backoff", backoff).
+		Msg("device outage, reopening it")
+	s.metrics.deviceUp.Set(0)
+	s.metrics.deviceOutages.With(cause).Inc()
+}
+
+// recovered records that the device is delivering samples.
+func (s *deviceSupervisor) recovered() {
+	s.lock.Lock()
+	wasDown, since := s.down, s.downSince
+	s.down = false
+	s.lock.Unlock()
+
+	s.metrics.deviceUp.Set(1)
+	if !wasDown {
+		return
+	}
+	outage := time.Since(since)
+	s.metrics.deviceOutageTime.Add(outage.Seconds())
+	s.logger.Info().Dur("outage", outage).Msg("device recovered")
+}
+
+// status fills in a device status's outages.
+func (s *deviceSupervisor) status(ret *DeviceStatus) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ret.Outages = s.outages
+	ret.Reconnecting = s.down
+	ret.LastError = s.lastError
+	if !s.lastOutage.IsZero() {
+		lastOutage := s.lastOutage
+		ret.LastOutage = &lastOutage
+	}
+}
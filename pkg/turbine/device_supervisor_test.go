@@ -0,0 +1,215 @@
+package turbine
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/metrics"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/file"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"github.com/rs/zerolog"
+)
+
+// flakyDevice delivers a few segments then fails, then stalls, then delivers segments until stopped.
+type flakyDevice struct {
+	lock   sync.Mutex
+	starts int
+	stops  int
+}
+
+func (d *flakyDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
+	d.lock.Lock()
+	d.starts++
+	start := d.starts
+	d.lock.Unlock()
+
+	switch start {
+	case 1:
+		for i := 0; i < 3; i++ {
+			complexSamples <- &types.SegmentComplex64{Data: make([]complex64, 16)}
+		}
+		return errors.New("usb error")
+	case 2:
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			select {
+			case complexSamples <- &types.SegmentComplex64{Data: make([]complex64, 16)}:
+			default:
+			}
+		}
+	}
+}
+
+func (d *flakyDevice) Stop() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.stops++
+	return nil
+}
+
+func (d *flakyDevice) MaxSampleRate() int { return 20e6 }
+
+func TestDeviceSupervisor(t *testing.T) {
+	d := &flakyDevice{}
+	stats := &deviceStats{}
+	m := newTurbineMetrics(metrics.NewRegistry())
+	s := newDeviceSupervisor(config.DeviceSupervisor{
+		StallTimeout: 100 * time.Millisecond,
+		MinBackoff:   10 * time.Millisecond,
+		MaxBackoff:   20 * time.Millisecond,
+	}, d, stats, m, zerolog.Nop())
+
+	samples := make(chan *types.SegmentComplex64, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-samples:
+				atomic.AddUint64(&stats.segments, 1)
+			}
+		}
+	}()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.run(ctx, 852000000, 1000000, samples)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var status DeviceStatus
+		s.status(&status)
+		d.lock.Lock()
+		starts := d.starts
+		d.lock.Unlock()
+		if starts >= 3 && !status.Reconnecting {
+			if status.Outages != 2 || status.LastOutage == nil || status.LastError == "" {
+				t.Fatalf("unexpected status %+v", status)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("device didn't recover: %d starts, status %+v", starts, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := m.deviceOutages.With("error").Value(); n != 1 {
+		t.Errorf("%.0f error outages", n)
+	}
+	if n := m.deviceOutages.With("stall").Value(); n != 1 {
+		t.Errorf("%.0f stall outages", n)
+	}
+	if m.deviceUp.Value() != 1 || m.deviceOutageTime.Value() == 0 {
+		t.Errorf("device up %.0f after %.3fs outage", m.deviceUp.Value(), m.deviceOutageTime.Value())
+	}
+	d.lock.Lock()
+	stops := d.stops
+	d.lock.Unlock()
+	if stops != 2 {
+		t.Errorf("device stopped %d times", stops)
+	}
+
+	cancel()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("supervisor returned %v", err)
+	}
+}
+
+// TestDeviceSupervisorReplay plays a recording through the supervisor, closing it part way through, and checks
+// that it's reopened and played to the end without losing or repeating samples.
+func TestDeviceSupervisorReplay(t *testing.T) {
+	const samples = 1000000
+	path := filepath.Join(t.TempDir(), "recording.cs8")
+	data := make([]byte, samples*iq.FormatCS8.SampleSize())
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := file.NewFileDevice(path, file.Options{SampleRate: 1000000, CenterFreq: 852000000, Fast: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats := &deviceStats{}
+	m := newTurbineMetrics(metrics.NewRegistry())
+	s := newDeviceSupervisor(config.DeviceSupervisor{MinBackoff: time.Millisecond}, d, stats, m, zerolog.Nop())
+
+	played := make(chan *types.SegmentComplex64)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.run(context.Background(), 852000000, 1000000, played)
+	}()
+
+	var received []complex64
+	for {
+		select {
+		case seg := <-played:
+			if len(received) == 0 {
+				// Closing the recording under the device fails its next read.
+				d.Stop()
+			}
+			received = append(received, seg.Data...)
+			atomic.AddUint64(&stats.segments, 1)
+			continue
+		case err = <-errc:
+		}
+		break
+	}
+
+	if !errors.Is(err, device.ErrEndOfSamples) {
+		t.Fatalf("supervisor returned %v", err)
+	}
+	if len(received) != samples {
+		t.Fatalf("played %d samples, expected %d", len(received), samples)
+	}
+	expected := make([]complex64, samples)
+	iq.FormatCS8.Decode(data, expected)
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("sample %d is %v, expected %v", i, received[i], expected[i])
+		}
+	}
+	if n := m.deviceOutages.With("error").Value(); n != 1 {
+		t.Errorf("%.0f error outages", n)
+	}
+}
+
+func TestDeviceSupervisorFailsFirstStart(t *testing.T) {
+	s := newDeviceSupervisor(config.DeviceSupervisor{}, &failingDevice{}, &deviceStats{},
+		newTurbineMetrics(metrics.NewRegistry()), zerolog.Nop())
+	if err := s.run(context.Background(), 852000000, 1000000, make(chan *types.SegmentComplex64)); err == nil {
+		t.Error("device that never started wasn't reported")
+	}
+}
+
+// failingDevice can't be opened.
+type failingDevice struct{}
+
+func (d *failingDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
+	return errors.New("no device found")
+}
+
+func (d *failingDevice) Stop() error        { return nil }
+func (d *failingDevice) MaxSampleRate() int { return 20e6 }
@@ -0,0 +1,91 @@
+package turbine
+
+import (
+	"context"
+	"time"
+
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/turbine/call"
+)
+
+const callEventBufferLength = 256
+
+// EventSink handles call lifecycle events.
+type EventSink interface {
+	// Start receives a context and should run in a loop, terminating upon ctx closing or on any errors.
+	Start(ctx context.Context) error
+	// Receive returns a channel that receives call events.
+	Receive() chan<- *call.Event
+}
+
+// emitCallEvent queues a call event for delivery to the event sinks.  It never blocks.
+func (t *Turbine) emitCallEvent(ev *call.Event) {
+	select {
+	case t.callEventChan <- ev:
+	default:
+		t.logger.Warn().
+			Str("event", string(ev.Type)).
+			Str("call_id", ev.Call.ID).
+			Msg("call event queue full, dropping event")
+	}
+}
+
+func (t *Turbine) dispatchCallEvents() error {
+	emergencies := make(map[string]struct{})
+	for {
+		select {
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		case ev := <-t.callEventChan:
+			logEvent := t.logger.Debug()
+			if ev.Type != call.EventTypeUpdate {
+				logEvent = t.logger.Info()
+			}
+			logEvent.
+				Str("event", string(ev.Type)).
+				Str("call_id", ev.Call.ID).
+				Int("system_id", ev.Call.SystemID).
+				Int("tgid", ev.Call.TalkGroupID).
+				Str("alpha_tag", ev.Call.AlphaTag()).
+				Str("category", ev.Call.Category()).
+				Str("frequency", op25.MHzToString(ev.Call.Frequency)).
+				Ints("sources", ev.Call.SourceIDs()).
+				Strs("source_aliases", ev.Call.SourceAliases()).
+				Float64("duration", ev.Call.Duration).
+				Bool("emergency", ev.Call.Emergency).
+				Bool("encrypted", ev.Call.Encrypted).
+				Float64("level_db", ev.Call.Signal.LevelDB).
+				Float64("rssi_db", ev.Call.Signal.RSSIDB).
+				Float64("snr_db", ev.Call.Signal.SNRDB).
+				Float64("frequency_error_hz", ev.Call.Signal.FrequencyErrorHz).
+				Msg("call event")
+
+			t.publishCallEvent(ev, emergencies)
+			t.channelRecorder.callEvent(ev)
+			t.snapshots.callEvent(ev)
+
+			t.metrics.observeCallEvent(ev)
+			for _, sink := range t.opts.EventSinks {
+				select {
+				case sink.Receive() <- ev:
+					// We will not wait on blocked channels.
+				default:
+					t.metrics.eventSinkDrops.With(metricName(sink)).Inc()
+				}
+			}
+		}
+	}
+}
+
+func (t *Turbine) expireCalls() error {
+	ticker := time.NewTicker(callExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		case now := <-ticker.C:
+			t.sm.ExpireCalls(now)
+		}
+	}
+}
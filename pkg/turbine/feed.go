@@ -0,0 +1,173 @@
+package turbine
+
+import (
+	"time"
+
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/op25/frame"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/feed"
+)
+
+const (
+	// decodeHealthInterval is how often control channels are checked for decode health changes.
+	decodeHealthInterval = time.Second
+	// decodeHealthTimeout is how long a control channel can go without a good packet and still be healthy.
+	decodeHealthTimeout = time.Second * 3
+	// minHealthyDecodeRatio is the fraction of a control channel's packets that must decode for it to be healthy.
+	minHealthyDecodeRatio = 0.5
+)
+
+// decodeHealthy is whether a control channel is decoding well enough to be relied on.
+func decodeHealthy(stats frame.Stats, now time.Time) bool {
+	return stats.InSync &&
+		now.Sub(stats.LastPacket) < decodeHealthTimeout &&
+		stats.DecodeRatio() >= minHealthyDecodeRatio
+}
+
+// DecodeHealth is the payload of a decode health feed event.
+type DecodeHealth struct {
+	Frequency int  `json:"frequency"`
+	Healthy   bool `json:"healthy"`
+	frame.Stats
+}
+
+// Feed returns the live event feed.
+func (t *Turbine) Feed() *feed.Bus {
+	return t.feed
+}
+
+func (t *Turbine) publishGrant(update op25.DataPacket) {
+	if t.feed.Subscribers() == 0 {
+		return
+	}
+	t.feed.Publish(&feed.Event{
+		Type:        feed.EventTypeGrant,
+		SystemID:    update.SystemID,
+		TalkGroupID: int(update.DestTGID),
+		Data: feed.Grant{
+			SourceID:    int(update.SrcID),
+			SourceAlias: t.sm.VMForSystemID(update.SystemID).UnitAlias(int(update.SrcID)),
+			Frequency:   update.TargetFreq,
+			Emergency:   update.Emergency,
+			Encrypted:   update.Encrypted,
+		},
+	})
+}
+
+// handleControlMessage publishes the control channel messages that don't become data packets.  Grants are
+// published from the data packet stream instead, so they carry what Turbine made of them.
+func (t *Turbine) handleControlMessage(msg op25.ControlMessage) {
+	var eventType feed.EventType
+	switch msg.Type {
+	case op25.ControlMessageTypeAffiliation:
+		eventType = feed.EventTypeAffiliation
+	case op25.ControlMessageTypeSystemID:
+		eventType = feed.EventTypeSystemID
+	case op25.ControlMessageTypeCellsite:
+		eventType = feed.EventTypeCellsite
+	default:
+		return
+	}
+
+	t.feed.Publish(&feed.Event{
+		Type:        eventType,
+		Timestamp:   msg.Timestamp,
+		SystemID:    msg.SystemID,
+		TalkGroupID: msg.TalkGroupID,
+		Data:        msg,
+	})
+}
+
+func (t *Turbine) publishControlChannel(systemID, freq int, action feed.ControlChannelAction) {
+	t.feed.Publish(&feed.Event{
+		Type:     feed.EventTypeControlChannel,
+		SystemID: systemID,
+		Data: feed.ControlChannel{
+			Frequency: freq,
+			Action:    action,
+		},
+	})
+}
+
+// publishCallEvent publishes a call event, along with an emergency event the first time a call is flagged as
+// an emergency.  emergencies tracks the calls already flagged, and is owned by the caller.
+func (t *Turbine) publishCallEvent(ev *call.Event, emergencies map[string]struct{}) {
+	var eventType feed.EventType
+	switch ev.Type {
+	case call.EventTypeStart:
+		eventType = feed.EventTypeCallStart
+	case call.EventTypeUpdate:
+		eventType = feed.EventTypeCallUpdate
+	default:
+		eventType = feed.EventTypeCallEnd
+	}
+
+	t.feed.Publish(&feed.Event{
+		Type:        eventType,
+		Timestamp:   ev.Timestamp,
+		SystemID:    ev.Call.SystemID,
+		TalkGroupID: ev.Call.TalkGroupID,
+		Data:        ev.Call,
+	})
+
+	_, flagged := emergencies[ev.Call.ID]
+	if ev.Call.Emergency && !flagged && ev.Type != call.EventTypeEnd {
+		emergencies[ev.Call.ID] = struct{}{}
+		t.feed.Publish(&feed.Event{
+			Type:        feed.EventTypeEmergency,
+			Timestamp:   ev.Timestamp,
+			SystemID:    ev.Call.SystemID,
+			TalkGroupID: ev.Call.TalkGroupID,
+			Data:        ev.Call,
+		})
+	}
+	if ev.Type == call.EventTypeEnd {
+		delete(emergencies, ev.Call.ID)
+	}
+}
+
+// watchDecodeHealth publishes an event whenever a control channel starts or stops decoding.
+func (t *Turbine) watchDecodeHealth() error {
+	healthy := make(map[int]bool)
+
+	ticker := time.NewTicker(decodeHealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		case <-ticker.C:
+			seen := make(map[int]struct{})
+			for _, ch := range t.ControlChannels() {
+				seen[ch.Frequency] = struct{}{}
+				isHealthy := ch.Healthy
+				if was, ok := healthy[ch.Frequency]; ok && was == isHealthy {
+					continue
+				}
+				healthy[ch.Frequency] = isHealthy
+
+				t.logger.Debug().
+					Int("system_id", ch.SystemID).
+					Str("frequency", op25.MHzToString(ch.Frequency)).
+					Bool("healthy", isHealthy).
+					Msg("control channel decode health changed")
+
+				t.feed.Publish(&feed.Event{
+					Type:     feed.EventTypeDecodeHealth,
+					SystemID: ch.SystemID,
+					Data: DecodeHealth{
+						Frequency: ch.Frequency,
+						Healthy:   isHealthy,
+						Stats:     ch.Decode,
+					},
+				})
+			}
+			for freq := range healthy {
+				if _, ok := seen[freq]; !ok {
+					delete(healthy, freq)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package feed
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventTypeGrant          EventType = "grant"
+	EventTypeAffiliation    EventType = "affiliation"
+	EventTypeSystemID       EventType = "system_id"
+	EventTypeCellsite       EventType = "cellsite"
+	EventTypeControlChannel EventType = "control_channel"
+	EventTypeCallStart      EventType = "call_start"
+	EventTypeCallUpdate     EventType = "call_update"
+	EventTypeCallEnd        EventType = "call_end"
+	EventTypeEmergency      EventType = "emergency"
+	EventTypeDecodeHealth   EventType = "decode_health"
+)
+
+// subscriptionBufferLength is how many events a slow subscriber can fall behind before events are dropped.
+const subscriptionBufferLength = 256
+
+// Event is a single item on the feed.  Data holds a type-specific payload.
+type Event struct {
+	Type        EventType   `json:"type"`
+	Timestamp   time.Time   `json:"timestamp"`
+	SystemID    int         `json:"system_id"`
+	TalkGroupID int         `json:"tgid,omitempty"`
+	Data        interface{} `json:"data"`
+}
+
+// Grant is the payload of a grant event.
+type Grant struct {
+	SourceID    int    `json:"source_id,omitempty"`
+	SourceAlias string `json:"source_alias,omitempty"`
+	Frequency   int    `json:"frequency"`
+	Emergency   bool   `json:"emergency"`
+	Encrypted   bool   `json:"encrypted"`
+}
+
+type ControlChannelAction string
+
+const (
+	ControlChannelAdded   ControlChannelAction = "added"
+	ControlChannelRemoved ControlChannelAction = "removed"
+	// ControlChannelRetired is a control channel set aside for staying unhealthy while another of its system's
+	// is healthy.  It's added back if the system loses its healthy ones.
+	ControlChannelRetired ControlChannelAction = "retired"
+)
+
+// ControlChannel is the payload of a control channel event.
+type ControlChannel struct {
+	Frequency int                  `json:"frequency"`
+	Action    ControlChannelAction `json:"action"`
+}
+
+// Filter selects which events a subscriber receives.  Empty fields match everything.
+type Filter struct {
+	Systems    map[int]struct{}
+	TalkGroups map[int]struct{}
+	Types      map[EventType]struct{}
+}
+
+// ParseFilter builds a filter from the system, tgid and type query parameters.  Each may be repeated or hold
+// a comma separated list.
+func ParseFilter(query url.Values) (Filter, error) {
+	var f Filter
+	var err error
+	if f.Systems, err = parseIntSet(query["system"]); err != nil {
+		return f, fmt.Errorf("bad system filter: %w", err)
+	}
+	if f.TalkGroups, err = parseIntSet(query["tgid"]); err != nil {
+		return f, fmt.Errorf("bad tgid filter: %w", err)
+	}
+	for _, v := range splitValues(query["type"]) {
+		if f.Types == nil {
+			f.Types = make(map[EventType]struct{})
+		}
+		f.Types[EventType(v)] = struct{}{}
+	}
+	return f, nil
+}
+
+func splitValues(values []string) []string {
+	var ret []string
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				ret = append(ret, part)
+			}
+		}
+	}
+	return ret
+}
+
+func parseIntSet(values []string) (map[int]struct{}, error) {
+	var ret map[int]struct{}
+	for _, v := range splitValues(values) {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		if ret == nil {
+			ret = make(map[int]struct{})
+		}
+		ret[id] = struct{}{}
+	}
+	return ret, nil
+}
+
+// Match returns true if the filter selects the event.  Events that aren't about a talkgroup are not
+// filtered by talkgroup.
+func (f Filter) Match(ev *Event) bool {
+	if _, ok := f.Systems[ev.SystemID]; len(f.Systems) > 0 && !ok {
+		return false
+	}
+	if _, ok := f.Types[ev.Type]; len(f.Types) > 0 && !ok {
+		return false
+	}
+	if _, ok := f.TalkGroups[ev.TalkGroupID]; len(f.TalkGroups) > 0 && ev.TalkGroupID != 0 && !ok {
+		return false
+	}
+	return true
+}
+
+// Subscription receives the events on a bus that match its filter.
+type Subscription struct {
+	ch      chan *Event
+	filter  Filter
+	dropped uint64
+}
+
+// Events returns the channel events are delivered on.  It is never closed.
+func (s *Subscription) Events() <-chan *Event {
+	return s.ch
+}
+
+// Dropped returns how many events were dropped because the subscriber fell behind.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Bus fans events out to subscribers.  Publishing never blocks: subscribers that fall behind miss events.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+func (b *Bus) Subscribe(filter Filter) *Subscription {
+	sub := &Subscription{
+		ch:     make(chan *Event, subscriptionBufferLength),
+		filter: filter,
+	}
+	b.mu.Lock()
+	b.subs[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	delete(b.subs, sub)
+	b.mu.Unlock()
+}
+
+// Publish sends an event to every matching subscriber.  Events are shared and must not be modified.
+func (b *Bus) Publish(ev *Event) {
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subs {
+		if !sub.filter.Match(ev) {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			atomic.AddUint64(&sub.dropped, 1)
+		}
+	}
+}
+
+// Subscribers returns the number of subscribers, so publishers can skip building events nobody will see.
+func (b *Bus) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subs)
+}
@@ -0,0 +1,64 @@
+package feed
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/events?system=604&tgid=1200,1216&type=grant&type=call_start", nil)
+	f, err := ParseFilter(req.URL.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ev   Event
+		want bool
+	}{
+		{Event{Type: EventTypeGrant, SystemID: 604, TalkGroupID: 1200}, true},
+		{Event{Type: EventTypeCallStart, SystemID: 604, TalkGroupID: 1216}, true},
+		{Event{Type: EventTypeGrant, SystemID: 605, TalkGroupID: 1200}, false},
+		{Event{Type: EventTypeGrant, SystemID: 604, TalkGroupID: 1232}, false},
+		{Event{Type: EventTypeAffiliation, SystemID: 604, TalkGroupID: 1200}, false},
+	}
+	for _, tt := range tests {
+		if got := f.Match(&tt.ev); got != tt.want {
+			t.Errorf("%+v: got %v want %v", tt.ev, got, tt.want)
+		}
+	}
+}
+
+func TestSSE(t *testing.T) {
+	bus := NewBus()
+	srv := httptest.NewServer(bus.SSEHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "?type=grant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	bus.Publish(&Event{Type: EventTypeAffiliation, SystemID: 604})
+	bus.Publish(&Event{Type: EventTypeGrant, SystemID: 604, TalkGroupID: 1200, Data: Grant{SourceID: 34512}})
+
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "event: grant\n" {
+		t.Fatalf("unexpected line %q", line)
+	}
+	line, err = reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(line, "data: ") || !strings.Contains(line, `"source_id":34512`) {
+		t.Fatalf("unexpected data %q", line)
+	}
+}
@@ -0,0 +1,103 @@
+package feed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/websocket"
+)
+
+// keepaliveInterval is how often an idle stream is sent something, so proxies don't time it out.
+const keepaliveInterval = time.Second * 15
+
+// SSEHandler streams events as Server-Sent Events.  The query string may filter events; see ParseFilter.
+func (b *Bus) SSEHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter, err := ParseFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		sub := b.Subscribe(filter)
+		defer b.Unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepalive := time.NewTicker(keepaliveInterval)
+		defer keepalive.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepalive.C:
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+			case ev := <-sub.Events():
+				encoded, err := json.Marshal(ev)
+				if err != nil {
+					log.Warn().Err(err).Str("event", string(ev.Type)).Msg("error marshaling feed event")
+					continue
+				}
+				if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, encoded); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	})
+}
+
+// WebSocketHandler streams events as JSON text messages over a WebSocket.  The query string may filter
+// events; see ParseFilter.
+func (b *Bus) WebSocketHandler() http.Handler {
+	return websocket.Handler(func(ws *websocket.Conn) {
+		defer ws.Close()
+
+		filter, err := ParseFilter(ws.Request().URL.Query())
+		if err != nil {
+			websocket.JSON.Send(ws, map[string]string{"error": err.Error()})
+			return
+		}
+
+		sub := b.Subscribe(filter)
+		defer b.Unsubscribe(sub)
+
+		// Nothing is expected from the client, but reading is the only way to notice it has gone away.
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			var discard []byte
+			for {
+				if err := websocket.Message.Receive(ws, &discard); err != nil {
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case <-closed:
+				return
+			case ev := <-sub.Events():
+				if err := websocket.JSON.Send(ws, ev); err != nil {
+					return
+				}
+			}
+		}
+	})
+}
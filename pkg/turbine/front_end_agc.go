@@ -0,0 +1,235 @@
+package turbine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultAGCMaxClipping = 0.001
+	defaultAGCHeadroom    = 6
+	defaultAGCInterval    = time.Second
+	// defaultAGCStep is the step of a gain stage that can be set to any gain.
+	defaultAGCStep = 2
+	// agcPeakFraction is the fraction of samples loud enough to count as the peaks kept below full scale.
+	agcPeakFraction = 0.001
+	// clipLevel is the sample magnitude counted as clipping, the largest an 8-bit ADC gives either way.
+	clipLevel = deviceFullScale - 1
+)
+
+// frontEndAGC steps the device's gains to keep its samples from clipping while leaving them as strong as they
+// can be.  Each interval it measures how many samples clip and how loud the loudest are, then lowers a gain a
+// step if too many clip, or raises one if the loudest would still have headroom after the step.  Gains are
+// raised in the order their stages are listed and lowered in reverse.
+type frontEndAGC struct {
+	ctrl        device.Controller
+	stages      []config.FrontEndAGCStage
+	maxClipping float64
+	headroom    float64
+	interval    time.Duration
+	metrics     *turbineMetrics
+	logger      zerolog.Logger
+
+	lock sync.Mutex
+	// levels counts samples by the magnitude of their larger component.
+	levels [deviceFullScale + 1]uint64
+	total  uint64
+	// settling is set after a change, so the samples measured while it took effect are ignored.
+	settling bool
+}
+
+// newFrontEndAGC returns nil if the AGC isn't enabled.
+func newFrontEndAGC(cfg config.FrontEndAGC, d device.Device, metrics *turbineMetrics, logger zerolog.Logger) (*frontEndAGC, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	ctrl, ok := d.(device.Controller)
+	if !ok {
+		return nil, errors.New("front end AGC needs a device with adjustable gain")
+	}
+	a := &frontEndAGC{
+		ctrl:        ctrl,
+		maxClipping: cfg.MaxClipping,
+		headroom:    cfg.Headroom,
+		interval:    cfg.Interval,
+		metrics:     metrics,
+		logger:      logger,
+	}
+	if a.maxClipping == 0 {
+		a.maxClipping = defaultAGCMaxClipping
+	}
+	if a.headroom == 0 {
+		a.headroom = defaultAGCHeadroom
+	}
+	if a.interval == 0 {
+		a.interval = defaultAGCInterval
+	}
+
+	deviceStages := ctrl.GainStages()
+	stages := cfg.Stages
+	if len(stages) == 0 {
+		for _, stage := range deviceStages {
+			stages = append(stages, config.FrontEndAGCStage{Name: stage.Name, Min: stage.Min})
+		}
+	}
+	for _, stage := range stages {
+		var deviceStage *device.GainStage
+		for i := range deviceStages {
+			if deviceStages[i].Name == stage.Name {
+				deviceStage = &deviceStages[i]
+			}
+		}
+		if deviceStage == nil {
+			return nil, fmt.Errorf("front end AGC: the device has no %s gain", stage.Name)
+		}
+		if stage.Max == 0 || stage.Max > deviceStage.Max {
+			stage.Max = deviceStage.Max
+		}
+		if stage.Min < deviceStage.Min {
+			stage.Min = deviceStage.Min
+		}
+		if stage.Step == 0 {
+			stage.Step = deviceStage.Step
+		}
+		if stage.Step == 0 {
+			stage.Step = defaultAGCStep
+		}
+		if stage.Min > stage.Max {
+			return nil, fmt.Errorf("front end AGC: %s gain's minimum is above its maximum", stage.Name)
+		}
+		a.stages = append(a.stages, stage)
+	}
+	return a, nil
+}
+
+// observe measures a segment of the device's samples.
+func (a *frontEndAGC) observe(seg *types.SegmentComplex64) {
+	if a == nil {
+		return
+	}
+	var levels [deviceFullScale + 1]uint64
+	for _, s := range seg.Data {
+		level := math.Max(math.Abs(float64(real(s))), math.Abs(float64(imag(s))))
+		if level > deviceFullScale {
+			level = deviceFullScale
+		}
+		levels[int(level)]++
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for i, n := range levels {
+		a.levels[i] += n
+	}
+	a.total += uint64(len(seg.Data))
+}
+
+// run adjusts the gain every interval until ctx is done.
+func (a *frontEndAGC) run(ctx context.Context) error {
+	a.logger.Info().
+		Interface("stages", a.stages).
+		Float64("max_clipping", a.maxClipping).
+		Float64("headroom_db", a.headroom).
+		Msg("starting front end AGC")
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			a.adjust()
+		}
+	}
+}
+
+// adjust changes a gain by a step if the samples measured since the last adjustment call for it.
+func (a *frontEndAGC) adjust() {
+	a.lock.Lock()
+	levels, total, settling := a.levels, a.total, a.settling
+	a.levels = [deviceFullScale + 1]uint64{}
+	a.total = 0
+	a.settling = false
+	a.lock.Unlock()
+	if total == 0 || settling {
+		return
+	}
+
+	var clipped uint64
+	for _, n := range levels[clipLevel:] {
+		clipped += n
+	}
+	clipping := float64(clipped) / float64(total)
+	// The peak is the level the loudest samples reach.
+	peak, loud := 0, uint64(0)
+	for peak = deviceFullScale; peak > 0; peak-- {
+		if loud += levels[peak]; float64(loud) >= agcPeakFraction*float64(total) {
+			break
+		}
+	}
+	peakDB := 20 * math.Log10(float64(peak+1)/deviceFullScale)
+	a.metrics.deviceClipping.Set(clipping)
+	a.metrics.devicePeak.Set(peakDB)
+
+	// A stage whose gain the device doesn't report is left alone, since there's nothing to step it from.
+	gains := a.ctrl.Settings().Gains
+	if clipping > a.maxClipping {
+		for i := len(a.stages) - 1; i >= 0; i-- {
+			stage := a.stages[i]
+			if gain, ok := gains[stage.Name]; ok && gain > stage.Min {
+				a.set(stage, gain, math.Max(gain-stage.Step, stage.Min), clipping, peakDB)
+				return
+			}
+		}
+		a.logger.Debug().Float64("clipping", clipping).Msg("front end AGC can't lower the gain any further")
+		return
+	}
+	for _, stage := range a.stages {
+		gain, ok := gains[stage.Name]
+		if !ok || gain >= stage.Max {
+			continue
+		}
+		next := math.Min(gain+stage.Step, stage.Max)
+		if peakDB+next-gain <= -a.headroom {
+			a.set(stage, gain, next, clipping, peakDB)
+		}
+		return
+	}
+}
+
+// set changes a stage's gain, and ignores the samples measured until the next adjustment while it takes
+// effect.
+func (a *frontEndAGC) set(stage config.FrontEndAGCStage, from, to, clipping, peakDB float64) {
+	if err := a.ctrl.Apply(device.Settings{Gains: map[string]float64{stage.Name: to}}); err != nil {
+		a.logger.Error().Err(err).Str("stage", stage.Name).Msg("front end AGC failed to change the gain")
+		return
+	}
+	a.lock.Lock()
+	a.settling = true
+	a.lock.Unlock()
+
+	to = a.ctrl.Settings().Gains[stage.Name]
+	direction := "up"
+	if to < from {
+		direction = "down"
+	}
+	a.logger.Info().
+		Str("stage", stage.Name).
+		Float64("from_db", from).
+		Float64("to_db", to).
+		Float64("clipping", clipping).
+		Float64("peak_dbfs", peakDB).
+		Msg("front end AGC changed gain")
+	a.metrics.deviceGainChanges.With(stage.Name, direction).Inc()
+	a.metrics.deviceGain.With(stage.Name).Set(to)
+}
@@ -0,0 +1,116 @@
+package turbine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/metrics"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/rs/zerolog"
+)
+
+// gainDevice is a device with HackRF-like gain stages, which never delivers samples.
+type gainDevice struct {
+	settings device.Settings
+}
+
+func (d *gainDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (d *gainDevice) Stop() error        { return nil }
+func (d *gainDevice) MaxSampleRate() int { return 20e6 }
+
+func (d *gainDevice) GainStages() []device.GainStage {
+	return []device.GainStage{{Name: "lna", Min: 0, Max: 40, Step: 8}, {Name: "vga", Min: 0, Max: 62, Step: 2}}
+}
+
+func (d *gainDevice) Settings() device.Settings {
+	return d.settings.With(device.Settings{})
+}
+
+func (d *gainDevice) Apply(changes device.Settings) error {
+	if err := device.Check(d.GainStages(), d.settings, changes); err != nil {
+		return err
+	}
+	d.settings = d.settings.With(changes)
+	return nil
+}
+
+// toneSegment is a segment of a tone whose components peak at level.
+func toneSegment(level float32) *types.SegmentComplex64 {
+	seg := &types.SegmentComplex64{Data: make([]complex64, 10000)}
+	for i := range seg.Data {
+		switch i % 4 {
+		case 0:
+			seg.Data[i] = complex(level, 0)
+		case 1:
+			seg.Data[i] = complex(0, level)
+		case 2:
+			seg.Data[i] = complex(-level, 0)
+		default:
+			seg.Data[i] = complex(0, -level)
+		}
+	}
+	return seg
+}
+
+func TestFrontEndAGC(t *testing.T) {
+	d := &gainDevice{settings: device.Settings{Gains: map[string]float64{"lna": 32, "vga": 20}}}
+	a, err := newFrontEndAGC(config.FrontEndAGC{
+		Enabled: true,
+		Stages:  []config.FrontEndAGCStage{{Name: "lna", Max: 40}, {Name: "vga", Min: 10, Max: 30}},
+	}, d, newTurbineMetrics(metrics.NewRegistry()), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		level    float32
+		lna, vga float64
+	}{
+		// Clipping lowers the last stage's gain first, then the change is left to settle.
+		{127, 32, 18},
+		{127, 32, 18},
+		{127, 32, 16},
+		// Peaks at -8 dBFS have headroom, but not enough to raise the LNA a whole step.
+		{50, 32, 16},
+		// Quiet samples raise the first stage's gain first, up to its limit, then the next stage's.
+		{10, 40, 16},
+		{10, 40, 16},
+		{10, 40, 18},
+	}
+	for i, step := range steps {
+		a.observe(toneSegment(step.level))
+		a.adjust()
+		if gains := d.settings.Gains; gains["lna"] != step.lna || gains["vga"] != step.vga {
+			t.Fatalf("step %d: gains are %v, expected lna %.0f, vga %.0f", i, gains, step.lna, step.vga)
+		}
+	}
+
+	// A stage the device doesn't report, as the HackRF's VGA until it's set, isn't raised from nothing.
+	d = &gainDevice{settings: device.Settings{Gains: map[string]float64{"lna": 40}}}
+	a, err = newFrontEndAGC(config.FrontEndAGC{
+		Enabled: true,
+		Stages:  []config.FrontEndAGCStage{{Name: "lna", Max: 40}, {Name: "vga", Min: 10, Max: 30}},
+	}, d, newTurbineMetrics(metrics.NewRegistry()), zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, level := range []float32{10, 127} {
+		a.observe(toneSegment(level))
+		a.adjust()
+		a.adjust()
+	}
+	if gains := d.settings.Gains; len(gains) != 1 || gains["lna"] != 32 {
+		t.Errorf("gains are %v, expected only lna lowered to 32", gains)
+	}
+
+	if _, err := newFrontEndAGC(config.FrontEndAGC{Enabled: true, Stages: []config.FrontEndAGCStage{{Name: "tuner"}}},
+		d, newTurbineMetrics(metrics.NewRegistry()), zerolog.Nop()); err == nil {
+		t.Error("AGC of a gain stage the device doesn't have")
+	}
+}
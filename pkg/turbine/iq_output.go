@@ -0,0 +1,247 @@
+package turbine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"github.com/norasector/turbine/pkg/turbine/device/iqstream"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// iqOutputQueueLength is how many segments an IQ output holds before it drops them, so it never holds up
+	// the receiver.
+	iqOutputQueueLength = 16
+	// iqClientQueueLength is how many frames a client can be behind before it's disconnected.
+	iqClientQueueLength = 32
+	// iqClientWriteTimeout is how long a write to a client can take before it's disconnected.
+	iqClientWriteTimeout = 5 * time.Second
+	// iqOutputWarningInterval limits how often dropped segments are logged.
+	iqOutputWarningInterval = time.Second
+)
+
+// iqOutput re-sends the device's samples, or a sub-band of them, to TCP clients in the frames the iq_stream
+// device takes.  A client that falls behind is disconnected rather than holding up the others.
+type iqOutput struct {
+	port       int
+	format     iq.Format
+	centerFreq int
+	sampleRate int
+	// tuner picks out the sub-band, if there is one.
+	tuner  *channelTuner
+	logger zerolog.Logger
+
+	segments chan iqOutputSegment
+	// dropped counts the segments dropped since the last one queued.  It and lastWarning are only used by the
+	// goroutine offering segments.
+	dropped     uint32
+	lastWarning time.Time
+	// sequence numbers the frames sent, counting the segments dropped.  Only used by run.
+	sequence uint32
+
+	lock    sync.Mutex
+	clients map[*iqClient]struct{}
+}
+
+// iqOutputSegment is a segment queued to be sent, and how many were dropped before it.
+type iqOutputSegment struct {
+	seg     *types.SegmentComplex64
+	dropped uint32
+}
+
+type iqClient struct {
+	conn   net.Conn
+	frames chan []byte
+}
+
+func newIQOutput(cfg config.IQOutput, centerFreq, sampleRate int, logger zerolog.Logger) (*iqOutput, error) {
+	if cfg.Port == 0 {
+		return nil, errors.New("IQ output needs a port")
+	}
+	format := iq.Format(cfg.Format)
+	if format == "" {
+		format = iq.FormatCS8
+	}
+	if format.SampleSize() == 0 {
+		return nil, errors.New("unknown sample format " + string(format))
+	}
+
+	o := &iqOutput{
+		port:       cfg.Port,
+		format:     format,
+		centerFreq: centerFreq,
+		sampleRate: sampleRate,
+		logger:     logger.With().Int("iq_output_port", cfg.Port).Logger(),
+		segments:   make(chan iqOutputSegment, iqOutputQueueLength),
+		clients:    make(map[*iqClient]struct{}),
+	}
+	if cfg.Decimation > 1 {
+		if sampleRate%cfg.Decimation != 0 {
+			return nil, fmt.Errorf("IQ output decimation %d doesn't divide the sample rate %d", cfg.Decimation, sampleRate)
+		}
+		subCenter := cfg.CenterFreq
+		if subCenter == 0 {
+			subCenter = centerFreq
+		}
+		if subCenter < centerFreq-sampleRate/2 || subCenter > centerFreq+sampleRate/2 {
+			return nil, fmt.Errorf("IQ output sub-band at %s is outside the device's samples", op25.MHzToString(subCenter))
+		}
+		// The sub-band is passed on as the device received it, so whoever takes it can correct it themselves.
+		o.tuner = newChannelTuner(subCenter, centerFreq, sampleRate, cfg.Decimation, 0)
+		o.centerFreq = subCenter
+		o.sampleRate = sampleRate / cfg.Decimation
+	}
+	return o, nil
+}
+
+// offer queues a segment to be sent.  It never blocks.
+func (o *iqOutput) offer(seg *types.SegmentComplex64) {
+	select {
+	case o.segments <- iqOutputSegment{seg: seg, dropped: o.dropped}:
+		o.dropped = 0
+		return
+	default:
+	}
+	o.dropped++
+	if time.Since(o.lastWarning) < iqOutputWarningInterval {
+		return
+	}
+	o.lastWarning = time.Now()
+	o.logger.Warn().Msg("IQ output is behind, dropping segments")
+}
+
+// run accepts clients and sends them every segment offered until ctx is done.
+func (o *iqOutput) run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", o.port))
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	go o.accept(ctx, listener)
+
+	o.logger.Info().
+		Str("center_freq", op25.MHzToString(o.centerFreq)).
+		Str("sample_rate", op25.MHzToString(o.sampleRate)).
+		Str("format", string(o.format)).
+		Msg("serving IQ")
+
+	for {
+		select {
+		case <-ctx.Done():
+			o.disconnectAll()
+			return ctx.Err()
+		case queued := <-o.segments:
+			o.broadcast(o.frame(queued))
+		}
+	}
+}
+
+// frame makes the frame a queued segment is sent in.  The segments dropped before it are skipped in the
+// sequence, so clients can tell there's a gap, and the sub-band's filter starts afresh after it rather than
+// running on from samples that aren't there.
+func (o *iqOutput) frame(queued iqOutputSegment) []byte {
+	o.sequence += queued.dropped
+	// The segment is shared with the receiver, so it's only read from.
+	data := queued.seg.Data
+	if o.tuner != nil {
+		if queued.dropped > 0 {
+			o.tuner.reset()
+		}
+		data = o.tuner.bfo.Work(o.tuner.bandpass.Work(data))
+	}
+	samples := o.format.Encode(nil, data)
+	header := iqstream.Header{
+		Sequence:   o.sequence,
+		SampleRate: uint32(o.sampleRate),
+		CenterFreq: uint64(o.centerFreq),
+		Length:     uint32(len(samples)),
+	}
+	o.sequence++
+	return append(header.Marshal(), samples...)
+}
+
+func (o *iqOutput) accept(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				o.logger.Error().Err(err).Msg("IQ output stopped accepting clients")
+			}
+			return
+		}
+		client := &iqClient{conn: conn, frames: make(chan []byte, iqClientQueueLength)}
+		o.lock.Lock()
+		o.clients[client] = struct{}{}
+		o.lock.Unlock()
+		o.logger.Info().Str("client", conn.RemoteAddr().String()).Msg("IQ output client connected")
+		go o.send(client)
+	}
+}
+
+// send writes frames to a client until it's disconnected.
+func (o *iqOutput) send(client *iqClient) {
+	for frame := range client.frames {
+		client.conn.SetWriteDeadline(time.Now().Add(iqClientWriteTimeout))
+		if _, err := client.conn.Write(frame); err != nil {
+			o.logger.Warn().
+				Err(err).
+				Str("client", client.conn.RemoteAddr().String()).
+				Msg("IQ output client disconnected")
+			o.disconnect(client)
+			// Drain what's left so broadcast never blocks on a dead client.
+			for range client.frames {
+			}
+			return
+		}
+	}
+}
+
+// broadcast queues a frame for every client, and disconnects those that are too far behind to take it.
+func (o *iqOutput) broadcast(frame []byte) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for client := range o.clients {
+		select {
+		case client.frames <- frame:
+		default:
+			o.logger.Warn().
+				Str("client", client.conn.RemoteAddr().String()).
+				Msg("IQ output client fell behind, disconnecting it")
+			o.disconnectLocked(client)
+		}
+	}
+}
+
+func (o *iqOutput) disconnect(client *iqClient) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.disconnectLocked(client)
+}
+
+func (o *iqOutput) disconnectLocked(client *iqClient) {
+	if _, ok := o.clients[client]; !ok {
+		return
+	}
+	delete(o.clients, client)
+	close(client.frames)
+	client.conn.Close()
+}
+
+func (o *iqOutput) disconnectAll() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for client := range o.clients {
+		o.disconnectLocked(client)
+	}
+}
@@ -0,0 +1,151 @@
+package turbine
+
+import (
+	"context"
+	"math"
+	"math/cmplx"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/synth"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device/iqstream"
+	"github.com/rs/zerolog"
+)
+
+// freePort finds a port that's free to listen on.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// TestIQOutput feeds an IQ output's samples to an iq_stream device, while another client that never reads is
+// disconnected without holding it up.
+func TestIQOutput(t *testing.T) {
+	const (
+		centerFreq = 852000000
+		sampleRate = 1000000
+	)
+	port := freePort(t)
+	o, err := newIQOutput(config.IQOutput{Port: port, Format: "cf32"}, centerFreq, sampleRate, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go o.run(ctx)
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	var slow net.Conn
+	for slow == nil {
+		if slow, err = net.Dial("tcp", addr); err != nil {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	defer slow.Close()
+
+	d, err := iqstream.NewIQStreamDevice(config.IQStream{Address: addr, Format: "cf32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan *types.SegmentComplex64)
+	go d.Start(ctx, centerFreq, sampleRate, received)
+	for clients(o) < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	gen := synth.NewGenerator(sampleRate, centerFreq, synth.WithNoise(20))
+	timeout := time.After(time.Minute)
+	for {
+		seg := gen.Segment(synthSegmentLength)
+		o.offer(seg)
+		select {
+		case got := <-received:
+			for i := range got.Data {
+				if got.Data[i] != seg.Data[i] {
+					t.Fatalf("sample %d received as %v, expected %v", i, got.Data[i], seg.Data[i])
+				}
+			}
+		case <-timeout:
+			t.Fatal("stopped receiving samples")
+		}
+		if clients(o) == 1 {
+			return
+		}
+	}
+}
+
+func clients(o *iqOutput) int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return len(o.clients)
+}
+
+func TestIQOutputSubBand(t *testing.T) {
+	o, err := newIQOutput(config.IQOutput{Port: 1, Decimation: 10, CenterFreq: 852200000}, 852000000, 8000000, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.centerFreq != 852200000 || o.sampleRate != 800000 {
+		t.Errorf("sub-band at %d Hz, %d samples per second", o.centerFreq, o.sampleRate)
+	}
+
+	// A tone 20 kHz above the sub-band's center should come out 20 kHz above zero.  Samples hold Q as the real
+	// part and I as the imaginary, which mirrors the spectrum.
+	in := make([]complex64, synthSegmentLength)
+	for i := range in {
+		phase := 2 * math.Pi * 220000 * float64(i) / 8000000
+		in[i] = complex64(complex(math.Sin(phase), math.Cos(phase)))
+	}
+	out := o.tuner.bfo.Work(o.tuner.bandpass.Work(in))
+	var rotation complex128
+	for i := len(out) / 2; i < len(out); i++ {
+		rotation += complex128(out[i] * complex(real(out[i-1]), -imag(out[i-1])))
+	}
+	if freq := -cmplx.Phase(rotation) / (2 * math.Pi) * 800000; math.Abs(freq-20000) > 100 {
+		t.Errorf("tone came out at %.0f Hz", freq)
+	}
+	if _, err := newIQOutput(config.IQOutput{Port: 1, Decimation: 10, CenterFreq: 857000000}, 852000000, 8000000, zerolog.Nop()); err == nil {
+		t.Error("sub-band outside the device's samples accepted")
+	}
+}
+
+// TestIQOutputDrops checks that segments dropped while the output is behind leave a gap in the sequence.
+func TestIQOutputDrops(t *testing.T) {
+	o, err := newIQOutput(config.IQOutput{Port: 1, Decimation: 10}, 852000000, 8000000, zerolog.Nop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := synth.NewGenerator(8000000, 852000000, synth.WithNoise(20))
+	for i := 0; i < iqOutputQueueLength+3; i++ {
+		o.offer(gen.Segment(1000))
+	}
+
+	sequence := func(queued iqOutputSegment) uint32 {
+		var header iqstream.Header
+		if err := header.Unmarshal(o.frame(queued)); err != nil {
+			t.Fatal(err)
+		}
+		return header.Sequence
+	}
+	for i := 0; i < iqOutputQueueLength; i++ {
+		if seq := sequence(<-o.segments); seq != uint32(i) {
+			t.Fatalf("frame %d sent with sequence %d", i, seq)
+		}
+	}
+	bandpass := o.tuner.bandpass
+	o.offer(gen.Segment(1000))
+	if seq := sequence(<-o.segments); seq != iqOutputQueueLength+3 {
+		t.Errorf("frame after 3 dropped segments sent with sequence %d", seq)
+	}
+	if o.tuner.bandpass == bandpass {
+		t.Error("sub-band filter wasn't reset after the gap")
+	}
+}
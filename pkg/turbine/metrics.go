@@ -0,0 +1,185 @@
+package turbine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/norasector/turbine/pkg/dsp/processor"
+	"github.com/norasector/turbine/pkg/metrics"
+	"github.com/norasector/turbine/pkg/op25/frame"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/device"
+)
+
+// turbineMetrics are the metrics reported by the receiver itself.  Decoders and outputs register their own.
+type turbineMetrics struct {
+	deviceSegments      *metrics.CounterVec
+	devicePPM           *metrics.Gauge
+	deviceGain          *metrics.GaugeVec
+	deviceGainChanges   *metrics.CounterVec
+	deviceClipping      *metrics.Gauge
+	devicePeak          *metrics.Gauge
+	deviceUp            *metrics.Gauge
+	deviceOutages       *metrics.CounterVec
+	deviceOutageTime    *metrics.Counter
+	segmentsProcessed   *metrics.CounterVec
+	samplesProcessed    *metrics.CounterVec
+	stageDuration       *metrics.HistogramVec
+	decodeFrames        *metrics.CounterVec
+	decodePackets       *metrics.CounterVec
+	decodeCRCFailures   *metrics.CounterVec
+	decodeSyncLosses    *metrics.CounterVec
+	decodeBitsCorrected *metrics.CounterVec
+	decodeInSync        *metrics.GaugeVec
+	decodeRate          *metrics.GaugeVec
+	channelLevel        *metrics.GaugeVec
+	squelchOpen         *metrics.GaugeVec
+	outputAudio         *metrics.CounterVec
+	outputSamples       *metrics.CounterVec
+	callEvents          *metrics.CounterVec
+	talkgroupCalls      *metrics.CounterVec
+	callDuration        *metrics.HistogramVec
+	eventSinkDrops      *metrics.CounterVec
+	signal              *signalMetrics
+}
+
+func newTurbineMetrics(reg *metrics.Registry) *turbineMetrics {
+	return &turbineMetrics{
+		deviceSegments: reg.CounterVec("turbine_device_segments_total",
+			"Segments of samples received from the device."),
+		devicePPM: reg.GaugeVec("turbine_device_ppm",
+			"Frequency correction applied to the device, in parts per million.").With(),
+		deviceGain: reg.GaugeVec("turbine_device_gain_db",
+			"Gain of each of the device's gain stages.", "stage"),
+		deviceGainChanges: reg.CounterVec("turbine_device_gain_changes_total",
+			"Changes to the device's gains by the front end AGC, by whether they were raised or lowered.",
+			"stage", "direction"),
+		deviceClipping: reg.GaugeVec("turbine_device_clipping_ratio",
+			"Fraction of the device's samples at full scale, over the front end AGC's last measurement.").With(),
+		deviceUp: reg.GaugeVec("turbine_device_up",
+			"Whether the device is delivering samples.").With(),
+		deviceOutages: reg.CounterVec("turbine_device_outages_total",
+			"Times the device failed or stopped delivering samples and was reopened, by cause.", "cause"),
+		deviceOutageTime: reg.CounterVec("turbine_device_outage_seconds_total",
+			"Time spent without samples from the device while it was reopened.").With(),
+		devicePeak: reg.GaugeVec("turbine_device_peak_dbfs",
+			"Level of the loudest 0.1% of the device's samples relative to full scale, over the front end AGC's "+
+				"last measurement.").With(),
+		segmentsProcessed: reg.CounterVec("turbine_segments_processed_total",
+			"Segments demodulated, by channel type.", "channel_type"),
+		samplesProcessed: reg.CounterVec("turbine_samples_processed_total",
+			"Complex samples demodulated, by channel type.", "channel_type"),
+		stageDuration: reg.HistogramVec("turbine_stage_duration_seconds",
+			"Time taken by each processing stage for one segment.", metrics.DurationBuckets, "channel_type", "stage"),
+		decodeFrames: reg.CounterVec("turbine_decode_frames_total",
+			"Frames received from a control channel while in sync, whether or not they decoded.", "system_id", "frequency"),
+		decodePackets: reg.CounterVec("turbine_decode_packets_total",
+			"Packets decoded from a control channel.", "system_id", "frequency"),
+		decodeCRCFailures: reg.CounterVec("turbine_decode_crc_failures_total",
+			"Packets from a control channel discarded for failing their CRC.", "system_id", "frequency"),
+		decodeSyncLosses: reg.CounterVec("turbine_decode_sync_losses_total",
+			"Times a control channel's decoder lost sync.", "system_id", "frequency"),
+		decodeBitsCorrected: reg.CounterVec("turbine_decode_bits_corrected_total",
+			"Bits fixed by error correction in packets decoded from a control channel.", "system_id", "frequency"),
+		decodeInSync: reg.GaugeVec("turbine_decode_in_sync",
+			"Whether a control channel's decoder is synchronized.", "system_id", "frequency"),
+		decodeRate: reg.GaugeVec("turbine_decode_rate",
+			"Packets per second decoded from a control channel over the last second.", "system_id", "frequency"),
+		channelLevel: reg.GaugeVec("turbine_channel_level_db",
+			"Average signal level of a voice channel.", "system_id", "frequency"),
+		squelchOpen: reg.GaugeVec("turbine_channel_squelch_open",
+			"Whether a voice channel's squelch is open.", "system_id", "frequency"),
+		outputAudio: reg.CounterVec("turbine_output_audio_total",
+			"Audio segments offered to each output, by whether they were delivered, dropped because the output "+
+				"was behind, or filtered by the talkgroup catalog.", "output", "result"),
+		outputSamples: reg.CounterVec("turbine_output_samples_total",
+			"Audio samples routed to outputs, by talkgroup action.", "action"),
+		callEvents: reg.CounterVec("turbine_call_events_total",
+			"Call events, by type.", "system_id", "event"),
+		talkgroupCalls: reg.CounterVec("turbine_talkgroup_calls_total",
+			"Calls started on each talkgroup in the catalog.  Calls on talkgroups it doesn't list are counted with "+
+				"an empty tgid.", "system_id", "tgid", "alpha_tag", "category"),
+		callDuration: reg.HistogramVec("turbine_call_duration_seconds",
+			"Duration of finished calls.", metrics.ExponentialBuckets(0.5, 2, 10), "system_id"),
+		eventSinkDrops: reg.CounterVec("turbine_event_sink_drops_total",
+			"Call events dropped because a sink was behind.", "sink"),
+		signal: newSignalMetrics(reg),
+	}
+}
+
+func WithMetrics(reg *metrics.Registry) TurbineOption {
+	return func(t *Turbine) error {
+		if reg != nil {
+			t.metricsRegistry = reg
+		}
+		return nil
+	}
+}
+
+// stageTimer records processing stage durations for a channel type.
+func (m *turbineMetrics) stageTimer(channelType string) processor.StageTimer {
+	return func(stage string, d time.Duration) {
+		m.stageDuration.With(channelType, stage).ObserveDuration(d)
+	}
+}
+
+// observeDeviceSettings sets the gain gauges from the device's settings.
+func (m *turbineMetrics) observeDeviceSettings(settings device.Settings) {
+	for stage, gain := range settings.Gains {
+		m.deviceGain.With(stage).Set(gain)
+	}
+}
+
+// observeDecodeStats adds what was decoded since prev to the decode counters.
+func (m *turbineMetrics) observeDecodeStats(systemID, freq string, prev, cur frame.Stats) {
+	addDelta(m.decodeFrames.With(systemID, freq), prev.Frames, cur.Frames)
+	addDelta(m.decodePackets.With(systemID, freq), prev.Packets, cur.Packets)
+	addDelta(m.decodeCRCFailures.With(systemID, freq), prev.CRCFailures, cur.CRCFailures)
+	addDelta(m.decodeSyncLosses.With(systemID, freq), prev.SyncLosses, cur.SyncLosses)
+	addDelta(m.decodeBitsCorrected.With(systemID, freq), prev.BitsCorrected, cur.BitsCorrected)
+	inSync := 0.0
+	if cur.InSync {
+		inSync = 1
+	}
+	m.decodeInSync.With(systemID, freq).Set(inSync)
+	m.decodeRate.With(systemID, freq).Set(cur.DecodeRate)
+}
+
+// removeDecodeStats drops the gauges of a control channel that's no longer monitored.
+func (m *turbineMetrics) removeDecodeStats(systemID, freq string) {
+	m.decodeInSync.Remove(systemID, freq)
+	m.decodeRate.Remove(systemID, freq)
+}
+
+func addDelta(c *metrics.Counter, prev, cur uint64) {
+	if cur > prev {
+		c.Add(float64(cur - prev))
+	}
+}
+
+func (m *turbineMetrics) observeCallEvent(ev *call.Event) {
+	systemID := strconv.Itoa(ev.Call.SystemID)
+	m.callEvents.With(systemID, string(ev.Type)).Inc()
+	if ev.Type == call.EventTypeStart {
+		// Only talkgroups in the catalog get labels of their own, so it bounds how many series there are.
+		tgid := ""
+		if ev.Call.TalkGroup != nil {
+			tgid = strconv.Itoa(ev.Call.TalkGroupID)
+		}
+		m.talkgroupCalls.With(systemID, tgid, ev.Call.AlphaTag(), ev.Call.Category()).Inc()
+	}
+	if ev.Type == call.EventTypeEnd {
+		m.callDuration.With(systemID).Observe(ev.Call.Duration)
+	}
+}
+
+// metricName is how an output or sink is labeled: its type name, without package or pointer.
+func metricName(v interface{}) string {
+	name := fmt.Sprintf("%T", v)
+	if idx := strings.LastIndexByte(name, '.'); idx >= 0 {
+		name = name[idx+1:]
+	}
+	return name
+}
@@ -0,0 +1,29 @@
+package turbine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine/pkg/metrics"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/catalog"
+)
+
+func TestTalkgroupCallMetrics(t *testing.T) {
+	m := newTurbineMetrics(metrics.NewRegistry())
+
+	listed := call.NewRecord(604, 1200, 851412500, time.Now())
+	listed.TalkGroup = &catalog.Talkgroup{AlphaTag: "PD Disp", Category: "Police"}
+	unlisted := call.NewRecord(604, 1300, 851412500, time.Now())
+	for _, rec := range []*call.Record{listed, listed, unlisted} {
+		m.observeCallEvent(&call.Event{Type: call.EventTypeStart, Call: rec})
+		m.observeCallEvent(&call.Event{Type: call.EventTypeEnd, Call: rec})
+	}
+
+	if v := m.talkgroupCalls.With("604", "1200", "PD Disp", "Police").Value(); v != 2 {
+		t.Errorf("counted %v calls on a listed talkgroup", v)
+	}
+	if v := m.talkgroupCalls.With("604", "", "", "").Value(); v != 1 {
+		t.Errorf("counted %v calls on unlisted talkgroups", v)
+	}
+}
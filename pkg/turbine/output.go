@@ -3,7 +3,8 @@ package turbine
 import (
 	"context"
 
-	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/catalog"
 )
 
 // AudioOutput handles incoming tagged audio samples.
@@ -11,5 +12,18 @@ type AudioOutput interface {
 	// Start receives a context and should run in a loop, terminating upon ctx closing or on any errors.
 	Start(ctx context.Context) error
 	// Receive returns a channel that receives tagged audio sample input.
-	Receive() chan<- *types.TaggedAudioSampleFloat32
+	Receive() chan<- *call.TaggedAudio
+}
+
+// CatalogOutput may be implemented by an AudioOutput to declare which talkgroup catalog action it serves.
+// Outputs that don't implement it are treated as streams.
+type CatalogOutput interface {
+	CatalogAction() catalog.Action
+}
+
+func outputAction(output AudioOutput) catalog.Action {
+	if co, ok := output.(CatalogOutput); ok {
+		return co.CatalogAction()
+	}
+	return catalog.ActionStream
 }
@@ -0,0 +1,154 @@
+// Package audiofile writes call recordings: Opus frames in an Ogg stream, and 16-bit PCM WAV.
+package audiofile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+const (
+	oggHeaderTypeBOS = 0x02
+	oggHeaderTypeEOS = 0x04
+
+	// Opus granule positions are always counted at 48kHz, regardless of the input rate.
+	opusGranuleRate = 48000
+	// Standard libopus encoder lookahead at 48kHz.
+	opusPreSkip = 312
+)
+
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := 0; i < 256; i++ {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = (r << 1) ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func oggCRC(crc uint32, b []byte) uint32 {
+	for _, v := range b {
+		crc = (crc << 8) ^ oggCRCTable[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+// oggWriter writes a single logical Ogg bitstream, placing one packet on each page.
+type oggWriter struct {
+	w        io.Writer
+	serial   uint32
+	sequence uint32
+}
+
+func newOggWriter(w io.Writer, serial uint32) *oggWriter {
+	return &oggWriter{w: w, serial: serial}
+}
+
+func (o *oggWriter) writePage(packet []byte, granule uint64, headerType byte) error {
+	// Lacing values: a run of 255s followed by the remainder, which terminates the packet.
+	segments := len(packet)/255 + 1
+	page := make([]byte, 27+segments+len(packet))
+
+	copy(page[0:4], "OggS")
+	page[4] = 0
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:14], granule)
+	binary.LittleEndian.PutUint32(page[14:18], o.serial)
+	binary.LittleEndian.PutUint32(page[18:22], o.sequence)
+	page[26] = byte(segments)
+	for i := 0; i < segments-1; i++ {
+		page[27+i] = 255
+	}
+	page[27+segments-1] = byte(len(packet) % 255)
+	copy(page[27+segments:], packet)
+
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC(0, page))
+
+	o.sequence++
+	_, err := o.w.Write(page)
+	return err
+}
+
+// OggOpusWriter writes Opus frames into an Ogg container as described in RFC 7845.
+type OggOpusWriter struct {
+	ogg        *oggWriter
+	sampleRate int
+	granule    uint64
+	lastPacket []byte
+	lastSet    bool
+}
+
+// NewOggOpusWriter writes the stream's headers, tagged with tags, to w.  serial identifies the stream, and
+// sampleRate is the rate of the audio before it was encoded.
+func NewOggOpusWriter(w io.Writer, serial uint32, sampleRate int, tags map[string]string) (*OggOpusWriter, error) {
+	ret := &OggOpusWriter{
+		ogg:        newOggWriter(w, serial),
+		sampleRate: sampleRate,
+		granule:    opusPreSkip,
+	}
+
+	head := make([]byte, 19)
+	copy(head[0:8], "OpusHead")
+	head[8] = 1 // version
+	head[9] = 1 // channels
+	binary.LittleEndian.PutUint16(head[10:12], opusPreSkip)
+	binary.LittleEndian.PutUint32(head[12:16], uint32(sampleRate))
+	binary.LittleEndian.PutUint16(head[16:18], 0) // output gain
+	head[18] = 0                                  // channel mapping family
+	if err := ret.ogg.writePage(head, 0, oggHeaderTypeBOS); err != nil {
+		return nil, err
+	}
+
+	vendor := "turbine"
+	comments := make([]string, 0, len(tags))
+	for k, v := range tags {
+		comments = append(comments, k+"="+v)
+	}
+	sort.Strings(comments)
+	var opusTags bytes.Buffer
+	opusTags.WriteString("OpusTags")
+	binary.Write(&opusTags, binary.LittleEndian, uint32(len(vendor)))
+	opusTags.WriteString(vendor)
+	binary.Write(&opusTags, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		binary.Write(&opusTags, binary.LittleEndian, uint32(len(c)))
+		opusTags.WriteString(c)
+	}
+	if err := ret.ogg.writePage(opusTags.Bytes(), 0, 0); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// WriteFrame queues an encoded frame holding the given number of input samples.  Frames are written
+// one behind so that the final page can be flagged as the end of the stream.
+func (o *OggOpusWriter) WriteFrame(frame []byte, samples int) error {
+	if o.lastSet {
+		if err := o.ogg.writePage(o.lastPacket, o.granule, 0); err != nil {
+			return err
+		}
+	}
+	o.granule += uint64(samples * opusGranuleRate / o.sampleRate)
+	o.lastPacket = frame
+	o.lastSet = true
+	return nil
+}
+
+// Close writes the final frame with the end of stream flag set.
+func (o *OggOpusWriter) Close() error {
+	if !o.lastSet {
+		// An Ogg stream must still be terminated; an empty packet is valid for this purpose.
+		return o.ogg.writePage(nil, o.granule, oggHeaderTypeEOS)
+	}
+	o.lastSet = false
+	return o.ogg.writePage(o.lastPacket, o.granule, oggHeaderTypeEOS)
+}
@@ -0,0 +1,140 @@
+package audiofile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// oggPage is a page read back from a stream.
+type oggPage struct {
+	headerType byte
+	granule    uint64
+	serial     uint32
+	sequence   uint32
+	lacing     []byte
+	packet     []byte
+}
+
+// readOggPages splits a stream into its pages, checking each page's CRC.
+func readOggPages(t *testing.T, b []byte) []oggPage {
+	t.Helper()
+	var pages []oggPage
+	for len(b) > 0 {
+		if len(b) < 27 || string(b[:4]) != "OggS" {
+			t.Fatalf("page %d doesn't start with a header", len(pages))
+		}
+		segments := int(b[26])
+		length := 27 + segments
+		for _, l := range b[27 : 27+segments] {
+			length += int(l)
+		}
+		page := append([]byte(nil), b[:length]...)
+		crc := binary.LittleEndian.Uint32(page[22:26])
+		binary.LittleEndian.PutUint32(page[22:26], 0)
+		if oggCRC(0, page) != crc {
+			t.Errorf("page %d has a bad CRC", len(pages))
+		}
+		pages = append(pages, oggPage{
+			headerType: b[5],
+			granule:    binary.LittleEndian.Uint64(b[6:14]),
+			serial:     binary.LittleEndian.Uint32(b[14:18]),
+			sequence:   binary.LittleEndian.Uint32(b[18:22]),
+			lacing:     b[27 : 27+segments],
+			packet:     b[27+segments : length],
+		})
+		b = b[length:]
+	}
+	return pages
+}
+
+func TestOggCRC(t *testing.T) {
+	// The CRC-32 Ogg uses: polynomial 0x04c11db7, unreflected, starting from and finishing with nothing.
+	if crc := oggCRC(0, []byte("123456789")); crc != 0x89a1897f {
+		t.Errorf("CRC is %#x", crc)
+	}
+}
+
+func TestOggLacing(t *testing.T) {
+	for _, test := range []struct {
+		length int
+		lacing []byte
+	}{
+		{0, []byte{0}},
+		{100, []byte{100}},
+		{255, []byte{255, 0}},
+		{600, []byte{255, 255, 90}},
+	} {
+		var buf bytes.Buffer
+		packet := bytes.Repeat([]byte{0xa5}, test.length)
+		if err := newOggWriter(&buf, 1).writePage(packet, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+		pages := readOggPages(t, buf.Bytes())
+		if len(pages) != 1 || !bytes.Equal(pages[0].lacing, test.lacing) || !bytes.Equal(pages[0].packet, packet) {
+			t.Errorf("%d byte packet laced as %+v", test.length, pages)
+		}
+	}
+}
+
+func TestOggOpusWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewOggOpusWriter(&buf, 42, 8000, map[string]string{"TURBINE_TGID": "10800", "ARTIST": "604"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two 20ms frames at 8kHz.
+	for _, frame := range [][]byte{{1, 2, 3}, {4, 5}} {
+		if err := w.WriteFrame(frame, 160); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := readOggPages(t, buf.Bytes())
+	if len(pages) != 4 {
+		t.Fatalf("%d pages", len(pages))
+	}
+	for i, page := range pages {
+		if page.serial != 42 || page.sequence != uint32(i) {
+			t.Errorf("page %d has serial %d, sequence %d", i, page.serial, page.sequence)
+		}
+	}
+
+	head := pages[0].packet
+	if pages[0].headerType != oggHeaderTypeBOS || string(head[:8]) != "OpusHead" || head[9] != 1 ||
+		binary.LittleEndian.Uint16(head[10:12]) != opusPreSkip || binary.LittleEndian.Uint32(head[12:16]) != 8000 {
+		t.Errorf("unexpected head page %+v", pages[0])
+	}
+	tags := pages[1].packet
+	if string(tags[:8]) != "OpusTags" || !bytes.Contains(tags, []byte("ARTIST=604")) ||
+		bytes.Index(tags, []byte("ARTIST=604")) > bytes.Index(tags, []byte("TURBINE_TGID=10800")) {
+		t.Errorf("unexpected tags page %q", tags)
+	}
+
+	// Granules count 48kHz samples from the pre-skip, and the last frame ends the stream.
+	if !bytes.Equal(pages[2].packet, []byte{1, 2, 3}) || pages[2].granule != opusPreSkip+960 || pages[2].headerType != 0 {
+		t.Errorf("unexpected first frame page %+v", pages[2])
+	}
+	if !bytes.Equal(pages[3].packet, []byte{4, 5}) || pages[3].granule != opusPreSkip+1920 ||
+		pages[3].headerType != oggHeaderTypeEOS {
+		t.Errorf("unexpected last frame page %+v", pages[3])
+	}
+}
+
+func TestOggOpusWriterEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewOggOpusWriter(&buf, 1, 8000, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pages := readOggPages(t, buf.Bytes())
+	if len(pages) != 3 || pages[2].headerType != oggHeaderTypeEOS || len(pages[2].packet) != 0 {
+		t.Errorf("unexpected pages %+v", pages)
+	}
+}
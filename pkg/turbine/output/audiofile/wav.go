@@ -0,0 +1,84 @@
+package audiofile
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+const wavHeaderLength = 44
+
+// WAVWriter writes mono 16-bit PCM WAV.  The RIFF and data chunk sizes are patched in on Close,
+// which is why it needs a WriteSeeker.
+type WAVWriter struct {
+	w            io.WriteSeeker
+	sampleRate   int
+	dataBytes    int
+	sampleBuffer []byte
+}
+
+func NewWAVWriter(w io.WriteSeeker, sampleRate int) (*WAVWriter, error) {
+	ret := &WAVWriter{
+		w:          w,
+		sampleRate: sampleRate,
+	}
+	if err := ret.writeHeader(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (w *WAVWriter) writeHeader() error {
+	const (
+		channels      = 1
+		bitsPerSample = 16
+	)
+	blockAlign := channels * bitsPerSample / 8
+
+	header := make([]byte, wavHeaderLength)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+w.dataBytes))
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16) // fmt chunk size
+	binary.LittleEndian.PutUint16(header[20:22], 1)  // PCM
+	binary.LittleEndian.PutUint16(header[22:24], channels)
+	binary.LittleEndian.PutUint32(header[24:28], uint32(w.sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(w.sampleRate*blockAlign))
+	binary.LittleEndian.PutUint16(header[32:34], uint16(blockAlign))
+	binary.LittleEndian.PutUint16(header[34:36], bitsPerSample)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(w.dataBytes))
+
+	_, err := w.w.Write(header)
+	return err
+}
+
+// WriteSamples writes samples, clipping them to full scale.
+func (w *WAVWriter) WriteSamples(samples []float32) error {
+	if cap(w.sampleBuffer) < len(samples)*2 {
+		w.sampleBuffer = make([]byte, len(samples)*2)
+	}
+	buf := w.sampleBuffer[:len(samples)*2]
+
+	for i, s := range samples {
+		v := math.Max(-1, math.Min(1, float64(s)))
+		binary.LittleEndian.PutUint16(buf[i*2:], uint16(int16(v*math.MaxInt16)))
+	}
+
+	n, err := w.w.Write(buf)
+	w.dataBytes += n
+	return err
+}
+
+// Close patches the header with the length written.  It doesn't close the underlying writer.
+func (w *WAVWriter) Close() error {
+	if _, err := w.w.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	_, err := w.w.Seek(0, io.SeekEnd)
+	return err
+}
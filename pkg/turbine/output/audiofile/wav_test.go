@@ -0,0 +1,61 @@
+package audiofile
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWAVWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "call.wav")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := NewWAVWriter(f, 8000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, samples := range [][]float32{{0, 0.5, -0.5}, {2, -2}} {
+		if err := w.WriteSamples(samples); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Anything written after the header is patched goes on the end, not over it.
+	if _, err := f.Write([]byte{0}); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != wavHeaderLength+10+1 {
+		t.Fatalf("file is %d bytes", len(b))
+	}
+	if string(b[0:4]) != "RIFF" || string(b[8:16]) != "WAVEfmt " || string(b[36:40]) != "data" {
+		t.Errorf("unexpected header %q", b[:wavHeaderLength])
+	}
+	if riff := binary.LittleEndian.Uint32(b[4:8]); riff != 36+10 {
+		t.Errorf("RIFF chunk is %d bytes", riff)
+	}
+	if data := binary.LittleEndian.Uint32(b[40:44]); data != 10 {
+		t.Errorf("data chunk is %d bytes", data)
+	}
+	if rate := binary.LittleEndian.Uint32(b[24:28]); rate != 8000 {
+		t.Errorf("sample rate is %d", rate)
+	}
+
+	// Samples beyond full scale are clipped.
+	expected := []int16{0, 16383, -16383, 32767, -32767}
+	for i, e := range expected {
+		if s := int16(binary.LittleEndian.Uint16(b[wavHeaderLength+i*2:])); s != e {
+			t.Errorf("sample %d is %d, expected %d", i, s, e)
+		}
+	}
+}
@@ -65,8 +65,7 @@ func (o *OpusEncoder) Start(ctx context.Context) error {
 					return err
 				}
 			case seg := <-o.receiveChan:
-				copy(o.inBuf[o.inBufPos:o.inBufPos+len(seg.Audio.Data)], seg.Audio.Data)
-				o.inBufPos += len(seg.Audio.Data)
+				o.appendSamples(seg.Audio.Data)
 				o.lastTG = *seg.TalkGroup
 				if err := o.maybeFlush(ctx, false); err != nil {
 					return err
@@ -79,6 +78,37 @@ func (o *OpusEncoder) Start(ctx context.Context) error {
 }
 
 func (o *OpusEncoder) maybeFlush(ctx context.Context, force bool) error {
+	frame, samplesPerFrame, err := o.encode(force)
+	if err != nil || frame == nil {
+		return err
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case o.outputChan <- &types.TaggedAudioFrameOpus{
+		Audio: &types.SegmentBinaryBytes{
+			SegmentNumber: o.segmentNumber,
+			Data:          frame,
+		},
+		TalkGroup:                &o.lastTG,
+		SampleLengthMicroseconds: samplesPerFrame * 1e6 / o.sampleRate,
+		Timestamp:                time.Now().UTC()}:
+		o.segmentNumber++
+	}
+	return nil
+}
+
+// appendSamples adds samples to the input buffer.  The caller must drain the buffer with encode
+// before it exceeds one frame plus len(samples).
+func (o *OpusEncoder) appendSamples(samples []float32) {
+	copy(o.inBuf[o.inBufPos:o.inBufPos+len(samples)], samples)
+	o.inBufPos += len(samples)
+}
+
+// encode encodes a single frame from the input buffer if enough samples are buffered, or if force
+// is set and any samples are buffered.  It returns a nil frame if nothing was encoded.
+func (o *OpusEncoder) encode(force bool) ([]byte, int, error) {
 
 	samplesPerFrame := o.sampleRate * usPerFrame / 1e6
 
@@ -99,14 +129,14 @@ func (o *OpusEncoder) maybeFlush(ctx context.Context, force bool) error {
 				o.inBufPos = 0
 				o.outBufPos = 0
 				o.outBufCount = 0
-				return nil
+				return nil, 0, nil
 			}
 		}
 
 		inputSample := o.inBuf[:samplesPerFrame]
 		bytesEncoded, err := o.encoder.EncodeFloat32(inputSample, o.encBuf[0:4096])
 		if err != nil {
-			return err
+			return nil, 0, err
 		}
 
 		// Move leftover samples to beginning of input buffer and reset position
@@ -124,21 +154,9 @@ func (o *OpusEncoder) maybeFlush(ctx context.Context, force bool) error {
 		o.outBufCount = 0
 		o.outBufPos = 0
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case o.outputChan <- &types.TaggedAudioFrameOpus{
-			Audio: &types.SegmentBinaryBytes{
-				SegmentNumber: o.segmentNumber,
-				Data:          ret,
-			},
-			TalkGroup:                &o.lastTG,
-			SampleLengthMicroseconds: samplesPerFrame * 1e6 / o.sampleRate,
-			Timestamp:                time.Now().UTC()}:
-			o.segmentNumber++
-		}
+		return ret, samplesPerFrame, nil
 	}
-	return nil
+	return nil, 0, nil
 }
 
 func (o *OpusEncoder) ReceiveChannel() chan<- *types.TaggedAudioSampleFloat32 {
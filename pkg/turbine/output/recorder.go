@@ -0,0 +1,433 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/norasector/turbine/pkg/metrics"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/catalog"
+	"github.com/norasector/turbine/pkg/turbine/output/audiofile"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+type RecordingFormat string
+
+const (
+	RecordingFormatOpus RecordingFormat = "opus"
+	RecordingFormatWAV  RecordingFormat = "wav"
+)
+
+const (
+	recorderBufferLength = 64
+	// recordingIdleTimeout closes a recording that has stopped receiving audio without a call end event.
+	recordingIdleTimeout = time.Second * 5
+	// recordingFinishedGrace drops trailing audio that arrives just after a call has ended.
+	recordingFinishedGrace = time.Second * 2
+	// opusChunkSize bounds how many samples are handed to the encoder at once so its buffer never overflows.
+	opusChunkSize = 960
+)
+
+type CallRecorderOptions struct {
+	Directory  string
+	Format     RecordingFormat
+	SampleRate int
+	// MaxAge removes recordings older than this.  Zero disables age-based retention.
+	MaxAge time.Duration
+	// MaxBytes removes the oldest recordings once the directory grows past this size.  Zero disables it.
+	MaxBytes int64
+}
+
+// CallRecorder writes each call to its own audio file, alongside a JSON file holding the call record.
+// Files are organised as <directory>/<system id>/<tgid>/<date>/<call id>.<ext>.
+type CallRecorder struct {
+	opts       CallRecorderOptions
+	recvChan   chan *call.TaggedAudio
+	eventChan  chan *call.Event
+	recordings map[recordingKey]*recording
+	finished   map[recordingKey]time.Time
+	written    *metrics.CounterVec
+	audioTime  *metrics.CounterVec
+}
+
+type recordingKey struct {
+	systemID int
+	tgid     int
+}
+
+type recordingWriter interface {
+	WriteSamples([]float32) error
+	Close() error
+}
+
+type recording struct {
+	record *call.Record
+	// provisional is set while the record was synthesized from audio, before the call was known.
+	provisional bool
+	lastAudio   time.Time
+	samples     int
+	tempPath    string
+	file        *os.File
+	writer      recordingWriter
+}
+
+type recordingMetadata struct {
+	Call          *call.Record    `json:"call"`
+	File          string          `json:"file"`
+	Format        RecordingFormat `json:"format"`
+	SampleRate    int             `json:"sample_rate"`
+	AudioDuration float64         `json:"audio_duration"`
+}
+
+func NewCallRecorder(opts CallRecorderOptions, reg *metrics.Registry) (*CallRecorder, error) {
+	switch opts.Format {
+	case "":
+		opts.Format = RecordingFormatOpus
+	case RecordingFormatOpus, RecordingFormatWAV:
+	default:
+		return nil, fmt.Errorf("unknown recording format %s", opts.Format)
+	}
+	if opts.Directory == "" {
+		return nil, fmt.Errorf("must specify recording directory")
+	}
+
+	return &CallRecorder{
+		opts:       opts,
+		recvChan:   make(chan *call.TaggedAudio, recorderBufferLength),
+		eventChan:  make(chan *call.Event, recorderBufferLength),
+		recordings: make(map[recordingKey]*recording),
+		finished:   make(map[recordingKey]time.Time),
+		written: reg.CounterVec("recorder_calls_written_total",
+			"Calls written to disk.", "system_id", "format"),
+		audioTime: reg.CounterVec("recorder_audio_seconds_total",
+			"Seconds of audio written to disk.", "system_id"),
+	}, nil
+}
+
+func (r *CallRecorder) Receive() chan<- *call.TaggedAudio {
+	return r.recvChan
+}
+
+// CatalogAction marks the recorder as receiving talkgroups the catalog sets to be recorded.
+func (r *CallRecorder) CatalogAction() catalog.Action {
+	return catalog.ActionRecord
+}
+
+// EventSink returns the sink through which the recorder learns about call boundaries and metadata.
+// It should be registered with the event sinks whenever the recorder is registered as an output.
+func (r *CallRecorder) EventSink() *CallRecorderEventSink {
+	return &CallRecorderEventSink{recorder: r}
+}
+
+type CallRecorderEventSink struct {
+	recorder *CallRecorder
+}
+
+func (s *CallRecorderEventSink) Receive() chan<- *call.Event {
+	return s.recorder.eventChan
+}
+
+// Start waits for ctx to close.  Events are consumed by the recorder's own loop.
+func (s *CallRecorderEventSink) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (r *CallRecorder) Start(ctx context.Context) error {
+	if err := os.MkdirAll(r.opts.Directory, 0755); err != nil {
+		return err
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+
+	if r.opts.MaxAge > 0 || r.opts.MaxBytes > 0 {
+		eg.Go(func() error {
+			return r.enforceRetention(ctx)
+		})
+	}
+
+	eg.Go(func() error {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				for key := range r.recordings {
+					r.finish(key)
+				}
+				return ctx.Err()
+
+			case now := <-ticker.C:
+				for key, rec := range r.recordings {
+					if now.Sub(rec.lastAudio) > recordingIdleTimeout {
+						r.finish(key)
+					}
+				}
+				for key, ts := range r.finished {
+					if now.Sub(ts) > recordingFinishedGrace {
+						delete(r.finished, key)
+					}
+				}
+
+			case ev := <-r.eventChan:
+				r.handleEvent(ev)
+
+			case ts := <-r.recvChan:
+				if err := r.handleAudio(ts); err != nil {
+					return err
+				}
+			}
+		}
+	})
+
+	return eg.Wait()
+}
+
+func (r *CallRecorder) handleEvent(ev *call.Event) {
+	key := recordingKey{systemID: ev.Call.SystemID, tgid: ev.Call.TalkGroupID}
+	rec, ok := r.recordings[key]
+
+	switch ev.Type {
+	case call.EventTypeStart:
+		delete(r.finished, key)
+		if ok && !rec.provisional && rec.record.ID != ev.Call.ID {
+			r.finish(key)
+			return
+		}
+		if ok {
+			rec.record = ev.Call
+			rec.provisional = false
+		}
+	case call.EventTypeUpdate:
+		if ok && (rec.provisional || rec.record.ID == ev.Call.ID) {
+			rec.record = ev.Call
+			rec.provisional = false
+		}
+	case call.EventTypeEnd:
+		if ok && (rec.provisional || rec.record.ID == ev.Call.ID) {
+			rec.record = ev.Call
+			rec.provisional = false
+			r.finish(key)
+			r.finished[key] = time.Now()
+		}
+	}
+}
+
+func (r *CallRecorder) handleAudio(ts *call.TaggedAudio) error {
+	key := recordingKey{systemID: ts.TalkGroup.SystemID, tgid: ts.TalkGroup.ID}
+	if _, ok := r.finished[key]; ok {
+		return nil
+	}
+
+	rec, ok := r.recordings[key]
+	if !ok {
+		var err error
+		rec, err = r.open(ts)
+		if err != nil {
+			// A recording failure should not take down the other outputs.
+			log.Error().Err(err).Int("tgid", key.tgid).Msg("error opening recording")
+			return nil
+		}
+		r.recordings[key] = rec
+	}
+
+	rec.lastAudio = time.Now()
+	rec.samples += len(ts.Audio.Data)
+	if err := rec.writer.WriteSamples(ts.Audio.Data); err != nil {
+		log.Error().Err(err).Str("file", rec.tempPath).Msg("error writing recording")
+		r.finish(key)
+	}
+	return nil
+}
+
+func (r *CallRecorder) directoryFor(rec *call.Record) string {
+	return filepath.Join(r.opts.Directory,
+		strconv.Itoa(rec.SystemID),
+		strconv.Itoa(rec.TalkGroupID),
+		rec.StartTime.UTC().Format("2006-01-02"))
+}
+
+func (r *CallRecorder) extension() string {
+	if r.opts.Format == RecordingFormatWAV {
+		return ".wav"
+	}
+	return ".ogg"
+}
+
+func (r *CallRecorder) open(ts *call.TaggedAudio) (*recording, error) {
+	now := time.Now()
+	provisional := ts.Call == nil
+	record := ts.Call
+	if provisional {
+		record = call.NewRecord(ts.TalkGroup.SystemID, ts.TalkGroup.ID, ts.TalkGroup.Frequency, now)
+		record.AddSource(ts.TalkGroup.SourceID, ts.SourceAlias, now)
+	}
+
+	dir := r.directoryFor(record)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	tempPath := filepath.Join(dir, record.ID+r.extension()+".part")
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var writer recordingWriter
+	switch r.opts.Format {
+	case RecordingFormatWAV:
+		writer, err = audiofile.NewWAVWriter(f, r.opts.SampleRate)
+	default:
+		writer, err = newOpusFileWriter(f, r.opts.SampleRate, record)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return nil, err
+	}
+
+	return &recording{
+		record:      record,
+		provisional: provisional,
+		lastAudio:   now,
+		tempPath:    tempPath,
+		file:        f,
+		writer:      writer,
+	}, nil
+}
+
+// finish closes a recording, moves it into place and writes its metadata.
+func (r *CallRecorder) finish(key recordingKey) {
+	rec, ok := r.recordings[key]
+	if !ok {
+		return
+	}
+	delete(r.recordings, key)
+
+	logger := log.With().Str("call_id", rec.record.ID).Int("tgid", key.tgid).Logger()
+
+	err := rec.writer.Close()
+	if closeErr := rec.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || rec.samples == 0 {
+		if err != nil {
+			logger.Error().Err(err).Msg("error closing recording")
+		}
+		os.Remove(rec.tempPath)
+		return
+	}
+
+	// The call may have started on a different date than the provisional record if events arrived late,
+	// so the final location is recomputed from the final record.
+	dir := r.directoryFor(rec.record)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logger.Error().Err(err).Msg("error creating recording directory")
+		return
+	}
+	audioPath := filepath.Join(dir, rec.record.ID+r.extension())
+	if err := os.Rename(rec.tempPath, audioPath); err != nil {
+		logger.Error().Err(err).Msg("error moving recording into place")
+		return
+	}
+
+	meta := recordingMetadata{
+		Call:          rec.record,
+		File:          filepath.Base(audioPath),
+		Format:        r.opts.Format,
+		SampleRate:    r.opts.SampleRate,
+		AudioDuration: float64(rec.samples) / float64(r.opts.SampleRate),
+	}
+	encoded, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		logger.Error().Err(err).Msg("error marshaling recording metadata")
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dir, rec.record.ID+".json"), encoded, 0644); err != nil {
+		logger.Error().Err(err).Msg("error writing recording metadata")
+		return
+	}
+
+	logger.Debug().Str("file", audioPath).Float64("audio_duration", meta.AudioDuration).Msg("recording written")
+
+	systemID := strconv.Itoa(key.systemID)
+	r.written.With(systemID, string(r.opts.Format)).Inc()
+	r.audioTime.With(systemID).Add(meta.AudioDuration)
+}
+
+// opusFileWriter encodes audio with an OpusEncoder and writes the frames into an Ogg file.
+type opusFileWriter struct {
+	enc *OpusEncoder
+	ogg *audiofile.OggOpusWriter
+}
+
+func newOpusFileWriter(f *os.File, sampleRate int, record *call.Record) (*opusFileWriter, error) {
+	enc, err := NewOpusEncoder(sampleRate, record.SystemID, record.TalkGroupID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := map[string]string{
+		"TURBINE_SYSTEM_ID": strconv.Itoa(record.SystemID),
+		"TURBINE_TGID":      strconv.Itoa(record.TalkGroupID),
+		"DATE":              record.StartTime.UTC().Format(time.RFC3339),
+	}
+	if alphaTag := record.AlphaTag(); alphaTag != "" {
+		tags["TITLE"] = alphaTag
+	}
+	if category := record.Category(); category != "" {
+		tags["GENRE"] = category
+	}
+
+	ogg, err := audiofile.NewOggOpusWriter(f, uint32(record.StartTime.UnixNano()), sampleRate, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return &opusFileWriter{enc: enc, ogg: ogg}, nil
+}
+
+func (o *opusFileWriter) drain(force bool) error {
+	for {
+		frame, samples, err := o.enc.encode(force)
+		if err != nil {
+			return err
+		}
+		if frame == nil {
+			return nil
+		}
+		if err := o.ogg.WriteFrame(frame, samples); err != nil {
+			return err
+		}
+	}
+}
+
+func (o *opusFileWriter) WriteSamples(samples []float32) error {
+	for len(samples) > 0 {
+		n := len(samples)
+		if n > opusChunkSize {
+			n = opusChunkSize
+		}
+		o.enc.appendSamples(samples[:n])
+		samples = samples[n:]
+		if err := o.drain(false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *opusFileWriter) Close() error {
+	if err := o.drain(true); err != nil {
+		return err
+	}
+	return o.ogg.Close()
+}
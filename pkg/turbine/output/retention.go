@@ -0,0 +1,33 @@
+package output
+
+import (
+	"context"
+	"time"
+
+	"github.com/norasector/turbine/pkg/turbine/output/retention"
+	"github.com/rs/zerolog/log"
+)
+
+const retentionInterval = time.Minute
+
+func (r *CallRecorder) enforceRetention(ctx context.Context) error {
+	ticker := time.NewTicker(retentionInterval)
+	defer ticker.Stop()
+
+	opts := retention.Options{MaxAge: r.opts.MaxAge, MaxBytes: r.opts.MaxBytes}
+	for {
+		removed, retained, err := retention.Sweep(r.opts.Directory, opts, time.Now())
+		if err != nil {
+			log.Warn().Err(err).Str("directory", r.opts.Directory).Msg("error enforcing recording retention")
+		}
+		if removed > 0 {
+			log.Info().Int("files_removed", removed).Int64("bytes_retained", retained).Msg("recording retention applied")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
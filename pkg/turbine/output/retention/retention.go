@@ -0,0 +1,105 @@
+// Package retention keeps a directory of recordings within an age and size limit.
+package retention
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// freshDirectoryAge is how old a directory must be before it's removed for being empty, so a recording being
+// opened doesn't lose its directory.
+const freshDirectoryAge = time.Minute
+
+type Options struct {
+	// MaxAge removes recordings older than this.  Zero disables age-based retention.
+	MaxAge time.Duration
+	// MaxBytes removes the oldest recordings once the directory grows past this size.  Zero disables it.
+	MaxBytes int64
+}
+
+// recording is a call's files: its audio and its metadata sidecar, which share a name and are removed together.
+type recording struct {
+	paths []string
+	size  int64
+	// modTime is that of the newest file.
+	modTime time.Time
+}
+
+// Sweep removes recordings in dir older than MaxAge, then the oldest recordings until the directory is under
+// MaxBytes, and then any directories left empty.  Recordings still being written, named *.part, are never
+// touched.  It returns how many files were removed and how many bytes are left.
+func Sweep(dir string, opts Options, now time.Time) (removed int, retained int64, err error) {
+	recordings := make(map[string]*recording)
+	var dirs []string
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if info, err := d.Info(); err == nil && path != dir && now.Sub(info.ModTime()) > freshDirectoryAge {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if strings.HasSuffix(path, ".part") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSuffix(path, filepath.Ext(path))
+		rec := recordings[name]
+		if rec == nil {
+			rec = &recording{}
+			recordings[name] = rec
+		}
+		rec.paths = append(rec.paths, path)
+		rec.size += info.Size()
+		if info.ModTime().After(rec.modTime) {
+			rec.modTime = info.ModTime()
+		}
+		retained += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	sorted := make([]*recording, 0, len(recordings))
+	for _, rec := range recordings {
+		sorted = append(sorted, rec)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].modTime.Before(sorted[j].modTime)
+	})
+
+	for _, rec := range sorted {
+		expired := opts.MaxAge > 0 && now.Sub(rec.modTime) > opts.MaxAge
+		oversize := opts.MaxBytes > 0 && retained > opts.MaxBytes
+		if !expired && !oversize {
+			break
+		}
+		for _, path := range rec.paths {
+			if err := os.Remove(path); err != nil {
+				return removed, retained, err
+			}
+			removed++
+		}
+		retained -= rec.size
+	}
+
+	// Remove any directories left empty, deepest first.  Removing a non-empty directory fails harmlessly.
+	sort.Slice(dirs, func(i, j int) bool {
+		return len(dirs[i]) > len(dirs[j])
+	})
+	for _, d := range dirs {
+		os.Remove(d)
+	}
+	return removed, retained, nil
+}
@@ -0,0 +1,121 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFile writes size bytes to path, dated age before now.
+func writeFile(t *testing.T, path string, size int, now time.Time, age time.Duration) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Now()
+	for _, test := range []struct {
+		name     string
+		opts     Options
+		removed  int
+		retained int64
+		kept     []string
+	}{
+		{"disabled", Options{}, 0, 600, []string{"old.wav", "mid.wav", "new.wav"}},
+		{"age", Options{MaxAge: 2 * time.Hour}, 1, 300, []string{"mid.wav", "new.wav"}},
+		{"size", Options{MaxBytes: 250}, 2, 100, []string{"new.wav"}},
+		{"both", Options{MaxAge: 2 * time.Hour, MaxBytes: 1000}, 1, 300, []string{"mid.wav", "new.wav"}},
+	} {
+		dir := t.TempDir()
+		sub := filepath.Join(dir, "sys", "10800")
+		writeFile(t, filepath.Join(sub, "old.wav"), 300, now, 3*time.Hour)
+		writeFile(t, filepath.Join(sub, "mid.wav"), 200, now, time.Hour)
+		writeFile(t, filepath.Join(sub, "new.wav"), 100, now, time.Minute)
+		// A recording still being written is neither counted nor removed, however old.
+		writeFile(t, filepath.Join(sub, "open.wav.part"), 1000, now, 4*time.Hour)
+
+		removed, retained, err := Sweep(dir, test.opts, now)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if removed != test.removed || retained != test.retained {
+			t.Errorf("%s: removed %d files, retained %d bytes", test.name, removed, retained)
+		}
+		for _, name := range test.kept {
+			if !exists(filepath.Join(sub, name)) {
+				t.Errorf("%s: %s was removed", test.name, name)
+			}
+		}
+		if !exists(filepath.Join(sub, "open.wav.part")) {
+			t.Errorf("%s: recording in progress was removed", test.name)
+		}
+	}
+}
+
+func TestSweepKeepsCallsWhole(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "1.ogg"), 300, now, 3*time.Hour)
+	writeFile(t, filepath.Join(dir, "1.json"), 50, now, 3*time.Hour-time.Second)
+	writeFile(t, filepath.Join(dir, "2.ogg"), 200, now, 3*time.Hour)
+	writeFile(t, filepath.Join(dir, "2.json"), 50, now, time.Minute)
+
+	// Removing 1.ogg alone would bring the directory under the limit, but its metadata goes with it.  2.ogg is
+	// as old, but its metadata was rewritten since.
+	removed, retained, err := Sweep(dir, Options{MaxAge: 2 * time.Hour, MaxBytes: 400}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 || retained != 250 {
+		t.Errorf("removed %d files, retained %d bytes", removed, retained)
+	}
+	if exists(filepath.Join(dir, "1.ogg")) || exists(filepath.Join(dir, "1.json")) {
+		t.Error("oldest call wasn't removed whole")
+	}
+	if !exists(filepath.Join(dir, "2.ogg")) || !exists(filepath.Join(dir, "2.json")) {
+		t.Error("newer call was split")
+	}
+}
+
+func TestSweepEmptyDirectories(t *testing.T) {
+	now := time.Now()
+	dir := t.TempDir()
+	old := filepath.Join(dir, "sys", "10800", "2026-01-01")
+	writeFile(t, filepath.Join(old, "call.wav"), 100, now, 48*time.Hour)
+	fresh := filepath.Join(dir, "sys", "10801")
+	if err := os.MkdirAll(fresh, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range []string{old, filepath.Dir(old), filepath.Join(dir, "sys")} {
+		if err := os.Chtimes(d, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, _, err := Sweep(dir, Options{MaxAge: 24 * time.Hour}, now); err != nil {
+		t.Fatal(err)
+	}
+	if exists(filepath.Dir(old)) {
+		t.Error("emptied directories weren't removed")
+	}
+	if !exists(fresh) {
+		t.Error("newly made directory was removed")
+	}
+	if !exists(dir) {
+		t.Error("recording directory was removed")
+	}
+}
@@ -7,7 +7,7 @@ import (
 	"io"
 	"time"
 
-	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/turbine/call"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -15,8 +15,8 @@ const sampleBufferLength int = 8
 
 type SimpleAudioOutput struct {
 	dest            io.Writer
-	recvChan        chan *types.TaggedAudioSampleFloat32
-	outChan         chan *types.TaggedAudioSampleFloat32
+	recvChan        chan *call.TaggedAudio
+	outChan         chan *call.TaggedAudio
 	sampleRate      int
 	sampleWaitTime  time.Duration
 	talkGroupFilter map[int]struct{}
@@ -26,8 +26,8 @@ func NewSimpleAudioOutput(dest io.Writer, sampleRate int, talkGroups []int) *Sim
 	ret := &SimpleAudioOutput{
 		dest:            dest,
 		sampleRate:      sampleRate,
-		recvChan:        make(chan *types.TaggedAudioSampleFloat32, sampleBufferLength),
-		outChan:         make(chan *types.TaggedAudioSampleFloat32, sampleBufferLength),
+		recvChan:        make(chan *call.TaggedAudio, sampleBufferLength),
+		outChan:         make(chan *call.TaggedAudio, sampleBufferLength),
 		sampleWaitTime:  time.Second,
 		talkGroupFilter: make(map[int]struct{}),
 	}
@@ -39,7 +39,7 @@ func NewSimpleAudioOutput(dest io.Writer, sampleRate int, talkGroups []int) *Sim
 	return ret
 }
 
-func (s *SimpleAudioOutput) Receive() chan<- *types.TaggedAudioSampleFloat32 {
+func (s *SimpleAudioOutput) Receive() chan<- *call.TaggedAudio {
 	return s.recvChan
 }
 
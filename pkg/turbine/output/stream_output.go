@@ -8,12 +8,10 @@ import (
 	"net"
 	"strconv"
 	"sync"
-	"time"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go"
-	"github.com/influxdata/influxdb-client-go/api"
-	"github.com/norasector/turbine-common/types"
 	commonTypes "github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/metrics"
+	"github.com/norasector/turbine/pkg/turbine/call"
 	"github.com/norasector/turbine/pkg/turbine/config"
 	"github.com/rs/zerolog/log"
 	"golang.org/x/sync/errgroup"
@@ -25,11 +23,12 @@ const receiveChannels = 8
 type TaggedOpusFrameUDPOutput struct {
 	dests      []config.OutputDestination
 	sampleRate int
-	recvChan   chan *types.TaggedAudioSampleFloat32
+	recvChan   chan *call.TaggedAudio
 	opusChan   chan *commonTypes.TaggedAudioFrameOpus
 	mu         sync.Mutex
 	encoders   map[int]map[int]*OpusEncoder
-	metrics    api.WriteAPI
+	frames     *metrics.CounterVec
+	bytesSent  *metrics.CounterVec
 }
 
 type OutputDestination struct {
@@ -37,18 +36,21 @@ type OutputDestination struct {
 	Port int
 }
 
-func NewTaggedOpusFrameUDPOutput(dests []config.OutputDestination, sampleRate int, metrics api.WriteAPI) *TaggedOpusFrameUDPOutput {
+func NewTaggedOpusFrameUDPOutput(dests []config.OutputDestination, sampleRate int, reg *metrics.Registry) *TaggedOpusFrameUDPOutput {
 	return &TaggedOpusFrameUDPOutput{
 		dests:      dests,
 		sampleRate: sampleRate,
-		recvChan:   make(chan *types.TaggedAudioSampleFloat32, receiveChannels),
+		recvChan:   make(chan *call.TaggedAudio, receiveChannels),
 		encoders:   make(map[int]map[int]*OpusEncoder),
 		opusChan:   make(chan *commonTypes.TaggedAudioFrameOpus),
-		metrics:    metrics,
+		frames: reg.CounterVec("stream_frames_total",
+			"Opus frames streamed, by whether they were sent to every destination.", "system_id", "result"),
+		bytesSent: reg.CounterVec("stream_bytes_sent_total",
+			"Bytes streamed to each destination.", "system_id"),
 	}
 }
 
-func (s *TaggedOpusFrameUDPOutput) Receive() chan<- *types.TaggedAudioSampleFloat32 {
+func (s *TaggedOpusFrameUDPOutput) Receive() chan<- *call.TaggedAudio {
 	return s.recvChan
 }
 
@@ -128,39 +130,17 @@ func (s *TaggedOpusFrameUDPOutput) Start(ctx context.Context) error {
 						continue
 					}
 
-					success := true
-					var bytesWritten int
+					systemID := strconv.Itoa(output.TalkGroup.SystemID)
+					result := "sent"
 					for _, destAddr := range destAddrs {
-						bytesWritten, err = conn.WriteToUDP(msgBuf.Bytes(), destAddr)
+						bytesWritten, err := conn.WriteToUDP(msgBuf.Bytes(), destAddr)
 						if err != nil {
 							log.Error().Err(err).Msg("error writing")
-							success = false
+							result = "dropped"
 						}
+						s.bytesSent.With(systemID).Add(float64(bytesWritten))
 					}
-
-					go s.metrics.WritePoint(influxdb2.NewPoint("opus.sent_frame",
-						map[string]string{
-							"channel_type": "voice",
-							"system_id":    strconv.Itoa(output.TalkGroup.SystemID),
-							"tgid":         strconv.Itoa(output.TalkGroup.ID),
-						},
-						map[string]interface{}{
-							"bytes_written":  bytesWritten,
-							"frame_length":   len(output.Audio.Data),
-							"encoded_length": len(encoded),
-							"sent": func() int {
-								if success {
-									return 1
-								}
-								return 0
-							}(),
-							"dropped": func() int {
-								if success {
-									return 0
-								}
-								return 1
-							}(),
-						}, time.Now()))
+					s.frames.With(systemID, result).Inc()
 				}
 			}
 		})
@@ -191,7 +171,7 @@ func (s *TaggedOpusFrameUDPOutput) Start(ctx context.Context) error {
 					select {
 					case <-ctx.Done():
 						return ctx.Err()
-					case enc.ReceiveChannel() <- ts:
+					case enc.ReceiveChannel() <- ts.TaggedAudioSampleFloat32:
 					}
 
 				}
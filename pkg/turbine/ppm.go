@@ -0,0 +1,133 @@
+package turbine
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/norasector/turbine/pkg/dsp/filters/fir"
+	"github.com/norasector/turbine/pkg/dsp/mixer"
+	"github.com/racerxdl/segdsp/dsp"
+)
+
+const (
+	// ppmSmoothing is the weight given to each control channel segment's estimate of the device's error.
+	ppmSmoothing = 0.01
+	// maxPPM bounds the correction, so a bad estimate can't tune channels somewhere absurd.
+	maxPPM = 200
+	// maxFrequencyError bounds a single measurement, in Hz, so one bad segment can only nudge the correction.
+	maxFrequencyError = 3000
+	// ppmRetuneThreshold is how far the correction moves before channels are retuned.
+	ppmRetuneThreshold = 0.05
+)
+
+// frequencyCorrection estimates the device's oscillator error, in parts per million, from the frequency error
+// measured on control channels.  A positive error means the oscillator runs fast, so signals appear below
+// their true frequency.
+type frequencyCorrection struct {
+	// bits is the float64 bits of the correction.  Accessed atomically.
+	bits uint64
+	mu   sync.Mutex
+}
+
+func newFrequencyCorrection(ppm float64) *frequencyCorrection {
+	c := &frequencyCorrection{}
+	c.set(ppm)
+	return c
+}
+
+func (c *frequencyCorrection) PPM() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.bits))
+}
+
+func (c *frequencyCorrection) set(ppm float64) {
+	atomic.StoreUint64(&c.bits, math.Float64bits(math.Max(-maxPPM, math.Min(maxPPM, ppm))))
+}
+
+// observe folds in the frequency error measured on a channel that was tuned with the applied correction.
+func (c *frequencyCorrection) observe(freq int, errorHz, applied float64) {
+	if freq == 0 || math.IsNaN(errorHz) {
+		return
+	}
+	errorHz = math.Max(-maxFrequencyError, math.Min(maxFrequencyError, errorHz))
+	estimate := applied - errorHz/float64(freq)*1e6
+
+	c.mu.Lock()
+	cur := c.PPM()
+	c.set(cur + ppmSmoothing*(estimate-cur))
+	c.mu.Unlock()
+}
+
+// channelTuner keeps a channel's band-pass decimator and BFO mixer centered on it, correcting for the device's
+// frequency error.  The band-pass decimator aliases the channel to somewhere within the first intermediate
+// frequency, and the BFO shifts it from there down to zero.
+type channelTuner struct {
+	frequency  int
+	centerFreq int
+	sampleRate int
+	if1        float64
+
+	bandpass *dsp.CTFirFilter
+	bfo      *mixer.WaveformMixer
+	ppm      float64
+}
+
+func newChannelTuner(freq, centerFreq, sampleRate, decimation int, ppm float64) *channelTuner {
+	c := &channelTuner{
+		frequency:  freq,
+		centerFreq: centerFreq,
+		sampleRate: sampleRate,
+		if1:        float64(sampleRate) / float64(decimation),
+		ppm:        ppm,
+	}
+	c.bandpass = dsp.MakeDecimationCTFirFilter(decimation, c.bandpassTaps())
+	c.bfo = mixer.NewWaveformMixer(int(c.if1), c.bfoFreq())
+	return c
+}
+
+// shiftFreq is where the channel appears relative to the center of the device's samples.
+func (c *channelTuner) shiftFreq() float64 {
+	return float64(c.frequency-c.centerFreq) - c.ppm*1e-6*float64(c.frequency)
+}
+
+func (c *channelTuner) bandpassTaps() []complex64 {
+	shiftFreq := c.shiftFreq()
+	return fir.MakeComplexBandPass(1.0,
+		float64(c.sampleRate),
+		shiftFreq-c.if1/2.0,
+		shiftFreq+c.if1/2.0,
+		c.if1/2,
+		fir.Hamming,
+	)
+}
+
+func (c *channelTuner) bfoFreq() int {
+	bfoFreq := c.shiftFreq() / c.if1
+	bfoFreq -= math.Floor(bfoFreq)
+	if bfoFreq < -0.5 {
+		bfoFreq += 1.0
+	}
+	if bfoFreq > 0.5 {
+		bfoFreq -= 1.0
+	}
+	return int(c.if1 * bfoFreq)
+}
+
+// reset clears the channel's filter and mixer, for when the samples it was given have a gap in them.  It must
+// be called from the goroutine processing the channel, between segments.
+func (c *channelTuner) reset() {
+	c.bandpass = dsp.MakeDecimationCTFirFilter(c.sampleRate/int(c.if1), c.bandpassTaps())
+	c.bfo = mixer.NewWaveformMixer(int(c.if1), c.bfoFreq())
+}
+
+// retune applies a new correction if it has moved far enough from the one in use.  It must be called from
+// the goroutine processing the channel, between segments.
+func (c *channelTuner) retune(ppm float64) bool {
+	if math.Abs(ppm-c.ppm) < ppmRetuneThreshold {
+		return false
+	}
+	c.ppm = ppm
+	c.bandpass.SetTaps(c.bandpassTaps())
+	c.bfo.SetFrequency(c.bfoFreq())
+	return true
+}
@@ -0,0 +1,29 @@
+package turbine
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFrequencyCorrection(t *testing.T) {
+	const (
+		freq    = 851012500
+		trueppm = -2.5
+	)
+	c := newFrequencyCorrection(0)
+	tuner := newChannelTuner(freq, 852000000, 8000000, 20, c.PPM())
+
+	for i := 0; i < 2000; i++ {
+		tuner.retune(c.PPM())
+		// The signal appears where the device's error puts it, and the channel measures what's left over.
+		residual := (tuner.ppm - trueppm) * 1e-6 * freq
+		c.observe(freq, residual, tuner.ppm)
+	}
+
+	if got := c.PPM(); math.Abs(got-trueppm) > ppmRetuneThreshold {
+		t.Errorf("correction %.3f ppm, expected %.3f ppm", got, trueppm)
+	}
+	if got, expected := tuner.shiftFreq(), float64(freq-852000000)-trueppm*1e-6*freq; math.Abs(got-expected) > 1e-6*freq*ppmRetuneThreshold {
+		t.Errorf("tuned to %.1f Hz, expected %.1f Hz", got, expected)
+	}
+}
@@ -0,0 +1,76 @@
+package turbine
+
+import (
+	"github.com/norasector/turbine/pkg/dsp/meter"
+	"github.com/norasector/turbine/pkg/metrics"
+	"github.com/norasector/turbine/pkg/turbine/call"
+)
+
+const (
+	// deviceFullScale is the sample magnitude of a full scale signal from the device, which signal levels are
+	// measured against.
+	deviceFullScale = 128
+	// defaultChannelBandwidth is used to measure channels whose system doesn't set a bandwidth.
+	defaultChannelBandwidth = 12500
+)
+
+func channelBandwidth(bw int) int {
+	if bw <= 0 {
+		return defaultChannelBandwidth
+	}
+	return bw
+}
+
+// channelSignal combines a channel's meters into a signal measurement.  Either meter may be nil.
+func channelSignal(sm *meter.SignalMeter, fm *meter.FrequencyMeter) call.Signal {
+	var sig call.Signal
+	if sm != nil {
+		if m, ok := sm.Measurement(); ok {
+			sig.RSSIDB = m.RSSIDB
+			sig.NoiseFloorDB = m.NoiseFloorDB
+			sig.SNRDB = m.SNRDB
+		}
+	}
+	if fm != nil {
+		sig.FrequencyErrorHz = fm.Offset()
+	}
+	return sig
+}
+
+// signalMetrics are the per-channel signal gauges.
+type signalMetrics struct {
+	rssi           *metrics.GaugeVec
+	noiseFloor     *metrics.GaugeVec
+	snr            *metrics.GaugeVec
+	frequencyError *metrics.GaugeVec
+}
+
+func newSignalMetrics(reg *metrics.Registry) *signalMetrics {
+	labels := []string{"channel_type", "system_id", "frequency"}
+	return &signalMetrics{
+		rssi: reg.GaugeVec("turbine_channel_rssi_db",
+			"Power within a channel's bandwidth, in dB relative to the device's full scale.", labels...),
+		noiseFloor: reg.GaugeVec("turbine_channel_noise_floor_db",
+			"Noise power within a channel's bandwidth, in dB relative to the device's full scale.", labels...),
+		snr: reg.GaugeVec("turbine_channel_snr_db",
+			"Signal to noise ratio of a channel.", labels...),
+		frequencyError: reg.GaugeVec("turbine_channel_frequency_error_hz",
+			"How far a channel's signal is above its assigned frequency.", labels...),
+	}
+}
+
+func (m *signalMetrics) observe(channelType, systemID, freq string, sig call.Signal, frequencyError bool) {
+	m.rssi.With(channelType, systemID, freq).Set(sig.RSSIDB)
+	m.noiseFloor.With(channelType, systemID, freq).Set(sig.NoiseFloorDB)
+	m.snr.With(channelType, systemID, freq).Set(sig.SNRDB)
+	if frequencyError {
+		m.frequencyError.With(channelType, systemID, freq).Set(sig.FrequencyErrorHz)
+	}
+}
+
+func (m *signalMetrics) remove(channelType, systemID, freq string) {
+	m.rssi.Remove(channelType, systemID, freq)
+	m.noiseFloor.Remove(channelType, systemID, freq)
+	m.snr.Remove(channelType, systemID, freq)
+	m.frequencyError.Remove(channelType, systemID, freq)
+}
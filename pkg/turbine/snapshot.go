@@ -0,0 +1,369 @@
+package turbine
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/op25/frame"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"github.com/norasector/turbine/pkg/turbine/device/sigmf"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultSnapshotDuration = 10 * time.Second
+	// crcFailureWindow is how long CRC failures are counted over to decide whether they're a burst.
+	crcFailureWindow = time.Second
+)
+
+// ErrNoSnapshot is returned when a snapshot is asked for but there are no samples to write.
+var ErrNoSnapshot = errors.New("no samples to snapshot")
+
+// iqRing holds the latest samples, encoded to keep them small.
+type iqRing struct {
+	format     iq.Format
+	sampleRate int
+
+	lock sync.Mutex
+	buf  []byte
+	// pos is where the next sample is written.
+	pos  int
+	full bool
+	// last is when the latest samples were written.
+	last    time.Time
+	scratch []byte
+}
+
+func newIQRing(format iq.Format, sampleRate int, duration time.Duration) *iqRing {
+	samples := int(float64(sampleRate) * duration.Seconds())
+	if samples < 1 {
+		samples = 1
+	}
+	return &iqRing{
+		format:     format,
+		sampleRate: sampleRate,
+		buf:        make([]byte, samples*format.SampleSize()),
+	}
+}
+
+func (r *iqRing) write(samples []complex64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.scratch = r.format.Encode(r.scratch[:0], samples)
+	data := r.scratch
+	if len(data) > len(r.buf) {
+		data = data[len(data)-len(r.buf):]
+	}
+	n := copy(r.buf[r.pos:], data)
+	copy(r.buf, data[n:])
+	if r.pos+len(data) >= len(r.buf) {
+		r.full = true
+	}
+	r.pos = (r.pos + len(data)) % len(r.buf)
+	r.last = time.Now()
+}
+
+// contents returns a copy of the samples held, oldest first, and when the latest of them were written.
+func (r *iqRing) contents() ([]byte, time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.full {
+		return append([]byte(nil), r.buf[:r.pos]...), r.last
+	}
+	data := make([]byte, 0, len(r.buf))
+	data = append(data, r.buf[r.pos:]...)
+	return append(data, r.buf[:r.pos]...), r.last
+}
+
+// snapshotter keeps the last few seconds of the device's samples, or of chosen channels', and writes them out
+// as SigMF when asked to, when a call is flagged as an emergency, or when a control channel fails a burst of
+// CRCs.  The device's samples are kept in its own format, so they're kept exactly; channels' as cf32.
+type snapshotter struct {
+	directory   string
+	duration    time.Duration
+	frequencies map[int]struct{}
+	onEmergency bool
+	crcFailures int
+	centerFreq  int
+	info        func() device.Info
+	logger      zerolog.Logger
+
+	// wideband holds the device's samples, unless only chosen channels are kept.
+	wideband *iqRing
+
+	lock     sync.Mutex
+	channels map[int]*snapshotTap
+	// lastAutomatic is when a snapshot was last taken without being asked for, so a run of failures doesn't
+	// write the same samples over and over.
+	lastAutomatic time.Time
+	emergencies   map[string]struct{}
+	crc           map[int]*crcCount
+	writing       sync.WaitGroup
+}
+
+// crcCount counts a control channel's CRC failures since start.
+type crcCount struct {
+	start    time.Time
+	failures uint64
+}
+
+// newSnapshotter returns nil if snapshots aren't configured.  format is the device's sample format.
+func newSnapshotter(cfg config.Snapshots, centerFreq, sampleRate int, format iq.Format, info func() device.Info, logger zerolog.Logger) *snapshotter {
+	if cfg.Directory == "" {
+		return nil
+	}
+	s := &snapshotter{
+		directory:   cfg.Directory,
+		duration:    cfg.Duration,
+		frequencies: make(map[int]struct{}),
+		onEmergency: cfg.OnEmergency,
+		crcFailures: cfg.CRCFailures,
+		centerFreq:  centerFreq,
+		info:        info,
+		logger:      logger,
+		channels:    make(map[int]*snapshotTap),
+		emergencies: make(map[string]struct{}),
+		crc:         make(map[int]*crcCount),
+	}
+	if s.duration == 0 {
+		s.duration = defaultSnapshotDuration
+	}
+	for _, freq := range cfg.Frequencies {
+		s.frequencies[freq] = struct{}{}
+	}
+	if len(s.frequencies) == 0 {
+		s.wideband = newIQRing(format, sampleRate, s.duration)
+	}
+	return s
+}
+
+// observe keeps a segment of the device's samples.
+func (s *snapshotter) observe(seg *types.SegmentComplex64) {
+	if s == nil || s.wideband == nil {
+		return
+	}
+	s.wideband.write(seg.Data)
+}
+
+// tap returns the block to add after a channel's lowpass decimator, or nil if the channel isn't kept.
+func (s *snapshotter) tap(systemID, freq, sampleRate int) *snapshotTap {
+	if s == nil {
+		return nil
+	}
+	if _, ok := s.frequencies[freq]; !ok {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	tap, ok := s.channels[freq]
+	if !ok || tap.ring.sampleRate != sampleRate {
+		tap = &snapshotTap{
+			systemID:  systemID,
+			frequency: freq,
+			ring:      newIQRing(iq.FormatCF32, sampleRate, s.duration),
+		}
+		s.channels[freq] = tap
+	}
+	return tap
+}
+
+// callEvent takes a snapshot the first time a call is flagged as an emergency.
+func (s *snapshotter) callEvent(ev *call.Event) {
+	if s == nil || !s.onEmergency {
+		return
+	}
+	rec := ev.Call
+	s.lock.Lock()
+	_, flagged := s.emergencies[rec.ID]
+	trigger := rec.Emergency && !flagged && ev.Type != call.EventTypeEnd
+	if trigger {
+		s.emergencies[rec.ID] = struct{}{}
+	}
+	if ev.Type == call.EventTypeEnd {
+		delete(s.emergencies, rec.ID)
+	}
+	s.lock.Unlock()
+
+	if trigger {
+		s.automatic("emergency", fmt.Sprintf("emergency call %s on system %d, talkgroup %d",
+			rec.ID, rec.SystemID, rec.TalkGroupID))
+	}
+}
+
+// decodeStats takes a snapshot when a control channel fails enough CRCs within crcFailureWindow.
+func (s *snapshotter) decodeStats(systemID, freq int, prev, cur frame.Stats) {
+	if s == nil || s.crcFailures == 0 || cur.CRCFailures <= prev.CRCFailures {
+		return
+	}
+	now := time.Now()
+	s.lock.Lock()
+	count, ok := s.crc[freq]
+	if !ok || now.Sub(count.start) > crcFailureWindow {
+		count = &crcCount{start: now}
+		s.crc[freq] = count
+	}
+	count.failures += cur.CRCFailures - prev.CRCFailures
+	failures := count.failures
+	burst := failures >= uint64(s.crcFailures)
+	if burst {
+		delete(s.crc, freq)
+	}
+	s.lock.Unlock()
+
+	if burst {
+		s.automatic("crc_failures", fmt.Sprintf("%d CRC failures on system %d control channel %s",
+			failures, systemID, op25.MHzToString(freq)))
+	}
+}
+
+// automatic takes a snapshot that wasn't asked for, unless the last one still holds most of these samples.
+func (s *snapshotter) automatic(reason, detail string) {
+	s.lock.Lock()
+	if !s.lastAutomatic.IsZero() && time.Since(s.lastAutomatic) < s.duration {
+		s.lock.Unlock()
+		s.logger.Debug().Str("reason", reason).Str("detail", detail).Msg("skipping snapshot soon after the last")
+		return
+	}
+	s.lastAutomatic = time.Now()
+	s.lock.Unlock()
+
+	if _, err := s.snapshot(reason, detail); err != nil {
+		s.logger.Warn().Err(err).Str("reason", reason).Msg("failed to take snapshot")
+	}
+}
+
+// pendingSnapshot is a copy of a ring's samples, waiting to be written.
+type pendingSnapshot struct {
+	path    string
+	format  iq.Format
+	data    []byte
+	global  sigmf.Global
+	capture sigmf.Capture
+}
+
+// snapshot copies the samples held and writes them out in the background, labelled with reason and detail.  It
+// returns the paths of the meta files being written, one for the device's samples or each channel's.
+func (s *snapshotter) snapshot(reason, detail string) ([]string, error) {
+	if s == nil {
+		return nil, fmt.Errorf("%w: snapshots aren't configured", ErrNoSnapshot)
+	}
+	now := time.Now().UTC()
+	dir := filepath.Join(s.directory, now.Format("20060102T150405.000Z")+"_"+reason)
+
+	info := s.info()
+	var pending []pendingSnapshot
+	add := func(name string, ring *iqRing, freq int, description string) {
+		data, last := ring.contents()
+		// A channel that's not been demodulated lately holds nothing from just before now.
+		if len(data) == 0 || now.Sub(last) > s.duration {
+			return
+		}
+		samples := len(data) / ring.format.SampleSize()
+		start := last.Add(-time.Duration(float64(samples) / float64(ring.sampleRate) * float64(time.Second))).UTC()
+		capture := sigmf.Capture{
+			Frequency: float64(freq),
+			DateTime:  &start,
+		}
+		if info.Gain != 0 {
+			capture.Gain = &info.Gain
+		}
+		pending = append(pending, pendingSnapshot{
+			path:   filepath.Join(dir, name),
+			format: ring.format,
+			data:   data,
+			global: sigmf.Global{
+				SampleRate:  float64(ring.sampleRate),
+				Hardware:    info.Name,
+				Description: description,
+			},
+			capture: capture,
+		})
+	}
+	if s.wideband != nil {
+		add("wideband", s.wideband, s.centerFreq, "Device samples")
+	}
+	s.lock.Lock()
+	for freq, tap := range s.channels {
+		add(strconv.Itoa(freq), tap.ring, freq,
+			fmt.Sprintf("System %d channel %s", tap.systemID, op25.MHzToString(freq)))
+	}
+	s.lock.Unlock()
+	if len(pending) == 0 {
+		return nil, ErrNoSnapshot
+	}
+
+	paths := make([]string, 0, len(pending))
+	for _, p := range pending {
+		metaPath, _ := sigmf.Paths(p.path)
+		paths = append(paths, metaPath)
+	}
+	s.logger.Info().Str("reason", reason).Str("detail", detail).Strs("paths", paths).Msg("taking snapshot")
+
+	s.writing.Add(1)
+	go func() {
+		defer s.writing.Done()
+		for _, p := range pending {
+			s.write(p, reason, detail)
+		}
+	}()
+	return paths, nil
+}
+
+func (s *snapshotter) write(p pendingSnapshot, reason, detail string) {
+	w, err := sigmf.Create(p.path, p.format, p.global, p.capture)
+	if err != nil {
+		s.logger.Error().Err(err).Str("path", p.path).Msg("failed to write snapshot")
+		return
+	}
+	if err := w.WriteEncoded(p.data); err != nil {
+		s.logger.Error().Err(err).Str("path", p.path).Msg("failed to write snapshot")
+	}
+	w.Annotate(sigmf.Annotation{
+		SampleCount: w.Samples(),
+		Label:       reason,
+		Comment:     detail,
+	})
+	if err := w.Close(); err != nil {
+		s.logger.Error().Err(err).Str("path", p.path).Msg("failed to finish snapshot")
+	}
+}
+
+// close waits for the snapshots being written.
+func (s *snapshotter) close() {
+	if s == nil {
+		return
+	}
+	s.writing.Wait()
+}
+
+// snapshotTap passes a channel's samples through unchanged, keeping the latest of them.
+type snapshotTap struct {
+	systemID  int
+	frequency int
+	ring      *iqRing
+}
+
+func (c *snapshotTap) PredictOutputSize(inputSize int) int {
+	return inputSize
+}
+
+func (c *snapshotTap) WorkBuffer(input, output []complex64) int {
+	copy(output, input)
+	c.ring.write(input)
+	return len(input)
+}
+
+func (c *snapshotTap) Work(data []complex64) []complex64 {
+	ret := make([]complex64, len(data))
+	c.WorkBuffer(data, ret)
+	return ret
+}
@@ -0,0 +1,124 @@
+package turbine
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/op25/frame"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device/iq"
+	"github.com/norasector/turbine/pkg/turbine/device/sigmf"
+	"github.com/rs/zerolog"
+)
+
+func TestIQRing(t *testing.T) {
+	r := newIQRing(iq.FormatCF32, 4, time.Second)
+	r.write([]complex64{1, 2, 3})
+	if data, _ := r.contents(); len(data) != 3*8 {
+		t.Fatalf("held %d bytes before filling", len(data))
+	}
+	r.write([]complex64{4, 5, 6})
+	r.write([]complex64{7, 8, 9, 10, 11})
+	data, _ := r.contents()
+	samples := make([]complex64, 4)
+	r.format.Decode(data, samples)
+	for i, want := range []complex64{8, 9, 10, 11} {
+		if samples[i] != want {
+			t.Errorf("sample %d is %v, expected %v", i, samples[i], want)
+		}
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	const (
+		controlFreq = 851612500
+		sampleRate  = 12500
+	)
+	dir := t.TempDir()
+	s := newSnapshotter(config.Snapshots{
+		Directory:   dir,
+		Duration:    time.Second,
+		Frequencies: []int{controlFreq},
+		OnEmergency: true,
+		CRCFailures: 5,
+	}, 851000000, 8000000, iq.FormatCF32, testDeviceInfo, zerolog.Nop())
+	if s.wideband != nil {
+		t.Error("kept the device's samples as well as the chosen channel's")
+	}
+	if s.tap(604, 851762500, sampleRate) != nil {
+		t.Error("tapped a channel that wasn't chosen")
+	}
+	if _, err := s.snapshot("request", ""); !errors.Is(err, ErrNoSnapshot) {
+		t.Errorf("snapshot with no samples returned %v", err)
+	}
+
+	control := s.tap(604, controlFreq, sampleRate)
+	control.Work(make([]complex64, 20000))
+
+	// Failures spread out don't trigger a snapshot, and neither does an emergency the last snapshot covers.
+	s.decodeStats(604, controlFreq, frame.Stats{}, frame.Stats{CRCFailures: 3})
+	s.decodeStats(604, controlFreq, frame.Stats{CRCFailures: 3}, frame.Stats{CRCFailures: 4})
+	s.crc[controlFreq].start = time.Now().Add(-2 * crcFailureWindow)
+	s.decodeStats(604, controlFreq, frame.Stats{CRCFailures: 4}, frame.Stats{CRCFailures: 8})
+	s.decodeStats(604, controlFreq, frame.Stats{CRCFailures: 8}, frame.Stats{CRCFailures: 9})
+	ev := callEvent(call.EventTypeStart, "a", 851762500, 0x100, time.Now())
+	ev.Call.Emergency = true
+	s.callEvent(ev)
+	s.close()
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*", "*"+sigmf.MetaExtension))
+	if len(matches) != 1 || !strings.HasSuffix(filepath.Dir(matches[0]), "_crc_failures") {
+		t.Fatalf("found snapshots %v", matches)
+	}
+	meta, samples := readRecording(t, matches[0])
+	if samples != sampleRate || meta.Global.SampleRate != sampleRate || meta.Captures[0].Frequency != controlFreq {
+		t.Errorf("unexpected snapshot of %d samples %+v", samples, meta)
+	}
+	if len(meta.Annotations) != 1 || meta.Annotations[0].SampleCount != sampleRate ||
+		meta.Annotations[0].Label != "crc_failures" {
+		t.Errorf("unexpected snapshot annotations %+v", meta.Annotations)
+	}
+
+	// One asked for is taken regardless.
+	paths, err := s.snapshot("request", "odd grant")
+	s.close()
+	if err != nil || len(paths) != 1 {
+		t.Fatalf("snapshot returned %v, %v", paths, err)
+	}
+	if meta, _ := readRecording(t, paths[0]); meta.Annotations[0].Comment != "odd grant" {
+		t.Errorf("unexpected snapshot annotations %+v", meta.Annotations)
+	}
+}
+
+func TestWidebandSnapshot(t *testing.T) {
+	// The device's samples are kept in its own format.
+	for format, datatype := range map[iq.Format]string{iq.FormatCS8: sigmf.DatatypeCI8, iq.FormatCS16: sigmf.DatatypeCI16} {
+		dir := t.TempDir()
+		s := newSnapshotter(config.Snapshots{Directory: dir, Duration: time.Second, OnEmergency: true},
+			851000000, 100000, format, testDeviceInfo, zerolog.Nop())
+		s.observe(&types.SegmentComplex64{Data: make([]complex64, 30000)})
+
+		ev := callEvent(call.EventTypeStart, "a", 851762500, 0x100, time.Now())
+		ev.Call.Emergency = true
+		s.callEvent(ev)
+		s.close()
+
+		matches, _ := filepath.Glob(filepath.Join(dir, "*_emergency", "wideband"+sigmf.MetaExtension))
+		if len(matches) != 1 {
+			t.Fatalf("found snapshots %v", matches)
+		}
+		meta, err := sigmf.ReadMeta(matches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Global.Datatype != datatype || meta.Annotations[0].SampleCount != 30000 ||
+			meta.Captures[0].Frequency != 851000000 {
+			t.Errorf("unexpected %s snapshot %+v", format, meta)
+		}
+	}
+}
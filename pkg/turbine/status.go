@@ -0,0 +1,387 @@
+package turbine
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/op25/frame"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/catalog"
+	"github.com/norasector/turbine/pkg/turbine/device"
+	"github.com/norasector/turbine/pkg/turbine/feed"
+)
+
+var (
+	ErrUnknownSystem       = errors.New("unknown system")
+	ErrUnknownFrequency    = errors.New("unknown frequency")
+	ErrFrequencyOutOfRange = errors.New("frequency outside of receiver bandwidth")
+	ErrNotRunning          = errors.New("not running yet")
+)
+
+// deviceConnectedTimeout is how long the device can go without delivering samples before it's reported as
+// disconnected.
+const deviceConnectedTimeout = time.Second * 2
+
+// outputStats counts what happened to audio sent to an output.  Accessed atomically.
+type outputStats struct {
+	delivered     uint64
+	dropped       uint64
+	filtered      uint64
+	lastDelivered int64
+}
+
+// deviceStats counts segments received from the device.  Accessed atomically.
+type deviceStats struct {
+	segments    uint64
+	lastSegment int64
+}
+
+type SystemStatus struct {
+	ID                 int             `json:"id"`
+	Name               string          `json:"name"`
+	SystemType         op25.SystemType `json:"system_type"`
+	ControlFrequencies []int           `json:"control_frequencies"`
+	SquelchLevel       int             `json:"squelch_level"`
+	TalkGroups         int             `json:"talkgroups"`
+	Units              int             `json:"units"`
+	ActiveCalls        int             `json:"active_calls"`
+	MutedTalkGroups    []int           `json:"muted_talkgroups"`
+}
+
+type ControlChannelStatus struct {
+	SystemID  int `json:"system_id"`
+	Frequency int `json:"frequency"`
+	Segments  int `json:"segments"`
+	// Healthy is whether the channel is decoding well enough to be relied on.
+	Healthy bool        `json:"healthy"`
+	Decode  frame.Stats `json:"decode"`
+	Signal  call.Signal `json:"signal"`
+}
+
+type VoiceChannelStatus struct {
+	SystemID     int         `json:"system_id"`
+	Frequency    int         `json:"frequency"`
+	SquelchOpen  bool        `json:"squelch_open"`
+	SquelchLevel float32     `json:"squelch_level"`
+	LastOpen     time.Time   `json:"last_open"`
+	TalkGroupID  int         `json:"tgid,omitempty"`
+	SourceID     int         `json:"source_id,omitempty"`
+	Signal       call.Signal `json:"signal"`
+}
+
+type DeviceStatus struct {
+	CenterFreq int `json:"center_freq"`
+	SampleRate int `json:"sample_rate"`
+	// PPM is the frequency correction applied to every channel.
+	PPM         float64   `json:"ppm"`
+	Connected   bool      `json:"connected"`
+	Segments    uint64    `json:"segments"`
+	LastSegment time.Time `json:"last_segment"`
+	// DroppedSamples is how many samples the device has lost, for devices that can lose them.
+	DroppedSamples uint64 `json:"dropped_samples,omitempty"`
+	// Settings and GainStages are the device's front end settings, for devices that have them.
+	Settings   *device.Settings   `json:"settings,omitempty"`
+	GainStages []device.GainStage `json:"gain_stages,omitempty"`
+	// Outages counts the times the device failed or stopped delivering samples and was reopened.  Reconnecting
+	// is set until it delivers samples again.
+	Outages      uint64     `json:"outages"`
+	Reconnecting bool       `json:"reconnecting,omitempty"`
+	LastOutage   *time.Time `json:"last_outage,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+}
+
+type OutputStatus struct {
+	Output        string         `json:"output"`
+	Action        catalog.Action `json:"action"`
+	QueueLength   int            `json:"queue_length"`
+	QueueCapacity int            `json:"queue_capacity"`
+	Delivered     uint64         `json:"delivered"`
+	Dropped       uint64         `json:"dropped"`
+	Filtered      uint64         `json:"filtered"`
+	LastDelivered time.Time      `json:"last_delivered"`
+}
+
+func timeFromUnixNano(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (t *Turbine) system(systemID int) (*internalSystem, error) {
+	sys, ok := t.systemMap[systemID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownSystem, systemID)
+	}
+	return sys, nil
+}
+
+// Systems returns the status of each configured system, ordered by ID.
+func (t *Turbine) Systems() []SystemStatus {
+	controlFreqs := make(map[int][]int)
+	t.controlMu.RLock()
+	for _, freq := range t.controlFreqs {
+		controlFreqs[freq.SystemID] = append(controlFreqs[freq.SystemID], freq.Frequency)
+	}
+	t.controlMu.RUnlock()
+
+	ret := make([]SystemStatus, 0, len(t.systemMap))
+	for _, sys := range t.systemMap {
+		vm := t.sm.VMForSystemID(sys.ID)
+		ret = append(ret, SystemStatus{
+			ID:                 sys.ID,
+			Name:               sys.Name,
+			SystemType:         sys.SystemType,
+			ControlFrequencies: controlFreqs[sys.ID],
+			SquelchLevel:       int(atomic.LoadInt32(&sys.squelchLevel)),
+			TalkGroups:         sys.catalog.Len(),
+			Units:              len(sys.units.Units()),
+			ActiveCalls:        len(vm.ActiveCalls()),
+			MutedTalkGroups:    vm.MutedTalkGroups(),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+// ControlChannels returns the decode status of each control channel being monitored.
+func (t *Turbine) ControlChannels() []ControlChannelStatus {
+	t.controlMu.RLock()
+	defer t.controlMu.RUnlock()
+
+	now := time.Now()
+	ret := make([]ControlChannelStatus, 0, len(t.controlFreqs))
+	for _, freq := range t.controlFreqs {
+		stats := freq.Stats()
+		ret = append(ret, ControlChannelStatus{
+			SystemID:  freq.SystemID,
+			Frequency: freq.Frequency,
+			Segments:  freq.segments(),
+			Healthy:   decodeHealthy(stats, now),
+			Decode:    stats,
+			Signal:    freq.Signal(),
+		})
+	}
+	return ret
+}
+
+// VoiceChannels returns the status of each voice channel being demodulated.
+func (t *Turbine) VoiceChannels() []VoiceChannelStatus {
+	t.mu.RLock()
+	freqs := make([]*VoiceFrequency, len(t.voiceFreqs))
+	copy(freqs, t.voiceFreqs)
+	t.mu.RUnlock()
+
+	ret := make([]VoiceChannelStatus, 0, len(freqs))
+	for _, freq := range freqs {
+		vm := t.sm.VMForSystemID(freq.SystemID)
+		status := VoiceChannelStatus{
+			SystemID:  freq.SystemID,
+			Frequency: freq.Frequency,
+		}
+		status.SquelchOpen, status.Signal, status.LastOpen = vm.SquelchState(freq.Frequency)
+		status.SquelchLevel = float32(status.Signal.LevelDB)
+		if tg := vm.TalkGroupForFrequency(freq.Frequency); tg != nil {
+			status.TalkGroupID = tg.ID
+			status.SourceID = tg.SourceID
+		}
+		ret = append(ret, status)
+	}
+	return ret
+}
+
+// ActiveCalls returns a snapshot of the calls in progress on every system.
+func (t *Turbine) ActiveCalls() []*call.Record {
+	var ret []*call.Record
+	for _, sys := range t.systemMap {
+		ret = append(ret, t.sm.VMForSystemID(sys.ID).ActiveCalls()...)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].StartTime.Before(ret[j].StartTime)
+	})
+	return ret
+}
+
+// DeviceStatus reports whether the device is delivering samples.
+func (t *Turbine) DeviceStatus() DeviceStatus {
+	last := timeFromUnixNano(atomic.LoadInt64(&t.deviceStats.lastSegment))
+	ret := DeviceStatus{
+		CenterFreq:  t.opts.CenterFreq,
+		SampleRate:  t.opts.SampleRate,
+		PPM:         t.correction.PPM(),
+		Connected:   !last.IsZero() && time.Since(last) < deviceConnectedTimeout,
+		Segments:    atomic.LoadUint64(&t.deviceStats.segments),
+		LastSegment: last,
+	}
+	t.deviceSupervisor.status(&ret)
+	if d, ok := t.device.(device.Dropper); ok {
+		ret.DroppedSamples = d.DroppedSamples()
+	}
+	if d, ok := t.device.(device.Controller); ok {
+		if settings := d.Settings(); !settings.IsEmpty() {
+			ret.Settings = &settings
+			ret.GainStages = d.GainStages()
+		}
+	}
+	return ret
+}
+
+// SetDeviceSettings changes the device's front end settings, leaving those not given as they are.  They take
+// effect straight away if the device is running.
+func (t *Turbine) SetDeviceSettings(changes device.Settings) error {
+	d, ok := t.device.(device.Controller)
+	if !ok {
+		return fmt.Errorf("%w: the device has no settings", device.ErrUnsupportedSetting)
+	}
+	if err := d.Apply(changes); err != nil {
+		return err
+	}
+	settings := d.Settings()
+	t.logger.Info().Interface("settings", settings).Msg("changed device settings")
+	t.metrics.observeDeviceSettings(settings)
+	return nil
+}
+
+// OutputHealth returns delivery counts for each audio output.
+func (t *Turbine) OutputHealth() []OutputStatus {
+	ret := make([]OutputStatus, 0, len(t.opts.AudioOutputs))
+	for idx, output := range t.opts.AudioOutputs {
+		stats := &t.outputStats[idx]
+		ch := output.Receive()
+		ret = append(ret, OutputStatus{
+			Output:        fmt.Sprintf("%T", output),
+			Action:        outputAction(output),
+			QueueLength:   len(ch),
+			QueueCapacity: cap(ch),
+			Delivered:     atomic.LoadUint64(&stats.delivered),
+			Dropped:       atomic.LoadUint64(&stats.dropped),
+			Filtered:      atomic.LoadUint64(&stats.filtered),
+			LastDelivered: timeFromUnixNano(atomic.LoadInt64(&stats.lastDelivered)),
+		})
+	}
+	return ret
+}
+
+// AddControlFrequency starts monitoring a control frequency for a system.  It returns ErrNotRunning until
+// Turbine has started.
+func (t *Turbine) AddControlFrequency(systemID, freq int) error {
+	sys, err := t.system(systemID)
+	if err != nil {
+		return err
+	}
+	if !t.freqWithinBounds(freq) {
+		return fmt.Errorf("%w: %s", ErrFrequencyOutOfRange, op25.MHzToString(freq))
+	}
+
+	t.controlMu.Lock()
+	defer t.controlMu.Unlock()
+	if !t.running {
+		return ErrNotRunning
+	}
+
+	delete(t.removedControlFreqs, freq)
+	delete(t.retiredControlFreqs, freq)
+	if _, ok := t.controlFreqCache[freq]; ok {
+		return nil
+	}
+
+	t.addControlFrequencyLocked(sys, freq)
+	t.logger.Info().Int("system_id", systemID).Str("frequency", op25.MHzToString(freq)).Msg("added control frequency")
+	return nil
+}
+
+// RemoveControlFrequency stops monitoring a control frequency.  It will not be picked up again when the
+// system announces it, unless it is added back.
+func (t *Turbine) RemoveControlFrequency(systemID, freq int) error {
+	if _, err := t.system(systemID); err != nil {
+		return err
+	}
+
+	t.controlMu.Lock()
+	defer t.controlMu.Unlock()
+	if !t.running {
+		return ErrNotRunning
+	}
+
+	if retired, ok := t.retiredControlFreqs[freq]; ok && retired.systemID == systemID {
+		delete(t.retiredControlFreqs, freq)
+		t.removedControlFreqs[freq] = struct{}{}
+		t.logger.Info().Int("system_id", systemID).Str("frequency", op25.MHzToString(freq)).Msg("removed control frequency")
+		t.publishControlChannel(systemID, freq, feed.ControlChannelRemoved)
+		return nil
+	}
+	for idx, ch := range t.controlFreqs {
+		if ch.SystemID == systemID && ch.Frequency == freq {
+			t.dropControlFrequencyLocked(idx)
+			t.removedControlFreqs[freq] = struct{}{}
+
+			t.logger.Info().Int("system_id", systemID).Str("frequency", op25.MHzToString(freq)).Msg("removed control frequency")
+			t.publishControlChannel(systemID, freq, feed.ControlChannelRemoved)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownFrequency, op25.MHzToString(freq))
+}
+
+// addControlFrequencyLocked starts decoding a control frequency.  controlMu must be held.
+func (t *Turbine) addControlFrequencyLocked(sys *internalSystem, freq int) {
+	t.controlFreqs = append(t.controlFreqs, NewControlFrequency(t, sys, freq))
+	t.controlFreqCache[freq] = struct{}{}
+	t.publishControlChannel(sys.ID, freq, feed.ControlChannelAdded)
+}
+
+// dropControlFrequencyLocked stops decoding the control frequency at idx in controlFreqs, and clears up after
+// it.  controlMu must be held.
+func (t *Turbine) dropControlFrequencyLocked(idx int) {
+	ch := t.controlFreqs[idx]
+	t.controlFreqs = append(t.controlFreqs[:idx:idx], t.controlFreqs[idx+1:]...)
+	delete(t.controlFreqCache, ch.Frequency)
+	t.channelRecorder.closeChannel(ch.Frequency)
+	t.metrics.removeDecodeStats(strconv.Itoa(ch.SystemID), op25.MHzToString(ch.Frequency))
+	t.metrics.signal.remove("control", strconv.Itoa(ch.SystemID), op25.MHzToString(ch.Frequency))
+}
+
+// MuteTalkGroup stops a talkgroup's audio from reaching any output.
+func (t *Turbine) MuteTalkGroup(systemID, tgid int) error {
+	if _, err := t.system(systemID); err != nil {
+		return err
+	}
+	t.sm.VMForSystemID(systemID).SetMuted(tgid, true)
+	t.logger.Info().Int("system_id", systemID).Int("tgid", tgid).Msg("muted talkgroup")
+	return nil
+}
+
+// UnmuteTalkGroup returns a muted talkgroup to its catalog action.
+func (t *Turbine) UnmuteTalkGroup(systemID, tgid int) error {
+	if _, err := t.system(systemID); err != nil {
+		return err
+	}
+	t.sm.VMForSystemID(systemID).SetMuted(tgid, false)
+	t.logger.Info().Int("system_id", systemID).Int("tgid", tgid).Msg("unmuted talkgroup")
+	return nil
+}
+
+// SetSquelch changes the squelch threshold of a system's voice channels.  It takes effect from the next
+// segment each channel processes.
+func (t *Turbine) SetSquelch(systemID, level int) error {
+	sys, err := t.system(systemID)
+	if err != nil {
+		return err
+	}
+	atomic.StoreInt32(&sys.squelchLevel, int32(level))
+	t.logger.Info().Int("system_id", systemID).Int("squelch_level", level).Msg("set squelch")
+	return nil
+}
+
+// Snapshot writes out the samples held from the last few seconds, labelled with comment, and returns the paths
+// of the SigMF meta files being written.  They're written in the background.
+func (t *Turbine) Snapshot(comment string) ([]string, error) {
+	return t.snapshots.snapshot("request", comment)
+}
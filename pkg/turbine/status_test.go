@@ -0,0 +1,30 @@
+package turbine
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/rs/zerolog"
+)
+
+func TestAddControlFrequencyBeforeStart(t *testing.T) {
+	tb, err := NewTurbine(nil, Options{
+		CenterFreq:            852000000,
+		SampleRate:            1000000,
+		VoiceOutputSampleRate: 8000,
+		Systems: []config.System{{
+			ID:         604,
+			SystemType: op25.SystemTypeSmartnet,
+			SymbolRate: 3600,
+		}},
+	}, WithLogger(zerolog.Nop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Control channels can't be changed until Start has set the systems up.
+	if err := tb.AddControlFrequency(604, 851637500); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("control frequency added before starting: %v", err)
+	}
+}
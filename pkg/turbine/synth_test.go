@@ -0,0 +1,240 @@
+package turbine
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/op25/frame/smartnet"
+	"github.com/norasector/turbine/pkg/synth"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/config"
+	"github.com/norasector/turbine/pkg/turbine/device/synthetic"
+	"github.com/norasector/turbine/pkg/turbine/feed"
+	"github.com/rs/zerolog"
+)
+
+const (
+	synthSegmentLength = 65536
+	// syntheticNoise puts the control channel about 25 dB above the noise in its bandwidth.
+	syntheticNoise = 12
+)
+
+// synthDevice delivers generated segments as fast as they're taken.
+type synthDevice struct {
+	gen *synth.Generator
+}
+
+func (d *synthDevice) Start(ctx context.Context, centerFreq int, sampleRate int, complexSamples chan *types.SegmentComplex64) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case complexSamples <- d.gen.Segment(synthSegmentLength):
+		}
+	}
+}
+
+func (d *synthDevice) Stop() error {
+	return nil
+}
+
+func (d *synthDevice) MaxSampleRate() int {
+	return 20e6
+}
+
+// TestSynthesizedGrant pushes a synthetic control channel through the whole receiver and checks the grant
+// that comes out, with both hard and soft decisions.  How the chain starts up depends on the noise it first
+// sees, so it's run with several seeds.
+func TestSynthesizedGrant(t *testing.T) {
+	for seed := int64(1); seed <= 6; seed++ {
+		for _, soft := range []bool{false, true} {
+			seed, soft := seed, soft
+			name := fmt.Sprintf("seed %d hard", seed)
+			if soft {
+				name = fmt.Sprintf("seed %d soft", seed)
+			}
+			t.Run(name, func(t *testing.T) {
+				testSynthesizedGrant(t, seed, soft)
+			})
+		}
+	}
+}
+
+func testSynthesizedGrant(t *testing.T, seed int64, softDecision bool) {
+	const (
+		centerFreq  = 852000000
+		controlFreq = 851612500 // channel 0x18
+		grantCmd    = 0x1e      // 851.7625 MHz
+	)
+
+	gen := synth.NewGenerator(1000000, centerFreq, synth.WithNoise(syntheticNoise), synth.WithSeed(seed))
+	cc := synth.NewSmartnetControlChannel(controlFreq, 40)
+	gen.Add(cc)
+
+	// Give the chain time to settle and sync before the grant.
+	for i := 0; i < 40; i++ {
+		cc.Send(synth.SmartnetIdle)
+	}
+	cc.Send(
+		smartnet.SmartnetPacket{Address: 0x1234, Command: 0x308},
+		smartnet.SmartnetPacket{Address: 0x2a32, Group: 1, Command: grantCmd},
+	)
+
+	tb, err := NewTurbine(&synthDevice{gen: gen}, Options{
+		CenterFreq:            centerFreq,
+		SampleRate:            1000000,
+		VoiceOutputSampleRate: 8000,
+		Systems: []config.System{{
+			ID:                 604,
+			ControlFrequencies: []int{controlFreq},
+			SystemType:         op25.SystemTypeSmartnet,
+			SymbolRate:         3600,
+			SquelchLevel:       -40,
+			SoftDecision:       softDecision,
+		}},
+	}, WithLogger(zerolog.Nop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := tb.Feed().Subscribe(feed.Filter{Types: map[feed.EventType]struct{}{feed.EventTypeGrant: {}}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- tb.Start(ctx)
+	}()
+	defer func() {
+		tb.Stop()
+		<-done
+	}()
+
+	select {
+	case ev := <-sub.Events():
+		grant := ev.Data.(feed.Grant)
+		if ev.SystemID != 604 || ev.TalkGroupID != 0x2a30 || grant.SourceID != 0x1234 ||
+			grant.Frequency != 851762500 || !grant.Emergency {
+			t.Errorf("unexpected grant %+v %+v", ev, grant)
+		}
+	case <-time.After(time.Minute):
+		t.Fatal("no grant decoded")
+	}
+
+	// Frames can fail while the chain settles, but not once it has synced.
+	channels := tb.ControlChannels()
+	if len(channels) != 1 || channels[0].Decode.Packets < 20 || channels[0].Decode.CRCFailures*2 > channels[0].Decode.Packets {
+		t.Errorf("unexpected control channel status %+v", channels)
+	}
+}
+
+// audioCapture is an output that hands on whatever audio it receives.
+type audioCapture struct {
+	audio chan *call.TaggedAudio
+}
+
+func (a *audioCapture) Start(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (a *audioCapture) Receive() chan<- *call.TaggedAudio {
+	return a.audio
+}
+
+// TestSyntheticDeviceCall runs the synthetic device and checks that a call it grants is followed, and that the
+// tone it carries comes out of the voice channel, with several seeds.
+func TestSyntheticDeviceCall(t *testing.T) {
+	for seed := int64(1); seed <= 5; seed++ {
+		seed := seed
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			testSyntheticDeviceCall(t, seed)
+		})
+	}
+}
+
+func testSyntheticDeviceCall(t *testing.T, seed int64) {
+	const (
+		centerFreq  = 852000000
+		controlFreq = 851612500
+		voiceFreq   = 851762500
+		toneFreq    = 1000
+	)
+
+	dev, err := synthetic.NewSyntheticDevice(config.Synthetic{
+		Noise:           syntheticNoise,
+		Seed:            seed,
+		Fast:            true,
+		ControlChannels: []config.SyntheticControlChannel{{Frequency: controlFreq}},
+		Calls: []config.SyntheticCall{{
+			Frequency:   voiceFreq,
+			TalkGroupID: 0x2a30,
+			SourceID:    0x1234,
+			ToneFreq:    toneFreq,
+			Duration:    5 * time.Second,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capture := &audioCapture{audio: make(chan *call.TaggedAudio, 64)}
+	tb, err := NewTurbine(dev, Options{
+		CenterFreq:            centerFreq,
+		SampleRate:            1000000,
+		VoiceOutputSampleRate: 8000,
+		AudioOutputs:          []AudioOutput{capture},
+		Systems: []config.System{{
+			ID:                 604,
+			ControlFrequencies: []int{controlFreq},
+			SystemType:         op25.SystemTypeSmartnet,
+			SymbolRate:         3600,
+			SquelchLevel:       -40,
+		}},
+	}, WithLogger(zerolog.Nop()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- tb.Start(ctx)
+	}()
+	defer func() {
+		tb.Stop()
+		<-done
+	}()
+
+	// Count zero crossings over a second of audio to find the tone.
+	var (
+		samples   []float32
+		timeout   = time.After(time.Minute)
+		sampleMax = 8000
+	)
+	for len(samples) < sampleMax {
+		select {
+		case audio := <-capture.audio:
+			if audio.TalkGroup.ID != 0x2a30 || audio.Call == nil || audio.Call.Frequency != voiceFreq {
+				t.Fatalf("unexpected audio %+v %+v", audio.TalkGroup, audio.Call)
+			}
+			samples = append(samples, audio.Audio.Data...)
+		case <-timeout:
+			t.Fatal("no audio")
+		}
+	}
+
+	crossings := 0
+	for i := 1; i < sampleMax; i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			crossings++
+		}
+	}
+	if crossings < 2*toneFreq*9/10 || crossings > 2*toneFreq*11/10 {
+		t.Errorf("expected a %dHz tone, got %d zero crossings in a second", toneFreq, crossings)
+	}
+}
@@ -0,0 +1,73 @@
+package turbine
+
+import (
+	"time"
+
+	"github.com/norasector/turbine/pkg/turbine/catalog"
+	"github.com/norasector/turbine/pkg/turbine/config"
+)
+
+// catalogReloadInterval is how often talkgroup catalog files are checked for changes.
+const catalogReloadInterval = time.Second * 10
+
+func newSystemCatalog(sys config.System) (*catalog.Catalog, error) {
+	defaultAction, err := catalog.ParseAction(sys.TalkgroupDefaultAction)
+	if err != nil {
+		return nil, err
+	}
+	return catalog.New(catalog.Options{
+		File:          sys.TalkgroupsFile,
+		DefaultAction: defaultAction,
+		Allow:         sys.TalkgroupAllow,
+		Deny:          sys.TalkgroupDeny,
+	})
+}
+
+// ReloadCatalogs re-reads every system's talkgroup catalog.  Calls already in progress keep the entry they
+// started with.
+func (t *Turbine) ReloadCatalogs() error {
+	for _, sys := range t.systemMap {
+		if err := sys.catalog.Reload(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchCatalogs reloads talkgroup catalogs whose files have changed.
+func (t *Turbine) watchCatalogs() error {
+	for _, sys := range t.systemMap {
+		if sys.TalkgroupsFile != "" {
+			t.logger.Info().
+				Int("system_id", sys.ID).
+				Str("file", sys.TalkgroupsFile).
+				Int("talkgroups", sys.catalog.Len()).
+				Msg("loaded talkgroup catalog")
+		}
+	}
+
+	ticker := time.NewTicker(catalogReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		case <-ticker.C:
+			for _, sys := range t.systemMap {
+				reloaded, err := sys.catalog.ReloadIfChanged()
+				if err != nil {
+					// Keep running with the previous catalog; a half-saved file shouldn't stop the receiver.
+					t.logger.Warn().Err(err).Int("system_id", sys.ID).Msg("error reloading talkgroup catalog")
+					continue
+				}
+				if reloaded {
+					t.logger.Info().
+						Int("system_id", sys.ID).
+						Str("file", sys.TalkgroupsFile).
+						Int("talkgroups", sys.catalog.Len()).
+						Msg("reloaded talkgroup catalog")
+				}
+			}
+		}
+	}
+}
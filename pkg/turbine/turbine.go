@@ -2,33 +2,38 @@ package turbine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go"
-	"github.com/influxdata/influxdb-client-go/api"
 	"github.com/norasector/turbine-common/types"
 	"github.com/norasector/turbine/pkg/dsp/viz"
+	"github.com/norasector/turbine/pkg/metrics"
 	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/turbine/call"
 	"github.com/norasector/turbine/pkg/turbine/device"
-	"github.com/norasector/turbine/pkg/util"
+	"github.com/norasector/turbine/pkg/turbine/feed"
+	"github.com/norasector/turbine/pkg/turbine/units"
 	"golang.org/x/sync/errgroup"
 )
 
 type Turbine struct {
 	device           device.Device
 	opts             Options
-	writeAPI         api.WriteAPI
+	metricsRegistry  *metrics.Registry
+	metrics          *turbineMetrics
 	rawSampleChan    chan *types.SegmentComplex64
 	outputChan       chan *types.TaggedAudioSampleFloat32
 	updateChan       chan op25.DataPacket
+	callEventChan    chan *call.Event
 	output           io.Writer
 	vizServer        *viz.Server
 	sm               *SystemManager
@@ -36,26 +41,35 @@ type Turbine struct {
 	voiceFreqs       []*VoiceFrequency
 	voiceFreqCache   map[int]struct{}
 	controlFreqCache map[int]struct{}
-	logger           zerolog.Logger
-	systemMap        map[int]*internalSystem
+	// removedControlFreqs are control frequencies removed at runtime, which are not re-added when announced.
+	removedControlFreqs map[int]struct{}
+	// retiredControlFreqs are control frequencies set aside for staying unhealthy, which are not re-added when
+	// announced, but come back if their system loses its healthy ones.
+	retiredControlFreqs map[int]retiredControlFrequency
+	outputStats         []outputStats
+	outputNames         []string
+	iqOutputs           []*iqOutput
+	channelRecorder     *channelRecorder
+	snapshots           *snapshotter
+	frontEndAGC         *frontEndAGC
+	deviceStats         *deviceStats
+	deviceSupervisor    *deviceSupervisor
+	correction          *frequencyCorrection
+	feed                *feed.Bus
+	logger              zerolog.Logger
+	systemMap           map[int]*internalSystem
 
 	mu        sync.RWMutex
 	controlMu sync.RWMutex
 	cancel    context.CancelFunc
 	ctx       context.Context
+	// running is set, under controlMu, once Start has set up the systems' control channels, so they can be
+	// changed through the API.
+	running bool
 }
 
 type TurbineOption func(t *Turbine) error
 
-func WithInfluxDB(influxClient api.WriteAPI) TurbineOption {
-	return func(t *Turbine) error {
-		if influxClient != nil {
-			t.writeAPI = influxClient
-		}
-		return nil
-	}
-}
-
 func WithImageServer(vizServer *viz.Server) TurbineOption {
 	return func(t *Turbine) error {
 		t.vizServer = vizServer
@@ -72,24 +86,45 @@ func WithLogger(logger zerolog.Logger) TurbineOption {
 
 func NewTurbine(device device.Device, options Options, opts ...TurbineOption) (*Turbine, error) {
 	t := &Turbine{
-		device:           device,
-		opts:             options,
-		output:           os.Stdout,
-		rawSampleChan:    make(chan *types.SegmentComplex64, 1),
-		outputChan:       make(chan *types.TaggedAudioSampleFloat32),
-		updateChan:       make(chan op25.DataPacket, 32),
-		writeAPI:         &util.MockWriteAPI{}, // overwritten with option
-		sm:               NewSystemManager(),
-		voiceFreqCache:   make(map[int]struct{}),
-		controlFreqCache: make(map[int]struct{}),
-		systemMap:        make(map[int]*internalSystem),
-		logger:           log.Logger,
+		device:              device,
+		opts:                options,
+		output:              os.Stdout,
+		rawSampleChan:       make(chan *types.SegmentComplex64, 1),
+		outputChan:          make(chan *types.TaggedAudioSampleFloat32),
+		updateChan:          make(chan op25.DataPacket, 32),
+		callEventChan:       make(chan *call.Event, callEventBufferLength),
+		metricsRegistry:     metrics.NewRegistry(), // overwritten with option
+		voiceFreqCache:      make(map[int]struct{}),
+		controlFreqCache:    make(map[int]struct{}),
+		removedControlFreqs: make(map[int]struct{}),
+		retiredControlFreqs: make(map[int]retiredControlFrequency),
+		deviceStats:         &deviceStats{},
+		correction:          newFrequencyCorrection(options.PPM),
+		feed:                feed.NewBus(),
+		systemMap:           make(map[int]*internalSystem),
+		logger:              log.Logger,
 	}
+	t.sm = NewSystemManager(t.emitCallEvent)
 
 	for _, sys := range options.Systems {
+		cat, err := newSystemCatalog(sys)
+		if err != nil {
+			return nil, fmt.Errorf("system %d: %w", sys.ID, err)
+		}
+		store, err := units.NewStore(units.Options{
+			AliasFile: sys.UnitAliasesFile,
+			Database:  sys.UnitDatabase,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("system %d: %w", sys.ID, err)
+		}
 		t.systemMap[sys.ID] = &internalSystem{
-			System: sys,
+			System:       sys,
+			catalog:      cat,
+			units:        store,
+			squelchLevel: int32(sys.SquelchLevel),
 		}
+		t.sm.AddSystem(sys.ID, WithSystemName(sys.Name), WithCatalog(cat), WithUnits(store))
 	}
 
 	for _, opt := range opts {
@@ -98,10 +133,35 @@ func NewTurbine(device device.Device, options Options, opts ...TurbineOption) (*
 		}
 	}
 
+	t.outputStats = make([]outputStats, len(t.opts.AudioOutputs))
+	t.metrics = newTurbineMetrics(t.metricsRegistry)
+	t.metrics.devicePPM.Set(t.correction.PPM())
+	t.deviceSupervisor = newDeviceSupervisor(t.opts.DeviceSupervisor, t.device, t.deviceStats, t.metrics, t.logger)
+	for _, output := range t.opts.AudioOutputs {
+		t.outputNames = append(t.outputNames, metricName(output))
+	}
+
 	if t.opts.CenterFreq == 0 || t.opts.SampleRate == 0.0 || t.opts.VoiceOutputSampleRate == 0.0 {
 		return nil, fmt.Errorf("must specify center freq, sample rate, and output rate")
 	}
 
+	t.channelRecorder = newChannelRecorder(t.opts.ChannelRecording, t.deviceInfo, t.logger)
+	t.snapshots = newSnapshotter(t.opts.Snapshots, t.opts.CenterFreq, t.opts.SampleRate, t.deviceFormat(), t.deviceInfo, t.logger)
+
+	agc, err := newFrontEndAGC(t.opts.FrontEndAGC, t.device, t.metrics, t.logger)
+	if err != nil {
+		return nil, err
+	}
+	t.frontEndAGC = agc
+
+	for _, cfg := range t.opts.IQOutputs {
+		o, err := newIQOutput(cfg, t.opts.CenterFreq, t.opts.SampleRate, t.logger)
+		if err != nil {
+			return nil, err
+		}
+		t.iqOutputs = append(t.iqOutputs, o)
+	}
+
 	return t, nil
 }
 
@@ -122,17 +182,27 @@ func (t *Turbine) Start(ctx context.Context) error {
 		return fmt.Errorf("error: sample rate %d > device max sample rate %d", t.opts.SampleRate, t.device.MaxSampleRate())
 	}
 
+	t.controlMu.Lock()
 	for _, sys := range t.systemMap {
 		sys.dataPacketChan = make(chan op25.OSWPacket)
 		for _, freq := range sys.ControlFrequencies {
-			ch := NewControlFrequency(t, sys, freq)
-			t.controlFreqs = append(t.controlFreqs, ch)
-			t.controlFreqCache[ch.Frequency] = struct{}{}
+			t.addControlFrequencyLocked(sys, freq)
 		}
 	}
+	t.running = true
+	t.controlMu.Unlock()
+
+	if !t.opts.DeviceSettings.IsEmpty() {
+		if err := t.SetDeviceSettings(t.opts.DeviceSettings); err != nil {
+			return err
+		}
+	}
+	if d, ok := t.device.(device.Controller); ok {
+		t.metrics.observeDeviceSettings(d.Settings())
+	}
 
 	eg.Go(func() error {
-		return t.device.Start(ctx,
+		return t.deviceSupervisor.run(ctx,
 			t.opts.CenterFreq,
 			t.opts.SampleRate,
 			t.rawSampleChan)
@@ -151,6 +221,19 @@ func (t *Turbine) Start(ctx context.Context) error {
 
 	eg.Go(t.processRawSamples)
 
+	for _, o := range t.iqOutputs {
+		thisOutput := o
+		eg.Go(func() error {
+			return thisOutput.run(t.ctx)
+		})
+	}
+
+	if t.frontEndAGC != nil {
+		eg.Go(func() error {
+			return t.frontEndAGC.run(t.ctx)
+		})
+	}
+
 	for _, output := range t.opts.AudioOutputs {
 		thisOutput := output
 		eg.Go(func() error {
@@ -158,12 +241,33 @@ func (t *Turbine) Start(ctx context.Context) error {
 		})
 	}
 
+	eg.Go(t.dispatchCallEvents)
+	eg.Go(t.expireCalls)
+	eg.Go(t.watchCatalogs)
+	eg.Go(t.saveUnits)
+	eg.Go(t.watchDecodeHealth)
+	eg.Go(t.watchControlChannels)
+
+	for _, sink := range t.opts.EventSinks {
+		thisSink := sink
+		eg.Go(func() error {
+			return thisSink.Start(t.ctx)
+		})
+	}
+
 	log.Info().
 		Str("center_freq", op25.MHzToString(t.opts.CenterFreq)).
 		Str("sample_rate", op25.MHzToString(t.opts.SampleRate)).
 		Msg("Starting")
 
-	if err := eg.Wait(); err != nil {
+	err := eg.Wait()
+	t.channelRecorder.close()
+	t.snapshots.close()
+	if err != nil {
+		if errors.Is(err, device.ErrEndOfSamples) {
+			log.Info().Msg("device has no more samples")
+			return nil
+		}
 		return err
 	}
 	return nil
@@ -176,7 +280,8 @@ func (t *Turbine) outputSamples() error {
 			return t.ctx.Err()
 		case buf := <-t.outputChan:
 			// We know systemID but need the rest.
-			tg := t.sm.VMForSystemID(buf.TalkGroup.SystemID).TalkGroupForFrequency(buf.Audio.Frequency)
+			vm := t.sm.VMForSystemID(buf.TalkGroup.SystemID)
+			tg := vm.TalkGroupForFrequency(buf.Audio.Frequency)
 			if tg == nil {
 				tg = &types.TalkGroup{}
 			}
@@ -187,26 +292,32 @@ func (t *Turbine) outputSamples() error {
 
 			buf.TalkGroup = tg
 
-			skippedOutputs := 0
-			for _, output := range t.opts.AudioOutputs {
+			action := vm.ActionFor(tg.ID)
+			audio := &call.TaggedAudio{
+				TaggedAudioSampleFloat32: buf,
+				Call:                     vm.CallForTalkGroup(tg.ID),
+				SourceAlias:              vm.UnitAlias(tg.SourceID),
+			}
+
+			for idx, output := range t.opts.AudioOutputs {
+				if !action.Has(outputAction(output)) {
+					atomic.AddUint64(&t.outputStats[idx].filtered, 1)
+					t.metrics.outputAudio.With(t.outputNames[idx], "filtered").Inc()
+					continue
+				}
 				select {
-				case output.Receive() <- buf:
+				case output.Receive() <- audio:
 					// We will not wait on blocked channels.
+					atomic.AddUint64(&t.outputStats[idx].delivered, 1)
+					atomic.StoreInt64(&t.outputStats[idx].lastDelivered, time.Now().UnixNano())
+					t.metrics.outputAudio.With(t.outputNames[idx], "delivered").Inc()
 				default:
-					skippedOutputs++
+					atomic.AddUint64(&t.outputStats[idx].dropped, 1)
+					t.metrics.outputAudio.With(t.outputNames[idx], "dropped").Inc()
 				}
 			}
 
-			go t.writeAPI.WritePoint(influxdb2.NewPoint("voice.types.output",
-				map[string]string{
-					"frequency": op25.MHzToString(buf.Audio.Frequency),
-				},
-				map[string]interface{}{
-					"samples_written": len(buf.Audio.Data),
-					"bytes_written":   len(buf.Audio.Data) * 4,
-					"skipped_outputs": skippedOutputs,
-				}, time.Now()))
-
+			t.metrics.outputSamples.With(action.String()).Add(float64(len(buf.Audio.Data)))
 		}
 	}
 }
@@ -239,6 +350,15 @@ func (t *Turbine) processRawSamples() error {
 		case buf := <-t.rawSampleChan:
 			segNum++
 			buf.SegmentNumber = segNum
+			atomic.AddUint64(&t.deviceStats.segments, 1)
+			atomic.StoreInt64(&t.deviceStats.lastSegment, time.Now().UnixNano())
+			t.metrics.deviceSegments.With().Inc()
+
+			for _, o := range t.iqOutputs {
+				o.offer(buf)
+			}
+			t.snapshots.observe(buf)
+			t.frontEndAGC.observe(buf)
 
 			eg, ctx := errgroup.WithContext(t.ctx)
 
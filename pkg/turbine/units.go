@@ -0,0 +1,30 @@
+package turbine
+
+import "time"
+
+// unitSaveInterval is how often unit databases are written to disk.
+const unitSaveInterval = time.Second * 30
+
+// saveUnits periodically persists each system's unit database, and once more on shutdown.
+func (t *Turbine) saveUnits() error {
+	ticker := time.NewTicker(unitSaveInterval)
+	defer ticker.Stop()
+
+	save := func() {
+		for _, sys := range t.systemMap {
+			if err := sys.units.Save(); err != nil {
+				t.logger.Warn().Err(err).Int("system_id", sys.ID).Str("file", sys.UnitDatabase).Msg("error saving unit database")
+			}
+		}
+	}
+
+	for {
+		select {
+		case <-t.ctx.Done():
+			save()
+			return t.ctx.Err()
+		case <-ticker.C:
+			save()
+		}
+	}
+}
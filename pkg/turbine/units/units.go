@@ -0,0 +1,271 @@
+package units
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxTalkGroupsPerUnit bounds how many talkgroups are remembered for each unit; the least recently used are
+// forgotten first.
+const maxTalkGroupsPerUnit = 64
+
+// Unit is a radio unit (source ID) seen on a system.
+type Unit struct {
+	ID    int    `json:"id"`
+	Alias string `json:"alias,omitempty"`
+	// Imported is set if the alias came from the alias file rather than being entered by hand.
+	Imported  bool      `json:"imported,omitempty"`
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+	LastSeen  time.Time `json:"last_seen,omitempty"`
+	// TalkGroups maps each talkgroup the unit has used to when it last used it.
+	TalkGroups map[int]time.Time `json:"talkgroups,omitempty"`
+}
+
+func (u *Unit) copy() *Unit {
+	ret := *u
+	ret.TalkGroups = make(map[int]time.Time, len(u.TalkGroups))
+	for k, v := range u.TalkGroups {
+		ret.TalkGroups[k] = v
+	}
+	return &ret
+}
+
+type Options struct {
+	// AliasFile is a CSV of unit IDs and aliases, imported on start.  Imported aliases replace those imported
+	// before, but not those set by hand.
+	AliasFile string
+	// Database is a JSON file the store is loaded from and saved to.  If empty, what is learned is lost on
+	// restart.
+	Database string
+}
+
+// Store holds the units of a single system.  It is safe for concurrent use.
+type Store struct {
+	opts  Options
+	mu    sync.RWMutex
+	units map[int]*Unit
+	dirty bool
+}
+
+func NewStore(opts Options) (*Store, error) {
+	s := &Store{
+		opts:  opts,
+		units: make(map[int]*Unit),
+	}
+
+	if opts.Database != "" {
+		if err := s.load(); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if opts.AliasFile != "" {
+		f, err := os.Open(opts.AliasFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		if err := s.Import(f); err != nil {
+			return nil, fmt.Errorf("%s: %w", opts.AliasFile, err)
+		}
+	}
+
+	return s, nil
+}
+
+func (s *Store) load() error {
+	contents, err := os.ReadFile(s.opts.Database)
+	if err != nil {
+		return err
+	}
+	var units []*Unit
+	if err := json.Unmarshal(contents, &units); err != nil {
+		return fmt.Errorf("%s: %w", s.opts.Database, err)
+	}
+	for _, u := range units {
+		s.units[u.ID] = u
+	}
+	return nil
+}
+
+// Import reads a CSV of unit IDs and aliases.  A header row, if present, is skipped, as is anything past
+// the second column.  Units given an alias by hand keep it.
+func (s *Store) Import(r io.Reader) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, row := range rows {
+		if len(row) < 2 {
+			continue
+		}
+		id, err := strconv.Atoi(strings.TrimSpace(row[0]))
+		if err != nil {
+			if i == 0 {
+				continue
+			}
+			return fmt.Errorf("row %d: %w", i+1, err)
+		}
+
+		u := s.unit(id)
+		if u.Alias != "" && !u.Imported {
+			continue
+		}
+		u.Alias = strings.TrimSpace(row[1])
+		u.Imported = true
+	}
+	s.dirty = true
+	return nil
+}
+
+// unit returns the unit for an ID, creating it if needed.  Must be called with s.mu held.
+func (s *Store) unit(id int) *Unit {
+	u, ok := s.units[id]
+	if !ok {
+		u = &Unit{ID: id}
+		s.units[id] = u
+	}
+	return u
+}
+
+// Observe records a unit being heard on a talkgroup.
+func (s *Store) Observe(id, tgid int, ts time.Time) {
+	if id == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.unit(id)
+	if u.FirstSeen.IsZero() {
+		u.FirstSeen = ts
+	}
+	u.LastSeen = ts
+
+	if tgid != 0 {
+		if u.TalkGroups == nil {
+			u.TalkGroups = make(map[int]time.Time)
+		}
+		u.TalkGroups[tgid] = ts
+		if len(u.TalkGroups) > maxTalkGroupsPerUnit {
+			oldest := tgid
+			for k, v := range u.TalkGroups {
+				if v.Before(u.TalkGroups[oldest]) {
+					oldest = k
+				}
+			}
+			delete(u.TalkGroups, oldest)
+		}
+	}
+	s.dirty = true
+}
+
+// Alias returns the alias of a unit, or an empty string if it has none.
+func (s *Store) Alias(id int) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if u, ok := s.units[id]; ok {
+		return u.Alias
+	}
+	return ""
+}
+
+// SetAlias sets a unit's alias by hand.
+func (s *Store) SetAlias(id int, alias string) {
+	s.mu.Lock()
+	u := s.unit(id)
+	u.Alias = alias
+	u.Imported = false
+	s.dirty = true
+	s.mu.Unlock()
+}
+
+// Lookup returns a copy of a unit, or nil if it has never been seen or imported.
+func (s *Store) Lookup(id int) *Unit {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if u, ok := s.units[id]; ok {
+		return u.copy()
+	}
+	return nil
+}
+
+// Units returns a copy of every unit, ordered by ID.
+func (s *Store) Units() []*Unit {
+	s.mu.RLock()
+	ret := make([]*Unit, 0, len(s.units))
+	for _, u := range s.units {
+		ret = append(ret, u.copy())
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret
+}
+
+// Save writes the store to its database file, if it has one and has changed since it was last saved.
+func (s *Store) Save() error {
+	if s.opts.Database == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err := s.write(); err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (s *Store) write() error {
+	encoded, err := json.MarshalIndent(s.Units(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash mid-write doesn't lose the database.
+	tmp, err := os.CreateTemp(filepath.Dir(s.opts.Database), filepath.Base(s.opts.Database)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(encoded); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.opts.Database)
+}
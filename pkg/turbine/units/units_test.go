@@ -0,0 +1,82 @@
+package units
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorePersistence(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "units.json")
+
+	s, err := NewStore(Options{Database: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Import(strings.NewReader("ID,Alias\n34512,Engine 21\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	first := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.Observe(34512, 1200, first)
+	s.Observe(34512, 1216, first.Add(time.Minute))
+	s.Observe(34513, 1200, first)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = NewStore(Options{Database: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := s.Lookup(34512)
+	if u == nil {
+		t.Fatal("unit not persisted")
+	}
+	if u.Alias != "Engine 21" || !u.Imported {
+		t.Errorf("unexpected alias %q imported=%v", u.Alias, u.Imported)
+	}
+	if !u.FirstSeen.Equal(first) || !u.LastSeen.Equal(first.Add(time.Minute)) {
+		t.Errorf("unexpected first/last seen %s %s", u.FirstSeen, u.LastSeen)
+	}
+	if len(u.TalkGroups) != 2 {
+		t.Errorf("got %d talkgroups, want 2", len(u.TalkGroups))
+	}
+	if s.Alias(34513) != "" {
+		t.Errorf("unexpected alias for learned unit")
+	}
+}
+
+func TestImportKeepsManualAliases(t *testing.T) {
+	db := filepath.Join(t.TempDir(), "units.json")
+	aliases := filepath.Join(t.TempDir(), "units.csv")
+	if err := os.WriteFile(aliases, []byte("34512,Engine 21\n34513,Engine 22\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStore(Options{AliasFile: aliases, Database: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetAlias(34512, "Rescue 1")
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarting imports the file again, which updates imported aliases but leaves the one set by hand.
+	if err := os.WriteFile(aliases, []byte("34512,Engine 21\n34513,Ladder 22\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err = NewStore(Options{AliasFile: aliases, Database: db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alias := s.Alias(34512); alias != "Rescue 1" {
+		t.Errorf("manual alias replaced by %q", alias)
+	}
+	if alias := s.Alias(34513); alias != "Ladder 22" {
+		t.Errorf("imported alias not updated, got %q", alias)
+	}
+}
@@ -4,13 +4,14 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"strconv"
+	"sync/atomic"
 	"time"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go"
 	"github.com/norasector/turbine-common/types"
 	"github.com/norasector/turbine/pkg/dsp/demodulators/quad"
 	"github.com/norasector/turbine/pkg/dsp/filters/fir"
-	"github.com/norasector/turbine/pkg/dsp/mixer"
+	"github.com/norasector/turbine/pkg/dsp/meter"
 	"github.com/norasector/turbine/pkg/dsp/processor"
 	"github.com/norasector/turbine/pkg/dsp/viz"
 	"github.com/norasector/turbine/pkg/op25"
@@ -23,7 +24,14 @@ type VoiceFrequency struct {
 	LastSeen  time.Time
 	SystemID  int
 
-	proc *processor.Processor
+	proc          *processor.Processor
+	tuner         *channelTuner
+	squelch       *dsp.Squelch
+	meter         *meter.SignalMeter
+	discriminator *meter.FrequencyMeter
+	system        *internalSystem
+	// squelchLevel is the threshold squelch was last set to, compared against the system's to pick up changes.
+	squelchLevel int32
 }
 
 func (freq *VoiceFrequency) initNBFM(t *Turbine, sys *internalSystem) {
@@ -50,17 +58,8 @@ func (freq *VoiceFrequency) initNBFM(t *Turbine, sys *internalSystem) {
 	if1 := float64(t.opts.SampleRate) / float64(dec1)
 	if2 := float64(if1) / float64(dec2)
 
-	// Offset from center
-	shiftFreq := freq.Frequency - t.opts.CenterFreq
-
-	bfoFreq := float64(shiftFreq) / if1
-	bfoFreq -= math.Floor(bfoFreq)
-	if bfoFreq < -0.5 {
-		bfoFreq += 1.0
-	}
-	if bfoFreq > 0.5 {
-		bfoFreq -= 1.0
-	}
+	// The band-pass decimator and BFO are retuned as the device's frequency error is learned.
+	freq.tuner = newChannelTuner(freq.Frequency, t.opts.CenterFreq, t.opts.SampleRate, dec1, t.correction.PPM())
 
 	t.logger.Info().
 		Int("system_id", freq.SystemID).
@@ -70,23 +69,17 @@ func (freq *VoiceFrequency) initNBFM(t *Turbine, sys *internalSystem) {
 		Int("decimation_2", dec2).
 		Int("intermediate_freq_1", int(if1)).
 		Int("intermediate_freq_2", int(if2)).
-		Str("shift_freq", op25.MHzToString(shiftFreq)).
-		Str("bfo_freq", op25.MHzToString(int(if1*bfoFreq))).
+		Str("shift_freq", op25.MHzToString(int(freq.tuner.shiftFreq()))).
+		Str("bfo_freq", op25.MHzToString(freq.tuner.bfoFreq())).
+		Float64("ppm", freq.tuner.ppm).
 		Msg("initializing channel")
 
-	bpfCoeffs := fir.MakeComplexBandPass(1.0,
-		float64(t.opts.SampleRate),
-		float64(shiftFreq)-if1/2.0,
-		float64(shiftFreq)+if1/2.0,
-		if1/2,
-		fir.Hamming,
-	)
 	freq.proc.AddBlock(processor.NewDSPWorkerCC(
 		"bandpass_decimator",
 		"Bandpass Decimator",
 		t.opts.SampleRate,
 		int(if1),
-		dsp.MakeDecimationCTFirFilter(dec1, bpfCoeffs),
+		freq.tuner.bandpass,
 	))
 
 	// Low pass -> get to 100k
@@ -98,7 +91,17 @@ func (freq *VoiceFrequency) initNBFM(t *Turbine, sys *internalSystem) {
 		"BFO Mixer",
 		int(if1),
 		int(if1),
-		mixer.NewWaveformMixer(int(if1), int(if1*bfoFreq)),
+		freq.tuner.bfo,
+	))
+
+	// Measure here, where the channel is centered but there's still spectrum either side to find the noise in.
+	freq.meter = meter.NewSignalMeter(int(if1), channelBandwidth(freq.Bandwidth), deviceFullScale)
+	freq.proc.AddBlock(processor.NewDSPWorkerCC(
+		"signal_meter",
+		"Signal Meter",
+		int(if1),
+		int(if1),
+		freq.meter,
 	))
 
 	// Mixer -- band pass
@@ -115,25 +118,59 @@ func (freq *VoiceFrequency) initNBFM(t *Turbine, sys *internalSystem) {
 		processor.ShowFFTBalance(),
 	))
 
+	if tap := t.channelRecorder.tap(sys.ID, freq.Frequency, int(if2)); tap != nil {
+		freq.proc.AddBlock(processor.NewDSPWorkerCC(
+			"channel_recorder",
+			"Channel Recorder",
+			int(if2),
+			int(if2),
+			tap,
+		))
+	}
+
+	if tap := t.snapshots.tap(sys.ID, freq.Frequency, int(if2)); tap != nil {
+		freq.proc.AddBlock(processor.NewDSPWorkerCC(
+			"snapshot_buffer",
+			"Snapshot Buffer",
+			int(if2),
+			int(if2),
+			tap,
+		))
+	}
+
+	freq.system = sys
+	freq.squelchLevel = atomic.LoadInt32(&sys.squelchLevel)
+	freq.squelch = dsp.MakeSquelch(float32(freq.squelchLevel), 0.1)
 	freq.proc.AddBlock(processor.NewDSPWorkerCC(
 		"squelch",
 		"Squelch",
 		int(if2),
 		int(if2),
-		dsp.MakeSquelch(float32(sys.SquelchLevel), 0.1),
+		freq.squelch,
 	))
 
 	deviation := 4000
+	demodGain := float32(if2) / (4 * math.Pi * float32(deviation))
 
 	freq.proc.AddBlock(processor.NewDSPWorkerCF(
 		"quad_demod",
 		"Quadrature Demodulator",
 		int(if2),
 		int(if2),
-		quad.MakeQuadDemod(float32(if2)/(4*math.Pi*float32(deviation))),
+		quad.MakeQuadDemod(demodGain),
 		processor.WithVizLength(int(if2)/40),
 	))
 
+	// The chain's spectrum is mirrored relative to the air, so the discriminator reads offsets inverted.
+	freq.discriminator = meter.NewFrequencyMeter(int(if2), float64(demodGain)).Invert()
+	freq.proc.AddBlock(processor.NewDSPWorkerFF(
+		"frequency_meter",
+		"Frequency Meter",
+		int(if2),
+		int(if2),
+		freq.discriminator,
+	))
+
 	freq.proc.AddBlock(processor.NewDSPWorkerFF(
 		"fm_deemphasis",
 		"FM Deemphasis",
@@ -200,30 +237,38 @@ func (freq *VoiceFrequency) init(t *Turbine, sys *internalSystem) {
 
 func (t *Turbine) processVoiceChannel(ctx context.Context, buf *types.SegmentComplex64, freq *VoiceFrequency) error {
 	start := time.Now()
-	metrics := map[string]interface{}{
-		"sample_length": len(buf.Data),
-		"sample_bytes":  len(buf.Data) * 8,
-	}
+	defer t.metrics.stageDuration.With("voice", "total").Since(start)
 
-	defer func() {
-
-		metrics["duration"] = time.Since(start).Microseconds()
-
-		go t.writeAPI.WritePoint(influxdb2.NewPoint("voice.processed",
-			map[string]string{
-				"frequency":    op25.MHzToString(freq.Frequency),
-				"sample_type":  "complex64",
-				"channel_type": "voice",
-			},
-			metrics, time.Now()))
-	}()
+	// Squelch isn't safe to change while processing, so runtime changes are picked up here.
+	if level := atomic.LoadInt32(&freq.system.squelchLevel); level != freq.squelchLevel {
+		freq.squelch.SetThreshold(float32(level))
+		freq.squelchLevel = level
+	}
+	freq.tuner.retune(t.correction.PPM())
 
-	samples, err := freq.proc.ProcessComplexToFloat(buf, metrics)
+	samples, err := freq.proc.ProcessComplexToFloat(buf, t.metrics.stageTimer("voice"))
 	if err != nil {
 		return err
 	}
 	samples.Frequency = freq.Frequency
 
+	open := !freq.squelch.IsMuted()
+	signal := channelSignal(freq.meter, freq.discriminator)
+	signal.LevelDB = float64(freq.squelch.GetAvgLevel())
+	t.sm.VMForSystemID(freq.SystemID).UpdateSquelch(freq.Frequency, open, signal)
+
+	t.metrics.segmentsProcessed.With("voice").Inc()
+	t.metrics.samplesProcessed.With("voice").Add(float64(len(buf.Data)))
+	systemID, frequency := strconv.Itoa(freq.SystemID), op25.MHzToString(freq.Frequency)
+	t.metrics.channelLevel.With(systemID, frequency).Set(signal.LevelDB)
+	// The discriminator only sees a signal while the squelch is open.
+	t.metrics.signal.observe("voice", systemID, frequency, signal, open)
+	squelchOpen := 0.0
+	if open {
+		squelchOpen = 1
+	}
+	t.metrics.squelchOpen.With(systemID, frequency).Set(squelchOpen)
+
 	select {
 	case t.outputChan <- &types.TaggedAudioSampleFloat32{
 		TalkGroup: &types.TalkGroup{
@@ -1,23 +1,41 @@
 package turbine
 
 import (
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/norasector/turbine-common/types"
+	"github.com/norasector/turbine/pkg/op25"
+	"github.com/norasector/turbine/pkg/turbine/call"
+	"github.com/norasector/turbine/pkg/turbine/catalog"
+	"github.com/norasector/turbine/pkg/turbine/units"
 )
 
 type SystemManager struct {
-	VMs map[int]*VoiceManager
-	mu  sync.Mutex
+	VMs  map[int]*VoiceManager
+	mu   sync.Mutex
+	emit func(*call.Event)
 }
 
-func NewSystemManager() *SystemManager {
+// NewSystemManager creates a system manager.  emit, if not nil, receives call events from every system.
+func NewSystemManager(emit func(*call.Event)) *SystemManager {
 	return &SystemManager{
-		VMs: make(map[int]*VoiceManager),
+		VMs:  make(map[int]*VoiceManager),
+		emit: emit,
 	}
 }
 
+// AddSystem creates the voice manager for a configured system.  Systems that are not added get a voice
+// manager with no name or catalog the first time they are seen.
+func (s *SystemManager) AddSystem(systemID int, opts ...VoiceManagerOption) *VoiceManager {
+	vm := NewVoiceManager(systemID, s.emit, opts...)
+	s.mu.Lock()
+	s.VMs[systemID] = vm
+	s.mu.Unlock()
+	return vm
+}
+
 func (s *SystemManager) VMForSystemID(systemID int) *VoiceManager {
 	if systemID == 0 {
 		panic("got 0 system ID")
@@ -25,7 +43,7 @@ func (s *SystemManager) VMForSystemID(systemID int) *VoiceManager {
 	s.mu.Lock()
 	vm, ok := s.VMs[systemID]
 	if !ok {
-		vm = NewVoiceManager(systemID)
+		vm = NewVoiceManager(systemID, s.emit)
 		s.VMs[systemID] = vm
 	}
 	s.mu.Unlock()
@@ -39,16 +57,100 @@ type VoiceManager struct {
 	mu                   sync.RWMutex
 	purgeTime            time.Duration
 	systemID             int
+	systemName           string
+	catalog              *catalog.Catalog
+	units                *units.Store
+	muted                map[int]struct{}
+
+	calls   map[int]*activeCall
+	squelch map[int]*squelchState
+	emit    func(*call.Event)
+}
+
+type VoiceManagerOption func(v *VoiceManager)
+
+func WithSystemName(name string) VoiceManagerOption {
+	return func(v *VoiceManager) {
+		v.systemName = name
+	}
 }
 
-func NewVoiceManager(systemID int) *VoiceManager {
-	return &VoiceManager{
+// WithCatalog attaches talkgroup catalog entries to the system's calls.
+func WithCatalog(c *catalog.Catalog) VoiceManagerOption {
+	return func(v *VoiceManager) {
+		v.catalog = c
+	}
+}
+
+// WithUnits records the units heard on the system and attaches their aliases to calls.
+func WithUnits(store *units.Store) VoiceManagerOption {
+	return func(v *VoiceManager) {
+		v.units = store
+	}
+}
+
+func NewVoiceManager(systemID int, emit func(*call.Event), opts ...VoiceManagerOption) *VoiceManager {
+	v := &VoiceManager{
 		talkGroupsByFreq:     make(map[int]types.TalkGroup),
 		talkGroupsByTGID:     make(map[int]types.TalkGroup),
 		talkGroupsBySourceID: make(map[int]types.TalkGroup),
 		systemID:             systemID,
 		purgeTime:            time.Second * 3,
+		calls:                make(map[int]*activeCall),
+		squelch:              make(map[int]*squelchState),
+		muted:                make(map[int]struct{}),
+		emit:                 emit,
+	}
+	for _, opt := range opts {
+		opt(v)
 	}
+	return v
+}
+
+// SetMuted mutes or unmutes a talkgroup, overriding its catalog action.
+func (v *VoiceManager) SetMuted(tgid int, muted bool) {
+	v.mu.Lock()
+	if muted {
+		v.muted[tgid] = struct{}{}
+	} else {
+		delete(v.muted, tgid)
+	}
+	v.mu.Unlock()
+}
+
+// MutedTalkGroups returns the talkgroups currently muted, in order.
+func (v *VoiceManager) MutedTalkGroups() []int {
+	v.mu.RLock()
+	ret := make([]int, 0, len(v.muted))
+	for tgid := range v.muted {
+		ret = append(ret, tgid)
+	}
+	v.mu.RUnlock()
+	sort.Ints(ret)
+	return ret
+}
+
+// UnitAlias returns the alias of a unit, or an empty string if it has none.
+func (v *VoiceManager) UnitAlias(id int) string {
+	if v.units == nil {
+		return ""
+	}
+	return v.units.Alias(id)
+}
+
+// ActionFor returns the catalog action for a talkgroup.  Muted talkgroups are ignored, and systems without a
+// catalog send every other talkgroup to every output.
+func (v *VoiceManager) ActionFor(tgid int) catalog.Action {
+	v.mu.RLock()
+	_, muted := v.muted[tgid]
+	v.mu.RUnlock()
+	if muted {
+		return catalog.ActionIgnore
+	}
+	if v.catalog == nil {
+		return catalog.ActionAll
+	}
+	return v.catalog.ActionFor(tgid)
 }
 
 func (v *VoiceManager) validateReturn(tg *types.TalkGroup) *types.TalkGroup {
@@ -85,7 +187,11 @@ func (v *VoiceManager) TalkGroupForSourceID(sid int) *types.TalkGroup {
 	return v.validateReturn(&tg)
 }
 
-func (v *VoiceManager) UpdateGroup(tgid, sourceID, freq int) {
+func (v *VoiceManager) UpdateGroup(update op25.DataPacket) {
+	tgid := int(update.DestTGID)
+	sourceID := int(update.SrcID)
+	freq := update.TargetFreq
+
 	v.mu.Lock()
 	tg, ok := v.talkGroupsByTGID[tgid]
 	if !ok {
@@ -109,5 +215,8 @@ func (v *VoiceManager) UpdateGroup(tgid, sourceID, freq int) {
 	v.talkGroupsByFreq[freq] = tg
 	v.talkGroupsByTGID[tgid] = tg
 	v.talkGroupsBySourceID[sourceID] = tg
+	events := v.trackGrant(update, tg.LastUpdate)
 	v.mu.Unlock()
+
+	v.emitAll(events)
 }